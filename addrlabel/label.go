@@ -0,0 +1,152 @@
+package addrlabel
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//地址标签
+type Label struct {
+	//标签名,如"our hot wallet"、"Binance 14"、"USDT contract"
+	Name string `json:"name"`
+	//分类,如"internal"、"exchange"、"token",可为空
+	Category string `json:"category,omitempty"`
+}
+
+//地址标签提供者,实现需保证并发安全
+type Provider interface {
+	//获取地址标签,未标注时返回nil
+	Label(addr common.Address) (*Label, error)
+}
+
+//内存标签
+type MemoryProvider struct {
+	lock   sync.RWMutex
+	labels map[common.Address]*Label
+}
+
+//构造一个新的内存标签
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		labels: make(map[common.Address]*Label),
+	}
+}
+
+func (provider *MemoryProvider) Label(addr common.Address) (*Label, error) {
+	provider.lock.RLock()
+	defer provider.lock.RUnlock()
+
+	return provider.labels[addr], nil
+}
+
+//设置地址标签,替换已有标签
+func (provider *MemoryProvider) Set(addr common.Address, label *Label) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	provider.labels[addr] = label
+}
+
+//移除地址标签
+func (provider *MemoryProvider) Remove(addrs ...common.Address) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	for _, addr := range addrs {
+		delete(provider.labels, addr)
+	}
+}
+
+//标注的地址数量
+func (provider *MemoryProvider) Len() int {
+	provider.lock.RLock()
+	defer provider.lock.RUnlock()
+
+	return len(provider.labels)
+}
+
+//从csv文件加载标签,每行为"address,name[,category]",以#开头的行和表头行(address,name...)忽略
+func LoadCSV(path string) (*MemoryProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	provider := NewMemoryProvider()
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(record[0], "address") {
+			continue
+		}
+		if len(record) < 2 || !common.IsHexAddress(record[0]) {
+			line, _ := reader.FieldPos(0)
+			return nil, errors.New("invalid label record at line " + strconv.Itoa(line))
+		}
+		label := &Label{Name: record[1]}
+		if len(record) > 2 {
+			label.Category = record[2]
+		}
+		provider.Set(common.HexToAddress(record[0]), label)
+	}
+
+	return provider, nil
+}
+
+//从json文件加载标签,格式为地址到标签的对象:{"0x...": {"name": "...", "category": "..."}}
+func LoadJSON(path string) (*MemoryProvider, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]*Label)
+	err = json.Unmarshal(bytes, &labels)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewMemoryProvider()
+	for addr, label := range labels {
+		if !common.IsHexAddress(addr) || label == nil {
+			return nil, errors.New("invalid label of address " + addr)
+		}
+		provider.Set(common.HexToAddress(addr), label)
+	}
+
+	return provider, nil
+}
+
+//按顺序查询多个标签提供者,返回第一个标签
+type MultiProvider []Provider
+
+func (providers MultiProvider) Label(addr common.Address) (*Label, error) {
+	for _, provider := range providers {
+		label, err := provider.Label(addr)
+		if err != nil {
+			return nil, err
+		}
+		if label != nil {
+			return label, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,82 @@
+package addrlabel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	hotWallet = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	exchange  = common.HexToAddress("0x00000000000000000000000000000000000000b1")
+	usdt      = common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7")
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func assertLabel(t *testing.T, provider Provider, addr common.Address, name string, category string) {
+	t.Helper()
+	label, err := provider.Label(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name == "" {
+		if label != nil {
+			t.Fatalf("%s: unexpected label %+v", addr.Hex(), label)
+		}
+		return
+	}
+	if label == nil || label.Name != name || label.Category != category {
+		t.Fatalf("%s: got %+v, want %s/%s", addr.Hex(), label, name, category)
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	path := writeFile(t, "labels.csv", `address,name,category
+# internal wallets
+0x00000000000000000000000000000000000000a1,our hot wallet,internal
+0x00000000000000000000000000000000000000B1, "Binance 14"
+`)
+	provider, err := LoadCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLabel(t, provider, hotWallet, "our hot wallet", "internal")
+	assertLabel(t, provider, exchange, "Binance 14", "")
+	assertLabel(t, provider, usdt, "", "")
+
+	_, err = LoadCSV(writeFile(t, "invalid.csv", "0x01,short address\n"))
+	if err == nil {
+		t.Fatal("expected invalid record error")
+	}
+}
+
+func TestLoadJSONAndMultiProvider(t *testing.T) {
+	path := writeFile(t, "labels.json", `{"0xdac17f958d2ee523a2206206994597c13d831ec7": {"name": "USDT contract", "category": "token"}}`)
+	fileLabels, err := LoadJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := NewMemoryProvider()
+	memory.Set(hotWallet, &Label{Name: "our hot wallet"})
+	memory.Set(usdt, &Label{Name: "tether"})
+	memory.Remove(usdt)
+
+	provider := MultiProvider{memory, fileLabels}
+	assertLabel(t, provider, hotWallet, "our hot wallet", "")
+	assertLabel(t, provider, usdt, "USDT contract", "token")
+	assertLabel(t, provider, exchange, "", "")
+	if memory.Len() != 1 {
+		t.Fatalf("len %d", memory.Len())
+	}
+}
@@ -0,0 +1,79 @@
+package addrlabel
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+)
+
+//基于sql表的标签,地址以小写hex存储;默认每次查询数据库,
+//开启缓存后查询结果(包括未标注)按地址缓存,表中的修改在缓存淘汰或清空后生效
+type SQLProvider struct {
+	db    *sql.DB
+	cache *lru.Cache[common.Address, *Label]
+
+	//查询语句,返回标签名和分类两列,默认语句不兼容时可自行修改
+	LabelQuery string
+}
+
+//构造基于sql表的标签,categoryColumn为空时分类为空,placeholder为驱动的参数占位符,如"?"或"$1"
+func NewSQLProvider(db *sql.DB, table string, addressColumn string, nameColumn string, categoryColumn string, placeholder string) *SQLProvider {
+	if categoryColumn == "" {
+		categoryColumn = "''"
+	}
+
+	return &SQLProvider{
+		db:         db,
+		LabelQuery: fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s = %s LIMIT 1", nameColumn, categoryColumn, table, addressColumn, placeholder),
+	}
+}
+
+//设置查询结果缓存的地址数,为0时不缓存
+func (provider *SQLProvider) SetCacheSize(size int) {
+	provider.cache = nil
+	if size > 0 {
+		provider.cache = lru.NewCache[common.Address, *Label](size)
+	}
+}
+
+//清空查询结果缓存,表中的修改立即生效
+func (provider *SQLProvider) ClearCache() {
+	if provider.cache != nil {
+		provider.cache.Purge()
+	}
+}
+
+func (provider *SQLProvider) Label(addr common.Address) (*Label, error) {
+	if provider.cache != nil {
+		if label, ok := provider.cache.Get(addr); ok {
+			return label, nil
+		}
+	}
+	label, err := provider.queryLabel(addr)
+	if err != nil {
+		return nil, err
+	}
+	if provider.cache != nil {
+		provider.cache.Add(addr, label)
+	}
+
+	return label, nil
+}
+
+func (provider *SQLProvider) queryLabel(addr common.Address) (*Label, error) {
+	label := &Label{}
+	var category sql.NullString
+	err := provider.db.QueryRow(provider.LabelQuery, strings.ToLower(addr.Hex())).Scan(&label.Name, &category)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	label.Category = category.String
+
+	return label, nil
+}
@@ -0,0 +1,65 @@
+package addrlabel
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openLabelDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "labels.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`CREATE TABLE address_labels (address TEXT NOT NULL PRIMARY KEY, name TEXT NOT NULL, category TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO address_labels VALUES (?, ?, ?), (?, ?, NULL)`,
+		strings.ToLower(usdt.Hex()), "USDT contract", "token", strings.ToLower(hotWallet.Hex()), "our hot wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSQLProvider(t *testing.T) {
+	db := openLabelDB(t)
+	provider := NewSQLProvider(db, "address_labels", "address", "name", "category", "?")
+	//地址按小写hex查询,分类为NULL时为空
+	assertLabel(t, provider, usdt, "USDT contract", "token")
+	assertLabel(t, provider, hotWallet, "our hot wallet", "")
+	assertLabel(t, provider, exchange, "", "")
+
+	noCategory := NewSQLProvider(db, "address_labels", "address", "name", "", "?")
+	assertLabel(t, noCategory, usdt, "USDT contract", "")
+}
+
+func TestSQLProviderCache(t *testing.T) {
+	db := openLabelDB(t)
+	provider := NewSQLProvider(db, "address_labels", "address", "name", "category", "?")
+	provider.SetCacheSize(16)
+	assertLabel(t, provider, usdt, "USDT contract", "token")
+	assertLabel(t, provider, exchange, "", "")
+
+	_, err := db.Exec(`UPDATE address_labels SET name = ? WHERE address = ?`, "tether", strings.ToLower(usdt.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO address_labels VALUES (?, ?, ?)`, strings.ToLower(exchange.Hex()), "Binance 14", "exchange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//标注和未标注的查询结果都已缓存
+	assertLabel(t, provider, usdt, "USDT contract", "token")
+	assertLabel(t, provider, exchange, "", "")
+
+	provider.ClearCache()
+	assertLabel(t, provider, usdt, "tether", "token")
+	assertLabel(t, provider, exchange, "Binance 14", "exchange")
+}
@@ -0,0 +1,98 @@
+package addrset
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//地址集合,实现需保证并发安全
+type AddressSet interface {
+	//是否包含地址
+	Contains(addr common.Address) (bool, error)
+
+	//添加地址
+	Add(addrs ...common.Address) error
+
+	//移除地址
+	Remove(addrs ...common.Address) error
+}
+
+//可枚举的地址集合
+type EnumerableSet interface {
+	AddressSet
+
+	//集合中的地址数量
+	Len() int
+
+	//遍历集合中的地址,fn返回false时停止遍历
+	Range(fn func(addr common.Address) bool)
+}
+
+//内存地址集合
+type MemorySet struct {
+	lock  sync.RWMutex
+	addrs map[common.Address]struct{}
+}
+
+//构造一个新的内存地址集合
+func NewMemorySet(addrs ...common.Address) *MemorySet {
+	set := &MemorySet{
+		addrs: make(map[common.Address]struct{}, len(addrs)),
+	}
+	for _, addr := range addrs {
+		set.addrs[addr] = struct{}{}
+	}
+
+	return set
+}
+
+func (set *MemorySet) Contains(addr common.Address) (bool, error) {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+
+	_, b := set.addrs[addr]
+	return b, nil
+}
+
+func (set *MemorySet) Add(addrs ...common.Address) error {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	for _, addr := range addrs {
+		set.addrs[addr] = struct{}{}
+	}
+
+	return nil
+}
+
+func (set *MemorySet) Remove(addrs ...common.Address) error {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	for _, addr := range addrs {
+		delete(set.addrs, addr)
+	}
+
+	return nil
+}
+
+//集合中的地址数量
+func (set *MemorySet) Len() int {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+
+	return len(set.addrs)
+}
+
+//遍历集合中的地址,fn返回false时停止遍历
+func (set *MemorySet) Range(fn func(addr common.Address) bool) {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+
+	for addr := range set.addrs {
+		if !fn(addr) {
+			return
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package addrset
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testAddress(i uint64) common.Address {
+	var addr common.Address
+	binary.BigEndian.PutUint64(addr[12:], i)
+
+	return addr
+}
+
+func TestMemorySet(t *testing.T) {
+	set := NewMemorySet(testAddress(1))
+	set.Add(testAddress(2), testAddress(3))
+	set.Remove(testAddress(3))
+	for i, want := range []bool{false, true, true, false} {
+		b, err := set.Contains(testAddress(uint64(i)))
+		if err != nil || b != want {
+			t.Fatalf("address %d: got %v, want %v", i, b, want)
+		}
+	}
+	if set.Len() != 2 {
+		t.Fatalf("len %d", set.Len())
+	}
+}
+
+func TestBloomSet(t *testing.T) {
+	set := NewBloomSet(NewMemorySet(testAddress(1)), NewBloomFilter(1000, 0.01), testAddress(1))
+	set.Add(testAddress(2))
+	for i, want := range []bool{false, true, true} {
+		b, err := set.Contains(testAddress(uint64(i)))
+		if err != nil || b != want {
+			t.Fatalf("address %d: got %v, want %v", i, b, want)
+		}
+	}
+
+	//移除后bloom仍可能命中,由后端集合确认
+	set.Remove(testAddress(2))
+	b, _ := set.Contains(testAddress(2))
+	if b {
+		t.Fatal("removed address still contained")
+	}
+}
+
+func TestBloomSetRebuild(t *testing.T) {
+	backing := NewMemorySet()
+	set := NewBloomSet(backing, NewBloomFilter(1000, 0.01))
+	set.Add(testAddress(1), testAddress(2))
+	set.Remove(testAddress(2))
+	err := set.Rebuild(func() ([]common.Address, error) {
+		return []common.Address{testAddress(1)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.filter.MayContain(testAddress(1)) || set.filter.MayContain(testAddress(2)) {
+		t.Fatal("rebuilt filter does not match the backing set")
+	}
+}
+
+//重建期间添加的地址不能丢失
+func TestBloomSetConcurrentAddAndRebuild(t *testing.T) {
+	n := uint64(20000)
+	backing := NewMemorySet()
+	set := NewBloomSet(backing, NewBloomFilter(n, 0.01))
+	snapshot := func() ([]common.Address, error) {
+		var addrs []common.Address
+		backing.Range(func(addr common.Address) bool {
+			addrs = append(addrs, addr)
+			return true
+		})
+		return addrs, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := uint64(0); i < n; i++ {
+			set.Add(testAddress(i))
+		}
+	}()
+	rebuilding := true
+	for rebuilding {
+		select {
+		case <-done:
+			rebuilding = false
+		default:
+		}
+		err := set.Rebuild(snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(0); i < n; i++ {
+		b, err := set.Contains(testAddress(i))
+		if err != nil || !b {
+			t.Fatalf("address %d lost after concurrent rebuild", i)
+		}
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	n := uint64(100000)
+	filter := NewBloomFilter(n, 0.01)
+	for i := uint64(0); i < n; i++ {
+		filter.Add(testAddress(i))
+	}
+	falsePositives := 0
+	for i := n; i < 2*n; i++ {
+		if filter.MayContain(testAddress(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / float64(n); rate > 0.02 {
+		t.Fatalf("false positive rate %f", rate)
+	}
+}
+
+func benchmarkContains(b *testing.B, set AddressSet, n uint64) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		//一半命中一半未命中
+		set.Contains(testAddress(uint64(i) % (2 * n)))
+	}
+}
+
+func newMemorySet(n uint64) *MemorySet {
+	set := NewMemorySet()
+	for i := uint64(0); i < n; i++ {
+		set.Add(testAddress(i))
+	}
+
+	return set
+}
+
+func BenchmarkMemorySetContains1M(b *testing.B) {
+	n := uint64(1000000)
+	benchmarkContains(b, newMemorySet(n), n)
+}
+
+func BenchmarkBloomSetContains1M(b *testing.B) {
+	n := uint64(1000000)
+	memorySet := newMemorySet(n)
+	set := NewBloomSet(memorySet, NewBloomFilter(n, 0.01))
+	memorySet.Range(func(addr common.Address) bool {
+		set.filter.Add(addr)
+		return true
+	})
+	benchmarkContains(b, set, n)
+}
+
+//3000万gas的主网区块最多容纳的普通转账数(每笔21000 gas)
+const mainnetBlockTxs = 30000000 / 21000
+
+var (
+	set10MOnce sync.Once
+	set10M     *MemorySet
+	bloom10M   *BloomSet
+)
+
+//1000万地址的内存集合和布隆集合,多个基准测试共用,只构造一次
+func sets10M() (*MemorySet, *BloomSet) {
+	set10MOnce.Do(func() {
+		n := uint64(10000000)
+		set10M = newMemorySet(n)
+		bloom10M = NewBloomSet(set10M, NewBloomFilter(n, 0.01))
+		set10M.Range(func(addr common.Address) bool {
+			bloom10M.filter.Add(addr)
+			return true
+		})
+	})
+
+	return set10M, bloom10M
+}
+
+//按扫描器的方式匹配一个满载主网区块的所有交易(from或to命中),约1%的交易命中
+func benchmarkBlock(b *testing.B, froms AddressSet, tos AddressSet, n uint64) {
+	type tx struct {
+		from common.Address
+		to   common.Address
+	}
+	txs := make([]tx, mainnetBlockTxs)
+	for i := range txs {
+		txs[i] = tx{from: testAddress(n + uint64(2*i)), to: testAddress(n + uint64(2*i+1))}
+		if i%100 == 0 {
+			txs[i].to = testAddress(uint64(i))
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, tx := range txs {
+			matched, _ := froms.Contains(tx.from)
+			if !matched {
+				tos.Contains(tx.to)
+			}
+		}
+	}
+}
+
+func BenchmarkMemorySetBlock10M(b *testing.B) {
+	set, _ := sets10M()
+	benchmarkBlock(b, set, set, 10000000)
+}
+
+func BenchmarkBloomSetBlock10M(b *testing.B) {
+	_, set := sets10M()
+	benchmarkBlock(b, set, set, 10000000)
+}
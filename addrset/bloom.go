@@ -0,0 +1,174 @@
+package addrset
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//地址布隆过滤器,只会误判存在,不会误判不存在
+type BloomFilter struct {
+	lock      sync.RWMutex
+	bits      []uint64
+	bitCount  uint64
+	hashCount uint64
+	//重建期间添加的地址的位,重建完成时合并到新的位,未在重建时为nil
+	rebuildBits []uint64
+}
+
+//根据预期地址数量和误判率构造布隆过滤器
+func NewBloomFilter(expectedItems uint64, falsePositiveRate float64) *BloomFilter {
+	if expectedItems == 0 {
+		expectedItems = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	bitCount := uint64(math.Ceil(-float64(expectedItems) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if bitCount < 64 {
+		bitCount = 64
+	}
+	hashCount := uint64(math.Round(float64(bitCount) / float64(expectedItems) * math.Ln2))
+	if hashCount < 1 {
+		hashCount = 1
+	}
+
+	return &BloomFilter{
+		bits:      make([]uint64, (bitCount+63)/64),
+		bitCount:  bitCount,
+		hashCount: hashCount,
+	}
+}
+
+//添加地址
+func (filter *BloomFilter) Add(addr common.Address) {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	h1, h2 := bloomHashes(addr)
+	for i := uint64(0); i < filter.hashCount; i++ {
+		index := (h1 + i*h2) % filter.bitCount
+		filter.bits[index/64] |= 1 << (index % 64)
+		if filter.rebuildBits != nil {
+			filter.rebuildBits[index/64] |= 1 << (index % 64)
+		}
+	}
+}
+
+//地址是否可能存在
+func (filter *BloomFilter) MayContain(addr common.Address) bool {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	h1, h2 := bloomHashes(addr)
+	for i := uint64(0); i < filter.hashCount; i++ {
+		index := (h1 + i*h2) % filter.bitCount
+		if filter.bits[index/64]&(1<<(index%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+//清空过滤器
+func (filter *BloomFilter) Reset() {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	for i := range filter.bits {
+		filter.bits[i] = 0
+	}
+}
+
+func bloomHashes(addr common.Address) (uint64, uint64) {
+	hasher := fnv.New64a()
+	hasher.Write(addr.Bytes())
+	h1 := hasher.Sum64()
+	h2 := binary.BigEndian.Uint64(addr[4:12]) ^ binary.BigEndian.Uint64(addr[12:20])
+
+	return h1, h2 | 1
+}
+
+//带布隆过滤器预检查的地址集合,过滤器排除的地址不会访问底层集合
+type BloomSet struct {
+	filter  *BloomFilter
+	backing AddressSet
+}
+
+//构造带布隆过滤器预检查的地址集合,existing为底层集合中已有的地址
+func NewBloomSet(backing AddressSet, filter *BloomFilter, existing ...common.Address) *BloomSet {
+	for _, addr := range existing {
+		filter.Add(addr)
+	}
+
+	return &BloomSet{
+		filter:  filter,
+		backing: backing,
+	}
+}
+
+func (set *BloomSet) Contains(addr common.Address) (bool, error) {
+	if !set.filter.MayContain(addr) {
+		return false, nil
+	}
+
+	return set.backing.Contains(addr)
+}
+
+func (set *BloomSet) Add(addrs ...common.Address) error {
+	for _, addr := range addrs {
+		set.filter.Add(addr)
+	}
+
+	return set.backing.Add(addrs...)
+}
+
+//移除地址,布隆过滤器中的位不会清除,移除较多时可调用Rebuild
+func (set *BloomSet) Remove(addrs ...common.Address) error {
+	return set.backing.Remove(addrs...)
+}
+
+//使用底层集合当前的全部地址重建布隆过滤器,清除已移除地址的位;snapshot返回底层集合的全部地址,
+//在重建开始后调用,重建期间添加的地址会合并到新的过滤器,查询不受影响;同时只能进行一次重建
+func (set *BloomSet) Rebuild(snapshot func() ([]common.Address, error)) error {
+	filter := set.filter
+	filter.lock.Lock()
+	if filter.rebuildBits != nil {
+		filter.lock.Unlock()
+		return errors.New("bloom filter is already rebuilding")
+	}
+	filter.rebuildBits = make([]uint64, len(filter.bits))
+	rebuilt := &BloomFilter{
+		bits:      make([]uint64, len(filter.bits)),
+		bitCount:  filter.bitCount,
+		hashCount: filter.hashCount,
+	}
+	filter.lock.Unlock()
+
+	addrs, err := snapshot()
+	if err != nil {
+		filter.lock.Lock()
+		filter.rebuildBits = nil
+		filter.lock.Unlock()
+		return err
+	}
+	for _, addr := range addrs {
+		rebuilt.Add(addr)
+	}
+
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	for i := range rebuilt.bits {
+		rebuilt.bits[i] |= filter.rebuildBits[i]
+	}
+	filter.bits = rebuilt.bits
+	filter.rebuildBits = nil
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package addrset
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//redis集合命令客户端,可由任意redis客户端库适配实现
+type RedisSetClient interface {
+	SIsMember(ctx context.Context, key string, member string) (bool, error)
+	SAdd(ctx context.Context, key string, members ...string) error
+	SRem(ctx context.Context, key string, members ...string) error
+}
+
+//基于redis set的地址集合,地址以小写hex存储
+type RedisSet struct {
+	client RedisSetClient
+	key    string
+}
+
+//构造基于redis set的地址集合
+func NewRedisSet(client RedisSetClient, key string) *RedisSet {
+	return &RedisSet{
+		client: client,
+		key:    key,
+	}
+}
+
+func (set *RedisSet) Contains(addr common.Address) (bool, error) {
+	return set.client.SIsMember(context.Background(), set.key, addressKey(addr))
+}
+
+func (set *RedisSet) Add(addrs ...common.Address) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	return set.client.SAdd(context.Background(), set.key, addressKeys(addrs)...)
+}
+
+func (set *RedisSet) Remove(addrs ...common.Address) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	return set.client.SRem(context.Background(), set.key, addressKeys(addrs)...)
+}
+
+//基于sql表的地址集合,地址以小写hex存储,表需在地址列上有唯一索引
+type SQLSet struct {
+	db *sql.DB
+
+	//查询/插入/删除语句,默认语句不兼容时可自行修改
+	ContainsQuery string
+	InsertQuery   string
+	DeleteQuery   string
+}
+
+//构造基于sql表的地址集合,placeholder为驱动的参数占位符,如"?"或"$1"
+func NewSQLSet(db *sql.DB, table string, column string, placeholder string) *SQLSet {
+	return &SQLSet{
+		db:            db,
+		ContainsQuery: fmt.Sprintf("SELECT 1 FROM %s WHERE %s = %s LIMIT 1", table, column, placeholder),
+		InsertQuery:   fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING", table, column, placeholder),
+		DeleteQuery:   fmt.Sprintf("DELETE FROM %s WHERE %s = %s", table, column, placeholder),
+	}
+}
+
+func (set *SQLSet) Contains(addr common.Address) (bool, error) {
+	var one int
+	err := set.db.QueryRow(set.ContainsQuery, addressKey(addr)).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (set *SQLSet) Add(addrs ...common.Address) error {
+	return set.exec(set.InsertQuery, addrs)
+}
+
+func (set *SQLSet) Remove(addrs ...common.Address) error {
+	return set.exec(set.DeleteQuery, addrs)
+}
+
+func (set *SQLSet) exec(query string, addrs []common.Address) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	tx, err := set.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, addr := range addrs {
+		_, err = stmt.Exec(addressKey(addr))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func addressKey(addr common.Address) string {
+	return strings.ToLower(addr.Hex())
+}
+
+func addressKeys(addrs []common.Address) []string {
+	keys := make([]string, len(addrs))
+	for i, addr := range addrs {
+		keys[i] = addressKey(addr)
+	}
+
+	return keys
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/warrior21st/ethblockscanner/rpcreplay"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+//分片回溯扫描命令,匹配的tx以json行写入-out文件(默认与日志一起输出到标准输出)
+//
+//	backfill -endpoints https://rpc1,https://rpc2 -tos 0x... -from 12000000 -to 12100000 -progress backfill.json -out txs.jsonl
+//
+//-record保存所有rpc响应,-replay离线回放,用于复现问题;多个分片并行时相同调用(如eth_blockNumber)的回放顺序
+//可能与录制时不同,需完全复现时录制和回放均使用-workers 1:
+//
+//	backfill -endpoints https://rpc1 -tos 0x... -from 12000000 -to 12000100 -workers 1 -record incident.jsonl
+//	backfill -replay incident.jsonl -tos 0x... -from 12000000 -to 12000100 -workers 1
+func main() {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	err := run(os.Args[1:], os.Stdout, interrupt)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		exit(err)
+	}
+}
+
+//解析参数并运行回溯扫描,stdout为未指定-out时tx的输出,收到interrupt时停止扫描
+func run(args []string, stdout io.Writer, interrupt <-chan os.Signal) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	endpoints := flags.String("endpoints", "", "comma separated rpc endpoints")
+	froms := flags.String("froms", "", "comma separated interested from addresses")
+	tos := flags.String("tos", "", "comma separated interested to addresses")
+	fromBlock := flags.Uint64("from", 0, "first block to backfill")
+	toBlock := flags.Uint64("to", 0, "last block to backfill, 0 for the latest block")
+	shardSize := flags.Uint64("shard", 10000, "blocks per shard")
+	concurrency := flags.Int("workers", 0, "shards processed in parallel, 0 for the number of endpoints")
+	progressPath := flags.String("progress", "", "json file recording shard progress, resumes interrupted runs")
+	live := flags.Bool("live", false, "backfill up to the latest block, then keep scanning new blocks")
+	outPath := flags.String("out", "", "file to append matched txs to as json lines, default stdout")
+	recordPath := flags.String("record", "", "archive file to record all rpc responses to")
+	replayPath := flags.String("replay", "", "archive file to replay rpc responses from instead of -endpoints")
+	//参数错误时flags已输出错误和用法
+	err := flags.Parse(args)
+	if err != nil {
+		return flag.ErrHelp
+	}
+
+	if (*endpoints == "" && *replayPath == "") || (*froms == "" && *tos == "") {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	if *live && *toBlock != 0 {
+		return fmt.Errorf("-to can not be used with -live")
+	}
+	if *toBlock != 0 && *fromBlock > *toBlock {
+		return fmt.Errorf("-from %d is after -to %d", *fromBlock, *toBlock)
+	}
+
+	endpointList := splitList(*endpoints)
+	if *replayPath != "" {
+		replayer, err := rpcreplay.NewReplayer(*replayPath)
+		if err != nil {
+			return err
+		}
+		defer replayer.Close()
+		endpointList = []string{replayer.URL()}
+	}
+
+	out := stdout
+	if *outPath != "" {
+		file, err := os.OpenFile(*outPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	var outLock sync.Mutex
+	watcher := txscanner.NewSimpleTxWatcher(endpointList, 0, 3*time.Second, func(tx *txscanner.TxInfo) error {
+		bytes, err := tx.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		outLock.Lock()
+		defer outLock.Unlock()
+		_, err = fmt.Fprintln(out, string(bytes))
+
+		return err
+	})
+	if *replayPath == "" && *recordPath != "" {
+		archive, err := rpcreplay.CreateArchive(*recordPath)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		watcher.SetRecordArchive(archive)
+		defer watcher.SetRecordArchive(nil)
+	}
+	for _, from := range splitList(*froms) {
+		err := watcher.AddInterestedFrom(from)
+		if err != nil {
+			return err
+		}
+	}
+	for _, to := range splitList(*tos) {
+		err := watcher.AddInterestedTo(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	backfiller := txscanner.NewBackfiller(watcher, *shardSize)
+	backfiller.SetConcurrency(*concurrency)
+	if *progressPath != "" {
+		progress, err := txscanner.NewFileBackfillProgress(*progressPath)
+		if err != nil {
+			return err
+		}
+		backfiller.SetProgressStore(progress)
+	}
+
+	scanner := txscanner.NewTxScanner(watcher)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupt:
+			backfiller.Stop()
+			scanner.Stop()
+		case <-done:
+		}
+	}()
+
+	if *live {
+		return backfiller.RunThenScan(scanner, *fromBlock)
+	}
+
+	//-to为0时扫描到当前最新区块
+	return backfiller.Run(*fromBlock, *toBlock)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+)
+
+var depositAddr = common.HexToAddress("0x00000000000000000000000000000000000000d1")
+
+//可并发写入和读取的输出
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return strings.Fields(b.buf.String())
+}
+
+func TestRunValidatesFlags(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-tos", depositAddr.Hex()}, flag.ErrHelp.Error()},
+		{[]string{"-endpoints", "http://127.0.0.1:1"}, flag.ErrHelp.Error()},
+		{[]string{"-endpoints", "http://127.0.0.1:1", "-tos", depositAddr.Hex(), "-from", "10", "-to", "5"}, "-from 10 is after -to 5"},
+		{[]string{"-endpoints", "http://127.0.0.1:1", "-tos", depositAddr.Hex(), "-live", "-to", "5"}, "-to can not be used with -live"},
+		{[]string{"-unknown"}, flag.ErrHelp.Error()},
+	} {
+		err := run(c.args, &syncBuffer{}, nil)
+		if err == nil || err.Error() != c.err {
+			t.Fatalf("%v: got error %v, want %s", c.args, err, c.err)
+		}
+	}
+}
+
+func TestRunToLatestBlock(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.MineN(3)
+	chain.Transfer(2, depositAddr, big.NewInt(1))
+	chain.Mine()
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+
+	//-to为0时回溯到最新区块后结束
+	out := &syncBuffer{}
+	err := run([]string{"-endpoints", server.URL(), "-tos", depositAddr.Hex(), "-from", "1", "-shard", "2"}, out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.lines()) != 2 {
+		t.Fatalf("got %d txs, want 2", len(out.lines()))
+	}
+}
+
+func TestRunLiveKeepsScanning(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.MineN(2)
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+
+	out := &syncBuffer{}
+	interrupt := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- run([]string{"-endpoints", server.URL(), "-tos", depositAddr.Hex(), "-from", "1", "-live"}, out, interrupt)
+	}()
+	waitLines(t, out, 1)
+
+	//回溯完成后继续实时扫描新区块,收到中断后结束
+	chain.Transfer(2, depositAddr, big.NewInt(1))
+	chain.Mine()
+	waitLines(t, out, 2)
+	interrupt <- os.Interrupt
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("live scan did not stop after interrupt")
+	}
+}
+
+func waitLines(t *testing.T, out *syncBuffer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for len(out.lines()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %d txs, got %d", n, len(out.lines()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
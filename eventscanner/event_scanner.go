@@ -0,0 +1,364 @@
+package eventscanner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+type EventWatcher interface {
+	//获取开始扫描的区块号
+	GetScanStartBlock() uint64
+
+	//获取节点地址
+	GetEthClients() ([]*ethclient.Client, error)
+
+	//是否是需要解析的tx
+	IsInterestedTx(from string, to string) bool
+
+	//获取关注的log地址
+	GetInterestedAddresses() []common.Address
+
+	//获取关注的log topic0
+	GetInterestedTopics() []common.Hash
+
+	//是否是需要解析的log
+	IsInterestedLog(address string, topic0 string) bool
+
+	//区块事件回调处理方法,返回错误时该区块会重新扫描
+	Callback(event *BlockEvent) error
+
+	//获取扫描间隔
+	GetScanInterval() time.Duration
+}
+
+//支持扫描进度确认的watcher,区块事件回调成功后确认该区块,返回错误时重新扫描该区块
+type CheckpointEventWatcher interface {
+	EventWatcher
+
+	//更新已扫描的最大区块号
+	UpdateMaxScanedBlock(blockNumber uint64) error
+}
+
+//标注地址的watcher,扫描器在区块事件回调前标注事件中所有tx和log的地址,标注出错时重新扫描该区块
+type EnrichEventWatcher interface {
+	EventWatcher
+
+	//获取地址标注,为nil时不标注
+	GetEnricher() *txscanner.Enricher
+}
+
+//区块事件,包含一个区块内匹配的全部tx(含receipt)和log
+type BlockEvent struct {
+	Header        *types.Header
+	BlockNumber   uint64
+	BlockHash     string
+	BlockUnixSecs uint64
+
+	//匹配tx规则或包含匹配log的tx
+	Txs []*txscanner.TxInfo
+	//匹配log规则的log
+	Logs []*types.Log
+	//Logs中log合约地址的标注信息,按小写地址索引,未开启标注时为nil
+	LogAddressInfos map[string]*txscanner.AddressInfo
+}
+
+//区块事件扫描器,同一进程可运行多个实例
+type EventScanner struct {
+	lastScanedBlockNumber uint64
+
+	watcher          EventWatcher
+	clients          []*ethclient.Client
+	signer           types.Signer
+	profile          *txscanner.ChainProfile
+	clientSleepTimes map[int]int64
+	stop             chan struct{}
+	stopOnce         sync.Once
+}
+
+//构造区块事件扫描器
+func NewEventScanner(watcher EventWatcher) *EventScanner {
+	return &EventScanner{
+		watcher: watcher,
+		stop:    make(chan struct{}),
+	}
+}
+
+//开始扫描
+func StartScanEvents(watcher EventWatcher) error {
+	return NewEventScanner(watcher).Start()
+}
+
+//开始扫描,阻塞直到调用Stop;出错后再次调用时从上次扫描到的区块继续
+func (scanner *EventScanner) Start() error {
+	LogToConsole("eth event scanner starting...")
+	watcher := scanner.watcher
+	lastScanedBlockNumber := scanner.GetLastScanedBlock()
+	if startBlock := watcher.GetScanStartBlock(); lastScanedBlockNumber == 0 && startBlock > 0 {
+		lastScanedBlockNumber = startBlock - 1
+	}
+	clients, err := watcher.GetEthClients()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(clients); i++ {
+		defer clients[i].Close()
+	}
+
+	chainID, err := clients[0].ChainID(context.Background())
+	if err != nil {
+		return err
+	}
+	scanner.profile = txscanner.GetChainProfile(chainID)
+	if profileWatcher, ok := watcher.(interface {
+		GetChainProfile() *txscanner.ChainProfile
+	}); ok && profileWatcher.GetChainProfile() != nil {
+		scanner.profile = profileWatcher.GetChainProfile()
+	}
+	LogToConsole("chainID:" + chainID.String() + ",profile:" + scanner.profile.Name + ",scaning...")
+
+	scanner.clients = clients
+	scanner.signer = txscanner.NewSigner(chainID)
+	scanner.clientSleepTimes = make(map[int]int64)
+	scanInterval := watcher.GetScanInterval()
+	if scanInterval <= time.Millisecond {
+		scanInterval = 0
+	}
+	errCount := 0
+	for !scanner.isStopped() {
+		scaned, err := scanner.scanBlock(lastScanedBlockNumber + 1)
+		if err != nil {
+			LogToConsole("scaning block " + strconv.FormatUint(lastScanedBlockNumber+1, 10) + " error: " + err.Error())
+			errCount++
+		} else if scaned {
+			errCount = 0
+			if checkpointWatcher, ok := watcher.(CheckpointEventWatcher); ok {
+				err = checkpointWatcher.UpdateMaxScanedBlock(lastScanedBlockNumber + 1)
+				if err != nil {
+					LogToConsole("update max scaned block error: " + err.Error() + ",rescan block " + strconv.FormatUint(lastScanedBlockNumber+1, 10) + ".")
+					errCount++
+				}
+			}
+			if err == nil {
+				lastScanedBlockNumber++
+				atomic.StoreUint64(&scanner.lastScanedBlockNumber, lastScanedBlockNumber)
+				continue
+			}
+		}
+
+		//如果连续报错达到10次，则线程睡眠30秒后继续
+		if errCount == 10 {
+			LogToConsole("scaning block continuous error " + strconv.Itoa(errCount) + " times,sleep 30s...")
+			scanner.sleep(30 * time.Second)
+			errCount = 0
+		}
+
+		if scanInterval > 0 {
+			scanner.sleep(scanInterval)
+		}
+	}
+
+	return nil
+}
+
+//扫描单个区块,区块未出块时返回false
+func (scanner *EventScanner) scanBlock(blockNumber uint64) (bool, error) {
+	avaiIndexes := txscanner.RebuildAvaiIndexes(len(scanner.clients), &scanner.clientSleepTimes)
+	if len(avaiIndexes) == 0 {
+		return false, nil
+	}
+	index := avaiIndexes[blockNumber%uint64(len(avaiIndexes))]
+	client := scanner.clients[index]
+	LogToConsole("scaning block " + strconv.FormatUint(blockNumber, 10) + " events on client_" + strconv.Itoa(index) + "...")
+
+	block, err := txscanner.GetBlock(client, blockNumber, scanner.profile, scanner.signer)
+	if err != nil {
+		if err.Error() == "not found" {
+			LogToConsole("block " + strconv.FormatUint(blockNumber, 10) + " is not mined or not synced on client_" + strconv.Itoa(index) + ".")
+			return false, nil
+		}
+		scanner.sleepClient(index)
+		return false, err
+	}
+	if block == nil {
+		return false, nil
+	}
+
+	event := &BlockEvent{
+		Header:        block.Header,
+		BlockNumber:   blockNumber,
+		BlockHash:     strings.ToLower(block.Hash.Hex()),
+		BlockUnixSecs: block.Header.Time,
+	}
+	logTxs, err := scanner.matchLogs(client, block, event)
+	if err != nil {
+		scanner.sleepClient(index)
+		return false, err
+	}
+
+	for _, tx := range block.Txs {
+		interested := logTxs[tx.Hash]
+		if !interested && tx.To != nil {
+			interested, err = scanner.isInterestedTx(tx.From, *tx.To)
+			if err != nil {
+				return false, err
+			}
+		}
+		if !interested {
+			continue
+		}
+
+		txInfo := tx.TxInfo(block)
+		err = txscanner.LoadReceipt(client, txInfo, scanner.profile)
+		if err != nil {
+			scanner.sleepClient(index)
+			return false, err
+		}
+		event.Txs = append(event.Txs, txInfo)
+	}
+
+	if len(event.Txs) == 0 && len(event.Logs) == 0 {
+		return true, nil
+	}
+	err = scanner.enrich(client, event)
+	if err != nil {
+		scanner.sleepClient(index)
+		return false, err
+	}
+	err = scanner.watcher.Callback(event)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//查询区块内匹配的log并写入event,返回包含匹配log的tx hash
+func (scanner *EventScanner) matchLogs(client *ethclient.Client, block *txscanner.Block, event *BlockEvent) (map[common.Hash]bool, error) {
+	logTxs := make(map[common.Hash]bool)
+	addresses := scanner.watcher.GetInterestedAddresses()
+	topics := scanner.watcher.GetInterestedTopics()
+	if len(addresses) == 0 && len(topics) == 0 {
+		return logTxs, nil
+	}
+
+	blockHash := block.Hash
+	filter := ethereum.FilterQuery{
+		BlockHash: &blockHash,
+		Addresses: addresses,
+	}
+	if len(topics) > 0 {
+		filter.Topics = [][]common.Hash{topics}
+	}
+	logs, err := client.FilterLogs(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	for i := range logs {
+		if len(logs[i].Topics) == 0 || !scanner.watcher.IsInterestedLog(logs[i].Address.Hex(), logs[i].Topics[0].Hex()) {
+			continue
+		}
+		event.Logs = append(event.Logs, &logs[i])
+		logTxs[logs[i].TxHash] = true
+	}
+
+	return logTxs, nil
+}
+
+//标注区块事件中tx和log的地址,watcher未开启标注时不处理
+func (scanner *EventScanner) enrich(client *ethclient.Client, event *BlockEvent) error {
+	enrichWatcher, ok := scanner.watcher.(EnrichEventWatcher)
+	if !ok || enrichWatcher.GetEnricher() == nil {
+		return nil
+	}
+	enricher := enrichWatcher.GetEnricher()
+	for _, tx := range event.Txs {
+		err := enricher.Enrich(client, tx)
+		if err != nil {
+			return err
+		}
+	}
+	if len(event.Logs) == 0 {
+		return nil
+	}
+	event.LogAddressInfos = make(map[string]*txscanner.AddressInfo)
+	for _, log := range event.Logs {
+		key := strings.ToLower(log.Address.Hex())
+		if event.LogAddressInfos[key] != nil {
+			continue
+		}
+		info, err := enricher.EnrichLog(log)
+		if err != nil {
+			return err
+		}
+		event.LogAddressInfos[key] = info
+	}
+
+	return nil
+}
+
+func (scanner *EventScanner) isInterestedTx(from common.Address, to common.Address) (bool, error) {
+	if addressWatcher, ok := scanner.watcher.(interface {
+		IsInterestedTxAddress(from common.Address, to common.Address) (bool, error)
+	}); ok {
+		return addressWatcher.IsInterestedTxAddress(from, to)
+	}
+
+	return scanner.watcher.IsInterestedTx(strings.ToLower(hexutil.Encode(from.Bytes())), strings.ToLower(hexutil.Encode(to.Bytes()))), nil
+}
+
+func (scanner *EventScanner) sleepClient(index int) {
+	errorSleepSeconds := int64(10)
+	scanner.clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
+	LogToConsole(fmt.Sprintf("client_%d response error,sleep %ds.", index, errorSleepSeconds))
+}
+
+//获取已扫描的最大区块号
+func (scanner *EventScanner) GetLastScanedBlock() uint64 {
+	return atomic.LoadUint64(&scanner.lastScanedBlockNumber)
+}
+
+//停止扫描,Start在当前区块处理完后返回
+func (scanner *EventScanner) Stop() {
+	scanner.stopOnce.Do(func() { close(scanner.stop) })
+}
+
+//重置停止状态,Stop后需要再次Start时调用,只能在Start返回后调用
+func (scanner *EventScanner) Reset() {
+	scanner.stop = make(chan struct{})
+	scanner.stopOnce = sync.Once{}
+}
+
+func (scanner *EventScanner) isStopped() bool {
+	select {
+	case <-scanner.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//睡眠指定时间,调用Stop时提前返回
+func (scanner *EventScanner) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-scanner.stop:
+	case <-timer.C:
+	}
+}
+
+func LogToConsole(msg string) {
+	fmt.Println(time.Now().Add(8*time.Hour).Format("2006-01-02 15:04:05") + "  " + msg)
+}
@@ -0,0 +1,170 @@
+package eventscanner_test
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/addrlabel"
+	"github.com/warrior21st/ethblockscanner/eventscanner"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+const scanTimeout = 20 * time.Second
+
+var (
+	depositAddr   = common.HexToAddress("0x00000000000000000000000000000000000000d1")
+	tokenAddr     = common.HexToAddress("0x00000000000000000000000000000000000000e1")
+	otherAddr     = common.HexToAddress("0x00000000000000000000000000000000000000f1")
+	transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+)
+
+func newWatcher(t *testing.T, chain *fakechain.Chain, callback func(*eventscanner.BlockEvent) error) *eventscanner.SimpleEventWatcher {
+	_, endpoints, closeServers := fakechain.NewServers(chain, 1)
+	t.Cleanup(closeServers)
+	watcher := eventscanner.NewSimpleEventWatcher(endpoints, 1, 10*time.Millisecond, callback)
+	watcher.AddInterestedTo(depositAddr.Hex())
+	watcher.AddInterestedParams(tokenAddr.Hex(), transferTopic.Hex())
+
+	return watcher
+}
+
+func emit(chain *fakechain.Chain, from int, token common.Address) *types.Transaction {
+	return chain.AddTx(from, &token, nil, nil, fakechain.NewLog(token, []common.Hash{transferTopic}, nil))
+}
+
+func assertTxs(t *testing.T, event *eventscanner.BlockEvent, want ...*types.Transaction) {
+	t.Helper()
+	if len(event.Txs) != len(want) {
+		t.Fatalf("block %d: got %d txs, want %d", event.BlockNumber, len(event.Txs), len(want))
+	}
+	for i, tx := range want {
+		if common.HexToHash(event.Txs[i].TxHash) != tx.Hash() {
+			t.Fatalf("block %d tx %d: got %s, want %s", event.BlockNumber, i, event.Txs[i].TxHash, tx.Hash().Hex())
+		}
+	}
+}
+
+func TestScanEvents(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(1))
+	transfer := emit(chain, 2, tokenAddr)
+	emit(chain, 3, otherAddr)
+	chain.Mine()
+	chain.Transfer(1, otherAddr, big.NewInt(1))
+	chain.Mine()
+	later := emit(chain, 1, tokenAddr)
+	chain.Mine()
+
+	var events []*eventscanner.BlockEvent
+	watcher := newWatcher(t, chain, func(event *eventscanner.BlockEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	var checkpoints []uint64
+	watcher.SetUpdateMaxScanedBlock(func(blockNumber uint64) error {
+		checkpoints = append(checkpoints, blockNumber)
+		return nil
+	})
+	err := fakechain.ScanTo(eventscanner.NewEventScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//没有匹配tx和log的区块不回调,但确认扫描进度
+	if len(events) != 2 || events[0].BlockNumber != 1 || events[1].BlockNumber != 3 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	assertTxs(t, events[0], deposit, transfer)
+	if events[0].Txs[1].Receipt() == nil || len(events[0].Logs) != 1 || events[0].Logs[0].TxHash != transfer.Hash() {
+		t.Fatalf("unexpected event %+v", events[0])
+	}
+	assertTxs(t, events[1], later)
+	if events[0].LogAddressInfos != nil {
+		t.Fatal("unexpected log address infos without enricher")
+	}
+	if len(checkpoints) != 3 || checkpoints[2] != 3 {
+		t.Fatalf("unexpected checkpoints %v", checkpoints)
+	}
+}
+
+func TestScanEventsCallbackErrorRescans(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+
+	var events []*eventscanner.BlockEvent
+	failed := false
+	watcher := newWatcher(t, chain, func(event *eventscanner.BlockEvent) error {
+		if !failed {
+			failed = true
+			return errors.New("callback failed")
+		}
+		events = append(events, event)
+		return nil
+	})
+	var checkpoints []uint64
+	watcher.SetUpdateMaxScanedBlock(func(blockNumber uint64) error {
+		checkpoints = append(checkpoints, blockNumber)
+		return nil
+	})
+	err := fakechain.ScanTo(eventscanner.NewEventScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("got %d events", len(events))
+	}
+	assertTxs(t, events[0], deposit)
+	if len(checkpoints) != 1 || checkpoints[0] != 1 {
+		t.Fatalf("unexpected checkpoints %v", checkpoints)
+	}
+}
+
+func TestScanEventsEnricher(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.SetCode(tokenAddr, []byte{0x60, 0x80})
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(1))
+	transfer := emit(chain, 2, tokenAddr)
+	chain.Mine()
+
+	var events []*eventscanner.BlockEvent
+	watcher := newWatcher(t, chain, func(event *eventscanner.BlockEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	labels := addrlabel.NewMemoryProvider()
+	labels.Set(depositAddr, &addrlabel.Label{Name: "deposit wallet", Category: "internal"})
+	labels.Set(tokenAddr, &addrlabel.Label{Name: "USDT contract", Category: "token"})
+	watcher.SetEnricher(txscanner.NewEnricher(labels, 16))
+	err := fakechain.ScanTo(eventscanner.NewEventScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("got %d events", len(events))
+	}
+	event := events[0]
+	assertTxs(t, event, deposit, transfer)
+	if info := event.Txs[0].ToInfo; info.Label.Name != "deposit wallet" || info.Kind != txscanner.AddressEOA {
+		t.Fatalf("unexpected deposit to info %+v", info)
+	}
+	if info := event.Txs[1].ToInfo; info.Label.Name != "USDT contract" || info.Kind != txscanner.AddressContract {
+		t.Fatalf("unexpected transfer to info %+v", info)
+	}
+	info := event.LogAddressInfos[hexAddress(tokenAddr)]
+	if len(event.LogAddressInfos) != 1 || info == nil || info.Label.Category != "token" || info.Kind != txscanner.AddressContract {
+		t.Fatalf("unexpected log address infos %+v", event.LogAddressInfos)
+	}
+}
+
+func hexAddress(addr common.Address) string {
+	return strings.ToLower(addr.Hex())
+}
@@ -0,0 +1,168 @@
+package eventscanner
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/warrior21st/ethblockscanner/addrset"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+	"github.com/warrior21st/ethblockscanner/rpcauth"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
+	"github.com/warrior21st/ethblockscanner/rpcreplay"
+	"github.com/warrior21st/ethblockscanner/txlogscanner"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+//简单区块事件管理结构,tx规则(from/to地址)和log规则(地址+topic0)均可添加;
+//只提供区块事件扫描器支持的设置,tx规则、回溯扫描等请使用txscanner
+type SimpleEventWatcher struct {
+	txWatcher  *txscanner.SimpleTxWatcher
+	logWatcher *txlogscanner.SimpleTxLogWatcher
+	callback   func(*BlockEvent) error
+}
+
+//构造一个新的简单区块事件管理结构
+func NewSimpleEventWatcher(endpoints []string, scanStartBlock uint64, scanInterval time.Duration, callback func(*BlockEvent) error) *SimpleEventWatcher {
+
+	return &SimpleEventWatcher{
+		txWatcher:  txscanner.NewSimpleTxWatcher(endpoints, scanStartBlock, scanInterval, nil),
+		logWatcher: txlogscanner.NewSimpleTxLogWatcher(endpoints, scanStartBlock, scanInterval, nil),
+		callback:   callback,
+	}
+}
+
+//添加关注的from地址
+func (watcher *SimpleEventWatcher) AddInterestedFrom(from string) error {
+	return watcher.txWatcher.AddInterestedFrom(from)
+}
+
+//添加关注的to地址
+func (watcher *SimpleEventWatcher) AddInterestedTo(to string) error {
+	return watcher.txWatcher.AddInterestedTo(to)
+}
+
+//移除关注的from地址
+func (watcher *SimpleEventWatcher) RemoveInterestedFrom(from string) error {
+	return watcher.txWatcher.RemoveInterestedFrom(from)
+}
+
+//移除关注的to地址
+func (watcher *SimpleEventWatcher) RemoveInterestedTo(to string) error {
+	return watcher.txWatcher.RemoveInterestedTo(to)
+}
+
+//设置关注的from地址集合(如redis/sql集合),替换当前集合
+func (watcher *SimpleEventWatcher) SetInterestedFromSet(set addrset.AddressSet) {
+	watcher.txWatcher.SetInterestedFromSet(set)
+}
+
+//设置关注的to地址集合(如redis/sql集合),替换当前集合
+func (watcher *SimpleEventWatcher) SetInterestedToSet(set addrset.AddressSet) {
+	watcher.txWatcher.SetInterestedToSet(set)
+}
+
+func (watcher *SimpleEventWatcher) IsInterestedTx(from string, to string) bool {
+	return watcher.txWatcher.IsInterestedTx(from, to)
+}
+
+func (watcher *SimpleEventWatcher) IsInterestedTxAddress(from common.Address, to common.Address) (bool, error) {
+	return watcher.txWatcher.IsInterestedTxAddress(from, to)
+}
+
+//添加关注的log参数
+func (watcher *SimpleEventWatcher) AddInterestedParams(address string, topic0 string) {
+	watcher.logWatcher.AddInterestedParams(address, topic0)
+}
+
+//移除关注的log参数
+func (watcher *SimpleEventWatcher) RemoveInterestedParams(address string, topic0 string) {
+	watcher.logWatcher.RemoveInterestedParams(address, topic0)
+}
+
+func (watcher *SimpleEventWatcher) GetInterestedAddresses() []common.Address {
+	return watcher.logWatcher.GetInterestedAddresses()
+}
+
+func (watcher *SimpleEventWatcher) GetInterestedTopics() []common.Hash {
+	return watcher.logWatcher.GetInterestedTopics()
+}
+
+func (watcher *SimpleEventWatcher) IsInterestedLog(address string, topic0 string) bool {
+	return watcher.logWatcher.IsInterestedLog(address, topic0)
+}
+
+//区块事件回调处理方法
+func (watcher *SimpleEventWatcher) Callback(event *BlockEvent) error {
+	return watcher.callback(event)
+}
+
+//设置扫描进度确认回调,返回错误时重新扫描该区块
+func (watcher *SimpleEventWatcher) SetUpdateMaxScanedBlock(callback func(uint64) error) {
+	watcher.txWatcher.SetUpdateMaxScanedBlock(callback)
+}
+
+func (watcher *SimpleEventWatcher) UpdateMaxScanedBlock(blockNumber uint64) error {
+	return watcher.txWatcher.UpdateMaxScanedBlock(blockNumber)
+}
+
+//设置地址标注,区块事件回调前标注tx和log的地址
+func (watcher *SimpleEventWatcher) SetEnricher(enricher *txscanner.Enricher) {
+	watcher.txWatcher.SetEnricher(enricher)
+}
+
+func (watcher *SimpleEventWatcher) GetEnricher() *txscanner.Enricher {
+	return watcher.txWatcher.GetEnricher()
+}
+
+//设置链配置(如L2/侧链),未设置时根据链id选择已知配置
+func (watcher *SimpleEventWatcher) SetChainProfile(profile *txscanner.ChainProfile) {
+	watcher.txWatcher.SetChainProfile(profile)
+}
+
+func (watcher *SimpleEventWatcher) GetChainProfile() *txscanner.ChainProfile {
+	return watcher.txWatcher.GetChainProfile()
+}
+
+//设置节点限流池,节点被限流或额度用尽时请求转到池中的其他节点
+func (watcher *SimpleEventWatcher) SetRateLimitPool(pool *ratelimit.Pool) {
+	watcher.txWatcher.SetRateLimitPool(pool)
+}
+
+//设置节点(http/ws/ipc及超时),替换构造时传入的节点地址
+func (watcher *SimpleEventWatcher) SetEndpoints(endpoints []*rpcnode.Endpoint) {
+	watcher.txWatcher.SetEndpoints(endpoints)
+}
+
+//设置录制文件,各节点的请求和响应写入录制文件,可用rpcreplay.NewReplayer回放;为nil时停止录制
+func (watcher *SimpleEventWatcher) SetRecordArchive(archive *rpcreplay.Archive) {
+	watcher.txWatcher.SetRecordArchive(archive)
+}
+
+//设置Infura project secret,按下标对应节点
+func (watcher *SimpleEventWatcher) SetInfuraSecrets(secrets []string) {
+	watcher.txWatcher.SetInfuraSecrets(secrets)
+}
+
+//设置节点认证,按下标对应节点,为nil的节点使用Infura secret或不认证
+func (watcher *SimpleEventWatcher) SetEndpointAuths(auths []*rpcauth.Auth) {
+	watcher.txWatcher.SetEndpointAuths(auths)
+}
+
+func (watcher *SimpleEventWatcher) GetScanStartBlock() uint64 {
+	return watcher.txWatcher.GetScanStartBlock()
+}
+
+func (watcher *SimpleEventWatcher) GetEthClients() ([]*ethclient.Client, error) {
+	return watcher.txWatcher.GetEthClients()
+}
+
+//获取区块扫描间隔
+func (watcher *SimpleEventWatcher) GetScanInterval() time.Duration {
+	return watcher.txWatcher.GetScanInterval()
+}
+
+//设置区块扫描间隔
+func (watcher *SimpleEventWatcher) SetScanInterval(interval time.Duration) {
+	watcher.txWatcher.SetScanInterval(interval)
+}
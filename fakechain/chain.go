@@ -0,0 +1,697 @@
+package fakechain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+)
+
+const (
+	//创世区块时间
+	GenesisTime = uint64(1600000000)
+	//出块间隔(秒)
+	BlockSeconds = uint64(12)
+	//区块gas上限
+	BlockGasLimit = uint64(30000000)
+	//普通转账gas
+	TransferGas = uint64(21000)
+)
+
+//区块base fee
+var BaseFee = big.NewInt(params.GWei)
+
+//blob交易的blob gas价格
+var BlobBaseFee = big.NewInt(params.BlobTxMinBlobGasprice)
+
+//创世区块中前FundedAccounts个测试账户的余额
+var InitialBalance = new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))
+
+//创世区块中有余额的测试账户数量
+const FundedAccounts = 10
+
+//内存中的确定性测试链,相同的操作序列总是产生相同的区块hash
+type Chain struct {
+	lock     sync.RWMutex
+	chainID  *big.Int
+	signer   types.Signer
+	blocks   []*types.Block
+	receipts map[common.Hash]*types.Receipt
+	txBlocks map[common.Hash]*types.Block
+	reverts  map[common.Hash][]byte
+	codes    map[common.Address][]byte
+	nonces   map[common.Address]uint64
+	pending  []*pendingTx
+	//区块内交易之外的余额变化(如内部转账、提款),按区块号索引
+	credits        map[uint64]map[common.Address]*big.Int
+	pendingCredits map[common.Address]*big.Int
+	//待出块的提款及下一个提款序号
+	pendingWithdrawals []*types.Withdrawal
+	withdrawalIndex    uint64
+	//重组次数,写入区块extraData使重组后的区块hash不同
+	reorgs uint64
+	//rpc返回的交易及receipt的额外字段,模拟L2特有的交易类型和L1费用
+	txFields      map[common.Hash]map[string]interface{}
+	receiptFields map[common.Hash]map[string]interface{}
+	//clique出块签名私钥,为nil时区块不签名
+	cliqueKey *ecdsa.PrivateKey
+}
+
+type pendingTx struct {
+	tx     *types.Transaction
+	from   common.Address
+	logs   []*types.Log
+	failed bool
+	//revert数据,eth_call重放和trace时返回
+	revertData []byte
+}
+
+//构造只有创世区块的测试链
+func NewChain(chainID int64) *Chain {
+	chain := &Chain{
+		chainID:  big.NewInt(chainID),
+		signer:   types.LatestSignerForChainID(big.NewInt(chainID)),
+		receipts: make(map[common.Hash]*types.Receipt),
+		txBlocks: make(map[common.Hash]*types.Block),
+		reverts:  make(map[common.Hash][]byte),
+		codes:    make(map[common.Address][]byte),
+		nonces:   make(map[common.Address]uint64),
+		credits:  make(map[uint64]map[common.Address]*big.Int),
+
+		txFields:      make(map[common.Hash]map[string]interface{}),
+		receiptFields: make(map[common.Hash]map[string]interface{}),
+	}
+	chain.blocks = []*types.Block{chain.newBlock(nil, nil)}
+
+	return chain
+}
+
+//第i个测试账户的私钥,i相同时私钥相同
+func Key(i int) *ecdsa.PrivateKey {
+	seed := make([]byte, 32)
+	binary.BigEndian.PutUint64(seed[24:], uint64(i)+1)
+	key, err := crypto.ToECDSA(seed)
+	if err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+//第i个测试账户的地址
+func Address(i int) common.Address {
+	return crypto.PubkeyToAddress(Key(i).PublicKey)
+}
+
+//构造log,区块和交易相关字段在出块时填充
+func NewLog(address common.Address, topics []common.Hash, data []byte) *types.Log {
+	return &types.Log{
+		Address: address,
+		Topics:  topics,
+		Data:    data,
+	}
+}
+
+func (chain *Chain) ChainID() *big.Int {
+	return new(big.Int).Set(chain.chainID)
+}
+
+//添加由第fromIndex个测试账户签名的交易到待出块列表,to为nil时为合约创建交易
+func (chain *Chain) AddTx(fromIndex int, to *common.Address, value *big.Int, data []byte, logs ...*types.Log) *types.Transaction {
+	return chain.addTx(fromIndex, to, value, data, false, logs)
+}
+
+//添加执行失败的交易(receipt status为0,不产生log)
+func (chain *Chain) AddFailedTx(fromIndex int, to *common.Address, value *big.Int, data []byte) *types.Transaction {
+	return chain.addTx(fromIndex, to, value, data, true, nil)
+}
+
+//添加revert的交易,eth_call重放或debug_traceTransaction时返回revertData
+func (chain *Chain) AddRevertedTx(fromIndex int, to *common.Address, value *big.Int, data []byte, revertData []byte) *types.Transaction {
+	tx := chain.addTx(fromIndex, to, value, data, true, nil)
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	chain.pending[len(chain.pending)-1].revertData = revertData
+
+	return tx
+}
+
+//添加EIP-4844 blob交易,blobHashes为blob的版本hash
+func (chain *Chain) AddBlobTx(fromIndex int, to common.Address, blobHashes []common.Hash) *types.Transaction {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	from := Address(fromIndex)
+	tx := types.MustSignNewTx(Key(fromIndex), chain.signer, &types.BlobTx{
+		ChainID:    uint256.MustFromBig(chain.chainID),
+		Nonce:      chain.nonces[from],
+		GasTipCap:  uint256.NewInt(params.GWei),
+		GasFeeCap:  uint256.MustFromBig(new(big.Int).Mul(BaseFee, big.NewInt(2))),
+		Gas:        TransferGas,
+		To:         to,
+		Value:      new(uint256.Int),
+		BlobFeeCap: uint256.MustFromBig(new(big.Int).Mul(BlobBaseFee, big.NewInt(2))),
+		BlobHashes: blobHashes,
+	})
+	chain.nonces[from]++
+	chain.pending = append(chain.pending, &pendingTx{tx: tx, from: from})
+
+	return tx
+}
+
+//添加转账交易
+func (chain *Chain) Transfer(fromIndex int, to common.Address, value *big.Int) *types.Transaction {
+	return chain.AddTx(fromIndex, &to, value, nil)
+}
+
+//添加指定小费的转账交易,fee cap足够支付base fee和小费,实际gas price为BaseFee + tip
+func (chain *Chain) TransferWithTip(fromIndex int, to common.Address, value *big.Int, tip *big.Int) *types.Transaction {
+	return chain.addTxWithTip(fromIndex, &to, value, nil, tip, false, nil)
+}
+
+func (chain *Chain) addTx(fromIndex int, to *common.Address, value *big.Int, data []byte, failed bool, logs []*types.Log) *types.Transaction {
+	return chain.addTxWithTip(fromIndex, to, value, data, nil, failed, logs)
+}
+
+//tip为nil时小费为1 gwei,fee cap为2倍BaseFee
+func (chain *Chain) addTxWithTip(fromIndex int, to *common.Address, value *big.Int, data []byte, tip *big.Int, failed bool, logs []*types.Log) *types.Transaction {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	from := Address(fromIndex)
+	if value == nil {
+		value = new(big.Int)
+	}
+	tipCap := big.NewInt(params.GWei)
+	feeCap := new(big.Int).Mul(BaseFee, big.NewInt(2))
+	if tip != nil {
+		tipCap = tip
+		feeCap = new(big.Int).Add(feeCap, tip)
+	}
+	tx := types.MustSignNewTx(Key(fromIndex), chain.signer, &types.DynamicFeeTx{
+		ChainID:   chain.chainID,
+		Nonce:     chain.nonces[from],
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       TransferGas + uint64(len(data))*16,
+		To:        to,
+		Value:     value,
+		Data:      data,
+	})
+	chain.nonces[from]++
+	chain.pending = append(chain.pending, &pendingTx{tx: tx, from: from, logs: logs, failed: failed})
+
+	return tx
+}
+
+//下一个区块增加addr的余额(amount可为负),模拟内部转账等交易之外的余额变化
+func (chain *Chain) Credit(addr common.Address, amount *big.Int) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	if chain.pendingCredits == nil {
+		chain.pendingCredits = make(map[common.Address]*big.Int)
+	}
+	if chain.pendingCredits[addr] == nil {
+		chain.pendingCredits[addr] = new(big.Int)
+	}
+	chain.pendingCredits[addr].Add(chain.pendingCredits[addr], amount)
+}
+
+//添加待出块的信标链提款,amountGwei单位为gwei
+func (chain *Chain) AddWithdrawal(validatorIndex uint64, addr common.Address, amountGwei uint64) *types.Withdrawal {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	withdrawal := &types.Withdrawal{
+		Index:     chain.withdrawalIndex,
+		Validator: validatorIndex,
+		Address:   addr,
+		Amount:    amountGwei,
+	}
+	chain.withdrawalIndex++
+	chain.pendingWithdrawals = append(chain.pendingWithdrawals, withdrawal)
+
+	return withdrawal
+}
+
+//将待出块交易打包为新区块
+func (chain *Chain) Mine() *types.Block {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	pending := chain.pending
+	chain.pending = nil
+	block := chain.newBlock(pending, chain.pendingWithdrawals)
+	chain.pendingWithdrawals = nil
+	chain.blocks = append(chain.blocks, block)
+	if chain.pendingCredits != nil {
+		chain.credits[block.NumberU64()] = chain.pendingCredits
+		chain.pendingCredits = nil
+	}
+
+	return block
+}
+
+//连续出n个区块,返回最后一个区块
+func (chain *Chain) MineN(n int) *types.Block {
+	var block *types.Block
+	for i := 0; i < n; i++ {
+		block = chain.Mine()
+	}
+
+	return block
+}
+
+//重组:移除fromBlock及之后的区块,之后出的区块hash与被移除的区块不同
+//被移除区块中的交易不会重新打包
+func (chain *Chain) Reorg(fromBlock uint64) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	if fromBlock == 0 || fromBlock >= uint64(len(chain.blocks)) {
+		return
+	}
+	for _, block := range chain.blocks[fromBlock:] {
+		delete(chain.credits, block.NumberU64())
+		for _, tx := range block.Transactions() {
+			delete(chain.receipts, tx.Hash())
+			delete(chain.txBlocks, tx.Hash())
+			delete(chain.reverts, tx.Hash())
+		}
+	}
+	chain.blocks = chain.blocks[:fromBlock]
+	chain.reorgs++
+}
+
+//最新区块号
+func (chain *Chain) Head() uint64 {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return uint64(len(chain.blocks) - 1)
+}
+
+//按区块号获取区块,不存在时返回nil
+func (chain *Chain) BlockByNumber(number uint64) *types.Block {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	if number >= uint64(len(chain.blocks)) {
+		return nil
+	}
+
+	return chain.blocks[number]
+}
+
+//按区块hash获取区块,不存在时返回nil
+func (chain *Chain) BlockByHash(hash common.Hash) *types.Block {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	for _, block := range chain.blocks {
+		if block.Hash() == hash {
+			return block
+		}
+	}
+
+	return nil
+}
+
+//获取交易receipt,不存在时返回nil
+func (chain *Chain) Receipt(txHash common.Hash) *types.Receipt {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return chain.receipts[txHash]
+}
+
+//获取交易所在区块,不存在时返回nil
+func (chain *Chain) TxBlock(txHash common.Hash) *types.Block {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return chain.txBlocks[txHash]
+}
+
+//获取addr在区块number时的余额:创世余额加上之后区块内交易的转账、手续费、出块奖励(小费)、提款和Credit
+func (chain *Chain) BalanceAt(addr common.Address, number uint64) *big.Int {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	balance := new(big.Int)
+	for i := 0; i < FundedAccounts; i++ {
+		if Address(i) == addr {
+			balance.Set(InitialBalance)
+		}
+	}
+	for n := uint64(1); n <= number && n < uint64(len(chain.blocks)); n++ {
+		block := chain.blocks[n]
+		for _, tx := range block.Transactions() {
+			receipt := chain.receipts[tx.Hash()]
+			success := receipt.Status == types.ReceiptStatusSuccessful
+			gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
+			if sender, _ := types.Sender(chain.signer, tx); sender == addr {
+				balance.Sub(balance, new(big.Int).Mul(gasUsed, receipt.EffectiveGasPrice))
+				if receipt.BlobGasPrice != nil {
+					balance.Sub(balance, new(big.Int).Mul(new(big.Int).SetUint64(receipt.BlobGasUsed), receipt.BlobGasPrice))
+				}
+				if success {
+					balance.Sub(balance, tx.Value())
+				}
+			}
+			if success && tx.To() != nil && *tx.To() == addr {
+				balance.Add(balance, tx.Value())
+			}
+			if block.Coinbase() == addr {
+				balance.Add(balance, new(big.Int).Mul(gasUsed, new(big.Int).Sub(receipt.EffectiveGasPrice, block.BaseFee())))
+			}
+		}
+		for _, withdrawal := range block.Withdrawals() {
+			if withdrawal.Address == addr {
+				balance.Add(balance, new(big.Int).Mul(new(big.Int).SetUint64(withdrawal.Amount), big.NewInt(params.GWei)))
+			}
+		}
+		if credit := chain.credits[n][addr]; credit != nil {
+			balance.Add(balance, credit)
+		}
+	}
+
+	return balance
+}
+
+//设置地址的合约代码,eth_getCode返回该代码
+func (chain *Chain) SetCode(addr common.Address, code []byte) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	chain.codes[addr] = code
+}
+
+//设置rpc返回的交易字段,覆盖同名字段(值为nil时返回null),如L2交易的type、sourceHash等
+func (chain *Chain) SetTxFields(txHash common.Hash, fields map[string]interface{}) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	chain.txFields[txHash] = fields
+}
+
+//获取设置的交易字段,未设置时返回nil
+func (chain *Chain) TxFields(txHash common.Hash) map[string]interface{} {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return chain.txFields[txHash]
+}
+
+//设置rpc返回的receipt字段,覆盖同名字段,如L2 receipt的l1Fee、gasUsedForL1等
+func (chain *Chain) SetReceiptFields(txHash common.Hash, fields map[string]interface{}) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	chain.receiptFields[txHash] = fields
+}
+
+//获取设置的receipt字段,未设置时返回nil
+func (chain *Chain) ReceiptFields(txHash common.Hash) map[string]interface{} {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return chain.receiptFields[txHash]
+}
+
+//之后的区块按clique共识由key签名,extraData为32字节vanity加65字节签名;clique区块不能包含提款
+func (chain *Chain) SetCliqueSigner(key *ecdsa.PrivateKey) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	chain.cliqueKey = key
+}
+
+//获取地址的合约代码,未设置时返回nil
+func (chain *Chain) CodeAt(addr common.Address) []byte {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return chain.codes[addr]
+}
+
+//获取addr在区块number时的nonce,即截至该区块addr发出的交易数量
+func (chain *Chain) NonceAt(addr common.Address, number uint64) uint64 {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	nonce := uint64(0)
+	for n := uint64(1); n <= number && n < uint64(len(chain.blocks)); n++ {
+		for _, tx := range chain.blocks[n].Transactions() {
+			if sender, _ := types.Sender(chain.signer, tx); sender == addr {
+				nonce++
+			}
+		}
+	}
+
+	return nonce
+}
+
+//获取addr在待出块交易之后的nonce,nonce不连续的待出块交易(如之前的交易被丢弃)不计入
+func (chain *Chain) PendingNonce(addr common.Address) uint64 {
+	nonce := chain.NonceAt(addr, chain.Head())
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	for _, p := range chain.pending {
+		if p.from == addr && p.tx.Nonce() == nonce {
+			nonce++
+		}
+	}
+
+	return nonce
+}
+
+//按hash获取待出块交易,不存在时返回nil
+func (chain *Chain) PendingTx(txHash common.Hash) *types.Transaction {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	for _, p := range chain.pending {
+		if p.tx.Hash() == txHash {
+			return p.tx
+		}
+	}
+
+	return nil
+}
+
+//从待出块列表移除交易,模拟交易被交易池丢弃,返回是否移除;
+//测试链不校验nonce,之后出块时仍会打包该账户nonce更大的交易
+func (chain *Chain) DropTx(txHash common.Hash) bool {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	for i, p := range chain.pending {
+		if p.tx.Hash() == txHash {
+			chain.pending = append(chain.pending[:i], chain.pending[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+//获取revert交易的revert数据,非revert交易返回nil
+func (chain *Chain) RevertData(txHash common.Hash) []byte {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return chain.reverts[txHash]
+}
+
+//查找from、to和input相同的revert交易,用于模拟eth_call重放
+func (chain *Chain) FindRevertedTx(from common.Address, to *common.Address, input []byte) *types.Transaction {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	for _, block := range chain.blocks {
+		for _, tx := range block.Transactions() {
+			if chain.reverts[tx.Hash()] == nil || (to == nil) != (tx.To() == nil) || (to != nil && *to != *tx.To()) {
+				continue
+			}
+			if sender, _ := types.Sender(chain.signer, tx); sender == from && bytes.Equal(tx.Data(), input) {
+				return tx
+			}
+		}
+	}
+
+	return nil
+}
+
+//获取交易发送者
+func (chain *Chain) Sender(tx *types.Transaction) common.Address {
+	from, err := types.Sender(chain.signer, tx)
+	if err != nil {
+		panic(err)
+	}
+
+	return from
+}
+
+//获取[fromBlock, toBlock]内匹配地址和topic的log,规则与eth_getLogs一致
+func (chain *Chain) FilterLogs(fromBlock uint64, toBlock uint64, addresses []common.Address, topics [][]common.Hash) []*types.Log {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	var logs []*types.Log
+	for number := fromBlock; number <= toBlock && number < uint64(len(chain.blocks)); number++ {
+		for _, tx := range chain.blocks[number].Transactions() {
+			for _, log := range chain.receipts[tx.Hash()].Logs {
+				if logMatches(log, addresses, topics) {
+					logs = append(logs, log)
+				}
+			}
+		}
+	}
+
+	return logs
+}
+
+func logMatches(log *types.Log, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 {
+		matched := false
+		for _, address := range addresses {
+			if log.Address == address {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(topics) > len(log.Topics) {
+		return false
+	}
+	for i, sub := range topics {
+		if len(sub) == 0 {
+			continue
+		}
+		matched := false
+		for _, topic := range sub {
+			if log.Topics[i] == topic {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+//构造区块并记录receipt,调用方需持有写锁;withdrawals为nil时区块不包含提款字段
+func (chain *Chain) newBlock(pending []*pendingTx, withdrawals []*types.Withdrawal) *types.Block {
+	number := uint64(len(chain.blocks))
+	header := &types.Header{
+		Number:     new(big.Int).SetUint64(number),
+		Time:       GenesisTime + number*BlockSeconds,
+		GasLimit:   BlockGasLimit,
+		Difficulty: new(big.Int),
+		BaseFee:    new(big.Int).Set(BaseFee),
+		Coinbase:   Address(0),
+		Extra:      binary.BigEndian.AppendUint64(nil, chain.reorgs),
+	}
+	if chain.cliqueKey != nil {
+		extra := make([]byte, 32+crypto.SignatureLength)
+		copy(extra, header.Extra)
+		header.Extra = extra
+	}
+	if number > 0 {
+		header.ParentHash = chain.blocks[number-1].Hash()
+	}
+
+	txs := make([]*types.Transaction, len(pending))
+	receipts := make([]*types.Receipt, len(pending))
+	blobGasUsed := uint64(0)
+	hasBlobTx := false
+	for i, p := range pending {
+		header.GasUsed += p.tx.Gas()
+		receipt := &types.Receipt{
+			Type:              p.tx.Type(),
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: header.GasUsed,
+			TxHash:            p.tx.Hash(),
+			GasUsed:           p.tx.Gas(),
+			EffectiveGasPrice: new(big.Int).Add(BaseFee, p.tx.GasTipCap()),
+			TransactionIndex:  uint(i),
+			Logs:              []*types.Log{},
+		}
+		if p.tx.Type() == types.BlobTxType {
+			receipt.BlobGasUsed = p.tx.BlobGas()
+			receipt.BlobGasPrice = new(big.Int).Set(BlobBaseFee)
+			blobGasUsed += receipt.BlobGasUsed
+			hasBlobTx = true
+		}
+		if p.failed {
+			receipt.Status = types.ReceiptStatusFailed
+			if p.revertData != nil {
+				chain.reverts[p.tx.Hash()] = p.revertData
+			}
+		} else {
+			for _, log := range p.logs {
+				l := *log
+				receipt.Logs = append(receipt.Logs, &l)
+			}
+		}
+		if p.tx.To() == nil {
+			receipt.ContractAddress = crypto.CreateAddress(p.from, p.tx.Nonce())
+		}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		txs[i] = p.tx
+		receipts[i] = receipt
+	}
+	//只有包含blob交易的区块才设置blob gas字段,保持其它区块hash不变
+	if hasBlobTx {
+		excessBlobGas := uint64(0)
+		header.BlobGasUsed = &blobGasUsed
+		header.ExcessBlobGas = &excessBlobGas
+	}
+
+	block := types.NewBlock(header, &types.Body{Transactions: txs, Withdrawals: withdrawals}, receipts, trie.NewStackTrie(nil))
+	if chain.cliqueKey != nil {
+		sealed := block.Header()
+		signature, err := crypto.Sign(clique.SealHash(sealed).Bytes(), chain.cliqueKey)
+		if err != nil {
+			panic(err)
+		}
+		copy(sealed.Extra[len(sealed.Extra)-crypto.SignatureLength:], signature)
+		block = block.WithSeal(sealed)
+	}
+	logIndex := uint(0)
+	for i, receipt := range receipts {
+		receipt.BlockHash = block.Hash()
+		receipt.BlockNumber = block.Number()
+		for _, log := range receipt.Logs {
+			log.BlockNumber = number
+			log.BlockHash = block.Hash()
+			log.TxHash = receipt.TxHash
+			log.TxIndex = uint(i)
+			log.Index = logIndex
+			logIndex++
+		}
+		chain.receipts[receipt.TxHash] = receipt
+		chain.txBlocks[receipt.TxHash] = block
+	}
+
+	return block
+}
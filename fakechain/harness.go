@@ -0,0 +1,58 @@
+package fakechain
+
+import (
+	"fmt"
+	"time"
+)
+
+//扫描器,txscanner.TxScanner、txlogscanner.TxlogScanner和eventscanner.EventScanner均实现该接口
+type Scanner interface {
+	Start() error
+	Stop()
+	GetLastScanedBlock() uint64
+}
+
+//在后台启动扫描器,直到已扫描到blockNumber后停止;超时或Start返回错误时返回错误
+func ScanTo(scanner Scanner, blockNumber uint64, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- scanner.Start()
+	}()
+
+	deadline := time.Now().Add(timeout)
+	for scanner.GetLastScanedBlock() < blockNumber {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("scanner stopped at block %d before block %d", scanner.GetLastScanedBlock(), blockNumber)
+		default:
+		}
+		if time.Now().After(deadline) {
+			scanner.Stop()
+			<-done
+			return fmt.Errorf("scanner reached block %d,timeout waiting for block %d", scanner.GetLastScanedBlock(), blockNumber)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	scanner.Stop()
+
+	return <-done
+}
+
+//启动n个使用同一测试链的rpc服务,返回服务地址和关闭所有服务的方法
+func NewServers(chain *Chain, n int) ([]*Server, []string, func()) {
+	servers := make([]*Server, n)
+	endpoints := make([]string, n)
+	for i := 0; i < n; i++ {
+		servers[i] = NewServer(chain)
+		endpoints[i] = servers[i].URL()
+	}
+
+	return servers, endpoints, func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}
+}
@@ -0,0 +1,600 @@
+package fakechain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+//基于测试链的json-rpc服务,支持注入错误、延迟和限流
+type Server struct {
+	chain      *Chain
+	rpcServer  *rpc.Server
+	httpServer *httptest.Server
+	listeners  []net.Listener
+
+	lock      sync.Mutex
+	faults    map[string]*fault
+	latency   time.Duration
+	rateLimit int
+	window    time.Time
+	requests  int
+	calls     map[string]int
+}
+
+type fault struct {
+	code    int
+	message string
+	//剩余次数,小于0时一直生效
+	count int
+}
+
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+}
+
+//启动测试链的json-rpc服务,使用完后需调用Close
+func NewServer(chain *Chain) *Server {
+	server := &Server{
+		chain:     chain,
+		rpcServer: rpc.NewServer(),
+		faults:    make(map[string]*fault),
+		calls:     make(map[string]int),
+	}
+	err := server.rpcServer.RegisterName("eth", &ethService{chain: chain})
+	if err != nil {
+		panic(err)
+	}
+	err = server.rpcServer.RegisterName("web3", &web3Service{})
+	if err != nil {
+		panic(err)
+	}
+	err = server.rpcServer.RegisterName("debug", &debugService{chain: chain})
+	if err != nil {
+		panic(err)
+	}
+	server.httpServer = httptest.NewServer(server)
+
+	return server
+}
+
+//服务地址,可作为watcher的endpoint
+func (server *Server) URL() string {
+	return server.httpServer.URL
+}
+
+func (server *Server) Chain() *Chain {
+	return server.chain
+}
+
+//在path上提供ipc服务(不支持注入错误、延迟和限流),Close时关闭
+func (server *Server) ServeIPC(path string) error {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	server.ServeListener(listener)
+
+	return nil
+}
+
+//在listener上提供json-rpc服务(如统计连接数的listener),Close时关闭
+func (server *Server) ServeListener(listener net.Listener) {
+	server.lock.Lock()
+	server.listeners = append(server.listeners, listener)
+	server.lock.Unlock()
+	go server.rpcServer.ServeListener(listener)
+}
+
+func (server *Server) Close() {
+	server.lock.Lock()
+	for _, listener := range server.listeners {
+		listener.Close()
+	}
+	server.lock.Unlock()
+	server.httpServer.Close()
+	server.rpcServer.Stop()
+}
+
+//之后count次调用method时返回错误,count小于0时一直返回错误
+func (server *Server) FailNext(method string, count int, message string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.faults[method] = &fault{code: -32000, message: message, count: count}
+}
+
+//之后count次调用method时返回节点限流错误(json-rpc错误码-32005),count小于0时一直返回
+func (server *Server) LimitNext(method string, count int) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.faults[method] = &fault{code: -32005, message: "daily request count exceeded, request rate limited", count: count}
+}
+
+//之后调用method时返回方法不存在错误(json-rpc错误码-32601),模拟未开启该方法的节点
+func (server *Server) DisableMethod(method string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.faults[method] = &fault{code: -32601, message: "the method " + method + " does not exist/is not available", count: -1}
+}
+
+//清除所有注入的错误
+func (server *Server) ClearFaults() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.faults = make(map[string]*fault)
+}
+
+//设置每个请求的响应延迟
+func (server *Server) SetLatency(latency time.Duration) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.latency = latency
+}
+
+//设置每秒最大请求数,超出时返回http 429,为0时不限流
+func (server *Server) SetRateLimit(requestsPerSecond int) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.rateLimit = requestsPerSecond
+}
+
+//method被调用的次数(包括返回错误的调用)
+func (server *Server) Calls(method string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	return server.calls[method]
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var requests []*rpcRequest
+	if len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &requests)
+	} else {
+		request := &rpcRequest{}
+		err = json.Unmarshal(body, request)
+		requests = []*rpcRequest{request}
+	}
+	if err != nil {
+		server.rpcServer.ServeHTTP(w, r)
+		return
+	}
+
+	latency, limited, f := server.beforeServe(requests)
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if limited {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if f != nil && len(requests) == 1 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      requests[0].ID,
+			"error":   map[string]interface{}{"code": f.code, "message": f.message},
+		})
+		return
+	}
+
+	server.rpcServer.ServeHTTP(w, r)
+}
+
+//记录调用并检查限流和注入的错误
+func (server *Server) beforeServe(requests []*rpcRequest) (time.Duration, bool, *fault) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	for _, request := range requests {
+		server.calls[request.Method]++
+	}
+	if server.rateLimit > 0 {
+		now := time.Now()
+		if now.Sub(server.window) >= time.Second {
+			server.window = now
+			server.requests = 0
+		}
+		server.requests++
+		if server.requests > server.rateLimit {
+			return server.latency, true, nil
+		}
+	}
+
+	for _, request := range requests {
+		f := server.faults[request.Method]
+		if f == nil {
+			continue
+		}
+		if f.count > 0 {
+			f.count--
+			if f.count == 0 {
+				delete(server.faults, request.Method)
+			}
+		}
+		return server.latency, false, f
+	}
+
+	return server.latency, false, nil
+}
+
+//测试节点的web3_clientVersion
+const ClientVersion = "Geth/v1.14.13-fakechain/linux-amd64/go1.22"
+
+//web3命名空间的rpc方法
+type web3Service struct{}
+
+func (service *web3Service) ClientVersion() string {
+	return ClientVersion
+}
+
+//debug命名空间的rpc方法
+type debugService struct {
+	chain *Chain
+}
+
+//按callTracer的格式返回交易的顶层调用
+func (service *debugService) TraceTransaction(hash common.Hash, config map[string]interface{}) (map[string]interface{}, error) {
+	block := service.chain.TxBlock(hash)
+	if block == nil {
+		return nil, errors.New("transaction " + hash.Hex() + " not found")
+	}
+	tx := block.Transaction(hash)
+	receipt := service.chain.Receipt(hash)
+	result := map[string]interface{}{
+		"type":    "CALL",
+		"from":    service.chain.Sender(tx),
+		"to":      tx.To(),
+		"gas":     hexutil.Uint64(tx.Gas()),
+		"gasUsed": hexutil.Uint64(receipt.GasUsed),
+		"input":   hexutil.Bytes(tx.Data()),
+		"value":   (*hexutil.Big)(tx.Value()),
+	}
+	if receipt.Status == types.ReceiptStatusFailed {
+		result["error"] = "execution reverted"
+		if revertData := service.chain.RevertData(hash); revertData != nil {
+			result["output"] = hexutil.Bytes(revertData)
+		}
+	}
+
+	return result, nil
+}
+
+//eth命名空间的rpc方法
+type ethService struct {
+	chain *Chain
+}
+
+type callArgs struct {
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Data  *hexutil.Bytes  `json:"data"`
+	Input *hexutil.Bytes  `json:"input"`
+}
+
+//eth_call执行revert时返回的错误,与geth一致
+type revertError struct {
+	data []byte
+}
+
+func (err *revertError) Error() string {
+	return "execution reverted"
+}
+
+func (err *revertError) ErrorCode() int {
+	return 3
+}
+
+func (err *revertError) ErrorData() interface{} {
+	return hexutil.Encode(err.data)
+}
+
+type filterCriteria struct {
+	BlockHash *common.Hash     `json:"blockHash"`
+	FromBlock *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock   *rpc.BlockNumber `json:"toBlock"`
+	Addresses []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+func (service *ethService) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(service.chain.ChainID())
+}
+
+func (service *ethService) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(service.chain.Head())
+}
+
+func (service *ethService) GetBlockByNumber(number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
+	block := service.chain.BlockByNumber(service.resolveNumber(number))
+	if block == nil {
+		return nil, nil
+	}
+
+	return service.marshalBlock(block, fullTx)
+}
+
+func (service *ethService) GetBlockByHash(hash common.Hash, fullTx bool) (map[string]interface{}, error) {
+	block := service.chain.BlockByHash(hash)
+	if block == nil {
+		return nil, nil
+	}
+
+	return service.marshalBlock(block, fullTx)
+}
+
+func (service *ethService) GetBlockTransactionCountByHash(hash common.Hash) *hexutil.Uint {
+	block := service.chain.BlockByHash(hash)
+	if block == nil {
+		return nil
+	}
+	count := hexutil.Uint(len(block.Transactions()))
+
+	return &count
+}
+
+func (service *ethService) GetBlockTransactionCountByNumber(number rpc.BlockNumber) *hexutil.Uint {
+	block := service.chain.BlockByNumber(service.resolveNumber(number))
+	if block == nil {
+		return nil
+	}
+	count := hexutil.Uint(len(block.Transactions()))
+
+	return &count
+}
+
+func (service *ethService) GetBalance(address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	block := service.blockByNumberOrHash(blockNrOrHash)
+	if block == nil {
+		return nil, errors.New("header not found")
+	}
+
+	return (*hexutil.Big)(service.chain.BalanceAt(address, block.NumberU64())), nil
+}
+
+//测试链的合约代码不随区块变化
+func (service *ethService) GetCode(address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	if service.blockByNumberOrHash(blockNrOrHash) == nil {
+		return nil, errors.New("header not found")
+	}
+
+	return service.chain.CodeAt(address), nil
+}
+
+func (service *ethService) GetTransactionCount(address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Uint64, error) {
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		nonce := hexutil.Uint64(service.chain.PendingNonce(address))
+		return &nonce, nil
+	}
+	block := service.blockByNumberOrHash(blockNrOrHash)
+	if block == nil {
+		return nil, errors.New("header not found")
+	}
+	nonce := hexutil.Uint64(service.chain.NonceAt(address, block.NumberU64()))
+
+	return &nonce, nil
+}
+
+func (service *ethService) GetTransactionByHash(hash common.Hash) (map[string]interface{}, error) {
+	block := service.chain.TxBlock(hash)
+	if block == nil {
+		if tx := service.chain.PendingTx(hash); tx != nil {
+			return service.marshalPendingTx(tx)
+		}
+		return nil, nil
+	}
+	for i, tx := range block.Transactions() {
+		if tx.Hash() == hash {
+			return service.marshalTx(block, tx, i)
+		}
+	}
+
+	return nil, nil
+}
+
+func (service *ethService) GetTransactionReceipt(hash common.Hash) (map[string]interface{}, error) {
+	receipt := service.chain.Receipt(hash)
+	if receipt == nil {
+		return nil, nil
+	}
+
+	return service.marshalReceipt(receipt)
+}
+
+func (service *ethService) GetBlockReceipts(blockNrOrHash rpc.BlockNumberOrHash) ([]map[string]interface{}, error) {
+	block := service.blockByNumberOrHash(blockNrOrHash)
+	if block == nil {
+		return nil, nil
+	}
+
+	receipts := make([]map[string]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		receipt, err := service.marshalReceipt(service.chain.Receipt(tx.Hash()))
+		if err != nil {
+			return nil, err
+		}
+		receipts[i] = receipt
+	}
+
+	return receipts, nil
+}
+
+//测试链不执行交易,只模拟revert交易的重放:from、to和input与revert交易相同时返回其revert数据
+func (service *ethService) Call(args callArgs, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	input := args.Input
+	if input == nil {
+		input = args.Data
+	}
+	var data []byte
+	if input != nil {
+		data = *input
+	}
+	if tx := service.chain.FindRevertedTx(args.From, args.To, data); tx != nil {
+		return nil, &revertError{data: service.chain.RevertData(tx.Hash())}
+	}
+
+	return hexutil.Bytes{}, nil
+}
+
+func (service *ethService) GetLogs(criteria filterCriteria) ([]*types.Log, error) {
+	var fromBlock, toBlock uint64
+	if criteria.BlockHash != nil {
+		block := service.chain.BlockByHash(*criteria.BlockHash)
+		if block == nil {
+			return nil, errors.New("unknown block")
+		}
+		fromBlock = block.NumberU64()
+		toBlock = fromBlock
+	} else {
+		fromBlock = service.chain.Head()
+		toBlock = fromBlock
+		if criteria.FromBlock != nil {
+			fromBlock = service.resolveNumber(*criteria.FromBlock)
+		}
+		if criteria.ToBlock != nil {
+			toBlock = service.resolveNumber(*criteria.ToBlock)
+		}
+	}
+
+	logs := service.chain.FilterLogs(fromBlock, toBlock, criteria.Addresses, criteria.Topics)
+	if logs == nil {
+		logs = []*types.Log{}
+	}
+
+	return logs, nil
+}
+
+func (service *ethService) blockByNumberOrHash(blockNrOrHash rpc.BlockNumberOrHash) *types.Block {
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		return service.chain.BlockByHash(hash)
+	}
+	if number, ok := blockNrOrHash.Number(); ok {
+		return service.chain.BlockByNumber(service.resolveNumber(number))
+	}
+
+	return nil
+}
+
+func (service *ethService) resolveNumber(number rpc.BlockNumber) uint64 {
+	if number < 0 {
+		return service.chain.Head()
+	}
+
+	return uint64(number)
+}
+
+//按eth_getBlockByNumber的格式序列化区块
+func (service *ethService) marshalBlock(block *types.Block, fullTx bool) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	err := remarshal(block.Header(), &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !fullTx {
+			txs[i] = tx.Hash()
+			continue
+		}
+		txFields, err := service.marshalTx(block, tx, i)
+		if err != nil {
+			return nil, err
+		}
+		txs[i] = txFields
+	}
+	fields["transactions"] = txs
+	fields["uncles"] = []common.Hash{}
+	if block.Withdrawals() != nil {
+		fields["withdrawals"] = block.Withdrawals()
+	}
+	fields["size"] = hexutil.Uint64(block.Size())
+
+	return fields, nil
+}
+
+//按eth_getTransactionByHash的格式序列化交易
+func (service *ethService) marshalTx(block *types.Block, tx *types.Transaction, index int) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	err := remarshal(tx, &fields)
+	if err != nil {
+		return nil, err
+	}
+	fields["from"] = service.chain.Sender(tx)
+	fields["blockHash"] = block.Hash()
+	fields["blockNumber"] = (*hexutil.Big)(block.Number())
+	fields["transactionIndex"] = hexutil.Uint64(index)
+	if tx.Type() != types.LegacyTxType {
+		fields["gasPrice"] = (*hexutil.Big)(new(big.Int).Add(block.BaseFee(), tx.GasTipCap()))
+	}
+	for key, value := range service.chain.TxFields(tx.Hash()) {
+		fields[key] = value
+	}
+
+	return fields, nil
+}
+
+//按eth_getTransactionReceipt的格式序列化receipt,包含设置的额外字段
+func (service *ethService) marshalReceipt(receipt *types.Receipt) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	err := remarshal(receipt, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range service.chain.ReceiptFields(receipt.TxHash) {
+		fields[key] = value
+	}
+
+	return fields, nil
+}
+
+//按交易池中交易的格式序列化交易,区块字段为null
+func (service *ethService) marshalPendingTx(tx *types.Transaction) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	err := remarshal(tx, &fields)
+	if err != nil {
+		return nil, err
+	}
+	fields["from"] = service.chain.Sender(tx)
+	fields["blockHash"] = nil
+	fields["blockNumber"] = nil
+	fields["transactionIndex"] = nil
+
+	return fields, nil
+}
+
+func remarshal(v interface{}, fields *map[string]interface{}) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, fields)
+}
@@ -0,0 +1,134 @@
+package fakechain
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func newClient(t *testing.T, server *Server) *ethclient.Client {
+	client, err := ethclient.Dial(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+func TestServerBlocksAndReceipts(t *testing.T) {
+	chain := NewChain(1)
+	topic := common.HexToHash("0x01")
+	tx := chain.AddTx(1, &common.Address{0xaa}, big.NewInt(5), []byte{1, 2, 3, 4, 5}, NewLog(common.Address{0xbb}, []common.Hash{topic}, nil))
+	chain.Mine()
+	server := NewServer(chain)
+	defer server.Close()
+	client := newClient(t, server)
+
+	chainID, err := client.ChainID(context.Background())
+	if err != nil || chainID.Int64() != 1 {
+		t.Fatalf("chain id %v, %v", chainID, err)
+	}
+	head, err := client.BlockNumber(context.Background())
+	if err != nil || head != 1 {
+		t.Fatalf("head %d, %v", head, err)
+	}
+
+	block, err := client.BlockByNumber(context.Background(), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash() != chain.BlockByNumber(1).Hash() || len(block.Transactions()) != 1 || block.Transactions()[0].Hash() != tx.Hash() {
+		t.Fatalf("unexpected block %s", block.Hash().Hex())
+	}
+	if block.Time() != GenesisTime+BlockSeconds {
+		t.Fatalf("block time %d", block.Time())
+	}
+
+	receipt, err := client.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != 1 || len(receipt.Logs) != 1 || receipt.Logs[0].BlockHash != block.Hash() {
+		t.Fatalf("unexpected receipt %+v", receipt)
+	}
+
+	logs, err := client.FilterLogs(context.Background(), ethereum.FilterQuery{
+		FromBlock: big.NewInt(0),
+		ToBlock:   big.NewInt(1),
+		Topics:    [][]common.Hash{{topic}},
+	})
+	if err != nil || len(logs) != 1 || logs[0].TxHash != tx.Hash() {
+		t.Fatalf("logs %v, %v", logs, err)
+	}
+
+	_, err = client.BlockByNumber(context.Background(), big.NewInt(2))
+	if err != ethereum.NotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestServerFaultsAndRateLimit(t *testing.T) {
+	chain := NewChain(1)
+	server := NewServer(chain)
+	defer server.Close()
+	client := newClient(t, server)
+
+	server.FailNext("eth_blockNumber", 2, "boom")
+	for i := 0; i < 2; i++ {
+		_, err := client.BlockNumber(context.Background())
+		if err == nil || err.Error() != "boom" {
+			t.Fatalf("call %d: expected boom, got %v", i, err)
+		}
+	}
+	_, err := client.BlockNumber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Calls("eth_blockNumber") != 3 {
+		t.Fatalf("calls %d", server.Calls("eth_blockNumber"))
+	}
+
+	server.SetRateLimit(1)
+	client.BlockNumber(context.Background())
+	_, err = client.BlockNumber(context.Background())
+	if err == nil {
+		t.Fatal("expected rate limit error")
+	}
+	server.SetRateLimit(0)
+
+	server.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	client.BlockNumber(context.Background())
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("latency not applied")
+	}
+}
+
+func TestChainReorg(t *testing.T) {
+	chain := NewChain(1)
+	tx := chain.Transfer(1, Address(2), big.NewInt(1))
+	chain.MineN(3)
+	oldHash := chain.BlockByNumber(2).Hash()
+
+	chain.Reorg(1)
+	if chain.Head() != 0 || chain.Receipt(tx.Hash()) != nil {
+		t.Fatal("reorg did not remove blocks")
+	}
+	chain.MineN(3)
+	if chain.BlockByNumber(2).Hash() == oldHash {
+		t.Fatal("reorged block has the same hash")
+	}
+
+	again := NewChain(1)
+	again.Transfer(1, Address(2), big.NewInt(1))
+	again.MineN(3)
+	if again.BlockByNumber(2).Hash() != oldHash {
+		t.Fatal("chain is not deterministic")
+	}
+}
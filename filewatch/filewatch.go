@@ -0,0 +1,65 @@
+package filewatch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+//读取json配置文件并解析到v
+func LoadJSON(path string, v interface{}) error {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, v)
+}
+
+//加载文件并定时检查,文件修改后重新加载,加载失败时下次检查再重试;
+//首次加载失败时返回错误,否则返回停止检查的方法,logger用于输出检查及重新加载的结果
+func Watch(path string, interval time.Duration, load func(path string) error, logger func(msg string)) (func(), error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	err = load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lastModTime := info.ModTime()
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				logger("stat file " + path + " error: " + err.Error())
+				continue
+			}
+			if info.ModTime().Equal(lastModTime) {
+				continue
+			}
+			err = load(path)
+			if err != nil {
+				logger("reload file " + path + " error: " + err.Error())
+				continue
+			}
+			lastModTime = info.ModTime()
+			logger("file " + path + " reloaded.")
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }, nil
+}
@@ -0,0 +1,92 @@
+package filewatch_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/warrior21st/ethblockscanner/filewatch"
+)
+
+type config struct {
+	Names []string `json:"names"`
+}
+
+func TestWatchReloadsModifiedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(path, []byte(`{"names":["a"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	var loaded [][]string
+	stop, err := filewatch.Watch(path, 10*time.Millisecond, func(path string) error {
+		c := &config{}
+		err := filewatch.LoadJSON(path, c)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		loaded = append(loaded, c.Names)
+		return nil
+	}, func(msg string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	err = ioutil.WriteFile(path, []byte(`{"names":["a","b"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//修改时间精度较低的文件系统上确保修改时间变化
+	err = os.Chtimes(path, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		n := len(loaded)
+		lock.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(loaded) != 2 || len(loaded[1]) != 2 {
+		t.Fatalf("unexpected loads %v", loaded)
+	}
+}
+
+func TestWatchReturnsInitialLoadError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	_, err := filewatch.Watch(path, time.Second, func(string) error { return nil }, func(string) {})
+	if !os.IsNotExist(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(`{`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadErr := errors.New("load failed")
+	_, err = filewatch.Watch(path, time.Second, func(string) error { return loadErr }, func(string) {})
+	if err != loadErr {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if filewatch.LoadJSON(path, &config{}) == nil {
+		t.Fatal("expected json error")
+	}
+}
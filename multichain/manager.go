@@ -0,0 +1,347 @@
+package multichain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+//可被管理的扫描器,txscanner.TxScanner/txlogscanner.TxlogScanner/eventscanner.EventScanner均已实现
+type Scanner interface {
+	//开始扫描,阻塞直到调用Stop
+	Start() error
+
+	//停止扫描
+	Stop()
+
+	//获取已扫描的最大区块号
+	GetLastScanedBlock() uint64
+}
+
+//停止后可再次启动的扫描器,Reset在Start返回后调用,重置停止状态
+type ResettableScanner interface {
+	Scanner
+
+	Reset()
+}
+
+//链扫描状态
+type ChainStatus struct {
+	ChainID         uint64
+	Running         bool
+	LastScanedBlock uint64
+	//扫描器异常退出后的重启次数
+	Restarts      int
+	LastError     string
+	LastErrorTime time.Time
+}
+
+type chainScanner struct {
+	chainID       uint64
+	scanner       Scanner
+	getEthClients func() ([]*ethclient.Client, error)
+
+	lock          sync.Mutex
+	started       bool
+	running       bool
+	restarts      int
+	lastError     string
+	lastErrorTime time.Time
+	//扫描器是否调用过Stop,再次启动前需要Reset
+	scannerStopped bool
+	//当前运行的停止及结束信号,每次启动时重新创建
+	stop chan struct{}
+	done chan struct{}
+}
+
+//多链扫描管理器,按链id管理扫描器,扫描器异常退出(返回错误或panic)时自动重启
+type Manager struct {
+	lock               sync.Mutex
+	chains             map[uint64]*chainScanner
+	restartInterval    time.Duration
+	maxRestartInterval time.Duration
+}
+
+//构造多链扫描管理器(默认首次重启间隔5秒,之后翻倍,最长5分钟)
+func NewManager() *Manager {
+	return &Manager{
+		chains:             make(map[uint64]*chainScanner),
+		restartInterval:    5 * time.Second,
+		maxRestartInterval: 5 * time.Minute,
+	}
+}
+
+//设置首次重启间隔和最长重启间隔
+func (manager *Manager) SetRestartInterval(interval time.Duration, maxInterval time.Duration) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.restartInterval = interval
+	manager.maxRestartInterval = maxInterval
+}
+
+//添加链扫描器,getEthClients一般为watcher的GetEthClients,用于启动前校验节点的链id
+func (manager *Manager) AddChain(chainID uint64, scanner Scanner, getEthClients func() ([]*ethclient.Client, error)) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if _, b := manager.chains[chainID]; b {
+		return fmt.Errorf("chain %d already added", chainID)
+	}
+	manager.chains[chainID] = &chainScanner{
+		chainID:       chainID,
+		scanner:       scanner,
+		getEthClients: getEthClients,
+	}
+
+	return nil
+}
+
+//启动全部未启动的链扫描器
+func (manager *Manager) Start() {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	for _, chain := range manager.chains {
+		err := manager.startChain(chain)
+		if err != nil {
+			LogToConsole(err.Error())
+		}
+	}
+}
+
+//启动指定链的扫描器
+func (manager *Manager) StartChain(chainID uint64) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	chain, b := manager.chains[chainID]
+	if !b {
+		return fmt.Errorf("chain %d not found", chainID)
+	}
+
+	return manager.startChain(chain)
+}
+
+//停止并移除指定链的扫描器
+func (manager *Manager) RemoveChain(chainID uint64) error {
+	manager.lock.Lock()
+	chain, b := manager.chains[chainID]
+	delete(manager.chains, chainID)
+	manager.lock.Unlock()
+
+	if !b {
+		return fmt.Errorf("chain %d not found", chainID)
+	}
+	stopChain(chain)
+
+	return nil
+}
+
+//停止全部链扫描器,停止后可再次调用Start启动
+func (manager *Manager) Stop() {
+	manager.lock.Lock()
+	chains := make([]*chainScanner, 0, len(manager.chains))
+	for _, chain := range manager.chains {
+		chains = append(chains, chain)
+	}
+	manager.lock.Unlock()
+
+	for _, chain := range chains {
+		stopChain(chain)
+	}
+}
+
+//获取全部链的扫描状态,按链id排序
+func (manager *Manager) Status() []ChainStatus {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	statuses := make([]ChainStatus, 0, len(manager.chains))
+	for _, chain := range manager.chains {
+		chain.lock.Lock()
+		statuses = append(statuses, ChainStatus{
+			ChainID:         chain.chainID,
+			Running:         chain.running,
+			LastScanedBlock: chain.scanner.GetLastScanedBlock(),
+			Restarts:        chain.restarts,
+			LastError:       chain.lastError,
+			LastErrorTime:   chain.lastErrorTime,
+		})
+		chain.lock.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ChainID < statuses[j].ChainID
+	})
+
+	return statuses
+}
+
+//启动链扫描器;扫描器停止过时需实现ResettableScanner才能再次启动
+func (manager *Manager) startChain(chain *chainScanner) error {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	if chain.started {
+		select {
+		case <-chain.stop:
+			return fmt.Errorf("chain %d is stopping", chain.chainID)
+		default:
+			return nil
+		}
+	}
+	if chain.scannerStopped {
+		scanner, ok := chain.scanner.(ResettableScanner)
+		if !ok {
+			return fmt.Errorf("chain %d scanner can not restart after stop", chain.chainID)
+		}
+		scanner.Reset()
+		chain.scannerStopped = false
+	}
+	chain.started = true
+	chain.stop = make(chan struct{})
+	chain.done = make(chan struct{})
+	go manager.supervise(chain, chain.stop, chain.done, manager.restartInterval, manager.maxRestartInterval)
+
+	return nil
+}
+
+//运行扫描器,异常退出时按间隔重启,直到stop关闭
+func (manager *Manager) supervise(chain *chainScanner, stop chan struct{}, done chan struct{}, restartInterval time.Duration, maxRestartInterval time.Duration) {
+	defer close(done)
+
+	interval := restartInterval
+	for {
+		startTime := time.Now()
+		err := runChain(chain, stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err == nil {
+			err = errors.New("scanner exited")
+		}
+		chain.lock.Lock()
+		chain.restarts++
+		chain.lastError = err.Error()
+		chain.lastErrorTime = time.Now()
+		chain.lock.Unlock()
+
+		//运行时间超过最长重启间隔时视为恢复正常,重启间隔重置
+		if time.Since(startTime) > maxRestartInterval {
+			interval = restartInterval
+		}
+		LogToConsole("chain " + strconv.FormatUint(chain.chainID, 10) + " scanner error: " + err.Error() + ",restart after " + interval.String() + "...")
+		timer := time.NewTimer(interval)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		interval *= 2
+		if interval > maxRestartInterval {
+			interval = maxRestartInterval
+		}
+	}
+}
+
+func runChain(chain *chainScanner, stop chan struct{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("scanner panic: %v", r)
+		}
+		chain.lock.Lock()
+		chain.running = false
+		chain.lock.Unlock()
+	}()
+
+	if chain.getEthClients != nil {
+		clients, err := chain.getEthClients()
+		if err != nil {
+			return err
+		}
+		err = ValidateChainID(clients, chain.chainID)
+		for _, client := range clients {
+			client.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	//校验链id期间已调用stop时不再启动扫描器;否则标记运行后由stopChain负责停止扫描器
+	chain.lock.Lock()
+	select {
+	case <-stop:
+		chain.lock.Unlock()
+		return nil
+	default:
+	}
+	chain.running = true
+	chain.lock.Unlock()
+
+	return chain.scanner.Start()
+}
+
+//停止链扫描器并等待当前运行结束
+func stopChain(chain *chainScanner) {
+	chain.lock.Lock()
+	if !chain.started {
+		chain.lock.Unlock()
+		return
+	}
+	stop, done := chain.stop, chain.done
+	stopScanner := false
+	select {
+	case <-stop:
+	default:
+		close(stop)
+		//扫描器已启动或即将启动时才调用Stop,Stop对之后的Start同样生效
+		if chain.running {
+			stopScanner = true
+			chain.scannerStopped = true
+		}
+	}
+	chain.lock.Unlock()
+
+	if stopScanner {
+		chain.scanner.Stop()
+	}
+	<-done
+
+	chain.lock.Lock()
+	if chain.done == done {
+		chain.started = false
+	}
+	chain.lock.Unlock()
+}
+
+//校验全部节点返回的链id均为chainID
+func ValidateChainID(clients []*ethclient.Client, chainID uint64) error {
+	if len(clients) == 0 {
+		return errors.New("no eth client")
+	}
+	for i, client := range clients {
+		cid, err := client.ChainID(context.Background())
+		if err != nil {
+			return fmt.Errorf("client_%d get chain id error: %s", i, err.Error())
+		}
+		if !cid.IsUint64() || cid.Uint64() != chainID {
+			return fmt.Errorf("client_%d chain id %s mismatch,expected %d", i, cid.String(), chainID)
+		}
+	}
+
+	return nil
+}
+
+func LogToConsole(msg string) {
+	fmt.Println(time.Now().Add(8*time.Hour).Format("2006-01-02 15:04:05") + "  " + msg)
+}
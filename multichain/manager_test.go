@@ -0,0 +1,224 @@
+package multichain_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/multichain"
+)
+
+const waitTimeout = 5 * time.Second
+
+//测试扫描器,按顺序返回results中的结果(nil表示阻塞直到Stop),为"panic"时panic
+type fakeScanner struct {
+	lock     sync.Mutex
+	results  []string
+	starts   int
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newFakeScanner(results ...string) *fakeScanner {
+	return &fakeScanner{results: results, stop: make(chan struct{})}
+}
+
+func (scanner *fakeScanner) Start() error {
+	scanner.lock.Lock()
+	scanner.starts++
+	result := ""
+	if len(scanner.results) > 0 {
+		result = scanner.results[0]
+		scanner.results = scanner.results[1:]
+	}
+	stop := scanner.stop
+	scanner.lock.Unlock()
+
+	switch result {
+	case "":
+		<-stop
+		return nil
+	case "panic":
+		panic("scanner panic")
+	default:
+		return errors.New(result)
+	}
+}
+
+func (scanner *fakeScanner) Stop() {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
+
+	scanner.stopOnce.Do(func() { close(scanner.stop) })
+}
+
+func (scanner *fakeScanner) GetLastScanedBlock() uint64 {
+	return 0
+}
+
+func (scanner *fakeScanner) startCount() int {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
+
+	return scanner.starts
+}
+
+//可重置的测试扫描器
+type resettableScanner struct {
+	*fakeScanner
+}
+
+func (scanner resettableScanner) Reset() {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
+
+	scanner.stop = make(chan struct{})
+	scanner.stopOnce = sync.Once{}
+}
+
+func newManager() *multichain.Manager {
+	manager := multichain.NewManager()
+	manager.SetRestartInterval(time.Millisecond, 10*time.Millisecond)
+
+	return manager
+}
+
+func waitStatus(t *testing.T, manager *multichain.Manager, check func(status multichain.ChainStatus) bool) multichain.ChainStatus {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		status := manager.Status()[0]
+		if check(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for status, last %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestManagerRestartsFailedScanner(t *testing.T) {
+	manager := newManager()
+	scanner := newFakeScanner("rpc error", "panic")
+	err := manager.AddChain(1, scanner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	defer manager.Stop()
+
+	status := waitStatus(t, manager, func(status multichain.ChainStatus) bool {
+		return status.Running && status.Restarts == 2
+	})
+	if status.LastError != "scanner panic: scanner panic" {
+		t.Fatalf("unexpected last error %q", status.LastError)
+	}
+	if scanner.startCount() != 3 {
+		t.Fatalf("got %d starts, want 3", scanner.startCount())
+	}
+}
+
+func TestManagerStopWaitsForScanner(t *testing.T) {
+	manager := newManager()
+	scanner := newFakeScanner()
+	err := manager.AddChain(1, scanner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	waitStatus(t, manager, func(status multichain.ChainStatus) bool { return status.Running })
+
+	manager.Stop()
+	if status := manager.Status()[0]; status.Running || status.Restarts != 0 {
+		t.Fatalf("unexpected status after stop %+v", status)
+	}
+	//重复停止直接返回
+	manager.Stop()
+}
+
+func TestManagerStopDuringValidation(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+
+	validating := make(chan struct{})
+	release := make(chan struct{})
+	manager := newManager()
+	scanner := newFakeScanner()
+	err := manager.AddChain(1, scanner, func() ([]*ethclient.Client, error) {
+		close(validating)
+		<-release
+		client, err := ethclient.Dial(server.URL())
+		if err != nil {
+			return nil, err
+		}
+		return []*ethclient.Client{client}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	<-validating
+
+	stopped := make(chan struct{})
+	go func() {
+		manager.Stop()
+		close(stopped)
+	}()
+	//等待Stop关闭停止信号后再完成校验
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(waitTimeout):
+		t.Fatal("timeout waiting for stop")
+	}
+	if scanner.startCount() != 0 {
+		t.Fatalf("scanner started %d times after stop", scanner.startCount())
+	}
+}
+
+func TestManagerRestartsAfterStop(t *testing.T) {
+	manager := newManager()
+	scanner := resettableScanner{newFakeScanner()}
+	err := manager.AddChain(1, scanner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	waitStatus(t, manager, func(status multichain.ChainStatus) bool { return status.Running })
+	manager.Stop()
+
+	err = manager.StartChain(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, manager, func(status multichain.ChainStatus) bool { return status.Running })
+	manager.Stop()
+	if scanner.startCount() != 2 {
+		t.Fatalf("got %d starts, want 2", scanner.startCount())
+	}
+}
+
+func TestManagerRejectsRestartOfStoppedScanner(t *testing.T) {
+	manager := newManager()
+	scanner := newFakeScanner()
+	err := manager.AddChain(1, scanner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	waitStatus(t, manager, func(status multichain.ChainStatus) bool { return status.Running })
+	manager.Stop()
+
+	if manager.StartChain(1) == nil {
+		t.Fatal("expected error restarting a scanner without Reset")
+	}
+	if manager.StartChain(2) == nil {
+		t.Fatal("expected error for unknown chain")
+	}
+}
@@ -0,0 +1,428 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//限流或额度用尽时默认的退避时间
+const defaultBackoff = time.Second
+
+//节点被限流时返回的json-rpc错误码(Infura等)
+const limitExceededCode = -32005
+
+//所有节点均被限流或额度用尽
+var ErrAllEndpointsLimited = errors.New("all endpoints are rate limited or out of daily budget")
+
+//节点限流配置
+type Config struct {
+	//每秒请求数,为0时不限制
+	RequestsPerSecond float64
+	//令牌桶容量,为0时为1
+	Burst int
+	//每日(UTC)请求数额度,为0时不限制
+	DailyBudget int64
+	//转发到该节点时使用的请求头(如认证),替换原请求的同名请求头
+	Header http.Header
+}
+
+//节点请求统计
+type EndpointStats struct {
+	URL string
+	//当日(UTC)请求数
+	RequestsToday int64
+	DailyBudget   int64
+	//被限流(429/-32005)的次数
+	Limited int64
+	//在该时间前不向该节点发送请求
+	BackoffUntil time.Time
+}
+
+type endpoint struct {
+	url       string
+	config    Config
+	transport http.RoundTripper
+
+	lock         sync.Mutex
+	tokens       float64
+	lastRefill   time.Time
+	day          string
+	requestsDay  int64
+	limited      int64
+	backoffUntil time.Time
+}
+
+//节点池,按节点限流;节点被限流或额度用尽时请求转到池中的其他节点
+type Pool struct {
+	lock      sync.RWMutex
+	endpoints []*endpoint
+	base      http.RoundTripper
+}
+
+//构造节点池,所有节点使用相同的限流配置
+func NewPool(urls []string, config Config) *Pool {
+	pool := &Pool{base: http.DefaultTransport}
+	for _, u := range urls {
+		pool.Add(u, config)
+	}
+
+	return pool
+}
+
+//添加节点,已存在时更新限流配置
+func (pool *Pool) Add(url string, config Config) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for _, ep := range pool.endpoints {
+		if ep.url == url {
+			ep.lock.Lock()
+			ep.config = config
+			ep.config.Header = config.Header.Clone()
+			ep.lock.Unlock()
+			return
+		}
+	}
+	config.Header = config.Header.Clone()
+	pool.endpoints = append(pool.endpoints, &endpoint{url: url, config: config, tokens: float64(burst(config))})
+}
+
+//设置转发到节点时使用的请求头
+func (pool *Pool) SetHeader(url string, key string, value string) {
+	ep := pool.endpoint(url)
+	if ep == nil {
+		return
+	}
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	if ep.config.Header == nil {
+		ep.config.Header = make(http.Header)
+	}
+	ep.config.Header.Set(key, value)
+}
+
+//设置底层http transport
+func (pool *Pool) SetTransport(base http.RoundTripper) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.base = base
+}
+
+func (pool *Pool) transport() http.RoundTripper {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	return pool.base
+}
+
+//设置发往节点的请求使用的http transport(如带认证或mTLS的transport),为nil时使用池的transport
+func (pool *Pool) SetEndpointTransport(url string, transport http.RoundTripper) {
+	if pool.endpoint(url) == nil {
+		pool.Add(url, Config{})
+	}
+	ep := pool.endpoint(url)
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	ep.transport = transport
+}
+
+//获取优先使用url节点的http client,可通过rpc.WithHTTPClient传给rpc.DialOptions
+//url不在池中时以不限流的配置加入
+func (pool *Pool) HTTPClient(url string) *http.Client {
+	if pool.endpoint(url) == nil {
+		pool.Add(url, Config{})
+	}
+
+	return &http.Client{Transport: &transport{pool: pool, primary: url}}
+}
+
+//获取各节点的请求统计
+func (pool *Pool) Stats() []EndpointStats {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	stats := make([]EndpointStats, len(pool.endpoints))
+	for i, ep := range pool.endpoints {
+		ep.lock.Lock()
+		ep.resetDay(time.Now())
+		stats[i] = EndpointStats{
+			URL:           ep.url,
+			RequestsToday: ep.requestsDay,
+			DailyBudget:   ep.config.DailyBudget,
+			Limited:       ep.limited,
+			BackoffUntil:  ep.backoffUntil,
+		}
+		ep.lock.Unlock()
+	}
+
+	return stats
+}
+
+func (pool *Pool) endpoint(url string) *endpoint {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	for _, ep := range pool.endpoints {
+		if ep.url == url {
+			return ep
+		}
+	}
+
+	return nil
+}
+
+//primary优先,之后为池中其他节点
+func (pool *Pool) candidates(primary string) []*endpoint {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	candidates := make([]*endpoint, 0, len(pool.endpoints))
+	for _, ep := range pool.endpoints {
+		if ep.url == primary {
+			candidates = append([]*endpoint{ep}, candidates...)
+		} else {
+			candidates = append(candidates, ep)
+		}
+	}
+
+	return candidates
+}
+
+func burst(config Config) int {
+	if config.Burst <= 0 {
+		return 1
+	}
+
+	return config.Burst
+}
+
+//当日额度在UTC零点重置,调用方需持有锁
+func (ep *endpoint) resetDay(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	if ep.day != day {
+		ep.day = day
+		ep.requestsDay = 0
+	}
+}
+
+//占用一次请求,返回需要等待的时间;节点退避中或额度用尽时返回false
+func (ep *endpoint) reserve(now time.Time) (time.Duration, bool) {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	ep.resetDay(now)
+	if now.Before(ep.backoffUntil) {
+		return 0, false
+	}
+	if ep.config.DailyBudget > 0 && ep.requestsDay >= ep.config.DailyBudget {
+		return 0, false
+	}
+	ep.requestsDay++
+	if ep.config.RequestsPerSecond <= 0 {
+		return 0, true
+	}
+
+	//令牌桶,令牌不足时预占令牌并等待
+	capacity := float64(burst(ep.config))
+	if !ep.lastRefill.IsZero() {
+		ep.tokens += now.Sub(ep.lastRefill).Seconds() * ep.config.RequestsPerSecond
+		if ep.tokens > capacity {
+			ep.tokens = capacity
+		}
+	}
+	ep.lastRefill = now
+	ep.tokens--
+	if ep.tokens >= 0 {
+		return 0, true
+	}
+
+	return time.Duration(-ep.tokens / ep.config.RequestsPerSecond * float64(time.Second)), true
+}
+
+func (ep *endpoint) backoff(d time.Duration) {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	ep.limited++
+	until := time.Now().Add(d)
+	if until.After(ep.backoffUntil) {
+		ep.backoffUntil = until
+	}
+}
+
+func (ep *endpoint) roundTripper(base http.RoundTripper) http.RoundTripper {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	if ep.transport != nil {
+		return ep.transport
+	}
+
+	return base
+}
+
+func (ep *endpoint) header() http.Header {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	return ep.config.Header.Clone()
+}
+
+type transport struct {
+	pool    *Pool
+	primary string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := t.pool.candidates(t.primary)
+	if len(candidates) == 0 {
+		return t.pool.transport().RoundTrip(withBody(req, body))
+	}
+	for _, ep := range candidates {
+		wait, ok := ep.reserve(time.Now())
+		if !ok {
+			continue
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			case <-timer.C:
+			}
+		}
+
+		epReq, err := t.request(req, ep, body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := ep.roundTripper(t.pool.transport()).RoundTrip(epReq)
+		if err != nil {
+			return nil, err
+		}
+		limited, d, err := limitedResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		if !limited {
+			return resp, nil
+		}
+		ep.backoff(d)
+	}
+
+	return nil, ErrAllEndpointsLimited
+}
+
+//构造发往节点的请求,非原节点时替换url和节点请求头
+func (t *transport) request(req *http.Request, ep *endpoint, body []byte) (*http.Request, error) {
+	epReq := withBody(req, body)
+	if ep.url == t.primary {
+		for key, values := range ep.header() {
+			epReq.Header[key] = values
+		}
+		return epReq, nil
+	}
+
+	u, err := url.Parse(ep.url)
+	if err != nil {
+		return nil, err
+	}
+	epReq.URL = u
+	epReq.Host = u.Host
+	epReq.Header.Del("Authorization")
+	for key, values := range ep.header() {
+		epReq.Header[key] = values
+	}
+
+	return epReq, nil
+}
+
+func withBody(req *http.Request, body []byte) *http.Request {
+	clone := req.Clone(req.Context())
+	if body != nil {
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+		clone.ContentLength = int64(len(body))
+		clone.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	return clone
+}
+
+//检查响应是否为限流(http 429或json-rpc错误码-32005),返回退避时间
+//限流时关闭响应body,否则响应body可正常读取
+func limitedResponse(resp *http.Response) (bool, time.Duration, error) {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		return true, retryAfter(resp.Header.Get("Retry-After")), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, 0, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, 0, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var rpcResp struct {
+		Error *struct {
+			Code int `json:"code"`
+			Data *struct {
+				Rate *struct {
+					BackoffSeconds float64 `json:"backoff_seconds"`
+				} `json:"rate"`
+			} `json:"data"`
+		} `json:"error"`
+	}
+	if len(body) == 0 || body[0] != '{' || json.Unmarshal(body, &rpcResp) != nil {
+		return false, 0, nil
+	}
+	if rpcResp.Error == nil || rpcResp.Error.Code != limitExceededCode {
+		return false, 0, nil
+	}
+	if rpcResp.Error.Data != nil && rpcResp.Error.Data.Rate != nil && rpcResp.Error.Data.Rate.BackoffSeconds > 0 {
+		return true, time.Duration(rpcResp.Error.Data.Rate.BackoffSeconds * float64(time.Second)), nil
+	}
+
+	return true, retryAfter(resp.Header.Get("Retry-After")), nil
+}
+
+//解析Retry-After(秒数或http时间)
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return defaultBackoff
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+
+	return defaultBackoff
+}
@@ -0,0 +1,155 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+)
+
+func dial(t *testing.T, pool *ratelimit.Pool, url string) *ethclient.Client {
+	rpcClient, err := rpc.DialOptions(context.Background(), url, rpc.WithHTTPClient(pool.HTTPClient(url)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ethclient.NewClient(rpcClient)
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+func TestTokenBucketThrottles(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+	pool := ratelimit.NewPool([]string{server.URL()}, ratelimit.Config{RequestsPerSecond: 20, Burst: 1})
+	client := dial(t, pool, server.URL())
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := client.BlockNumber(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Fatalf("5 requests at 20 rps took %s", elapsed)
+	}
+}
+
+func TestDailyBudgetShiftsEndpoint(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	servers, endpoints, closeAll := fakechain.NewServers(chain, 2)
+	defer closeAll()
+	pool := ratelimit.NewPool(nil, ratelimit.Config{})
+	pool.Add(endpoints[0], ratelimit.Config{DailyBudget: 2})
+	pool.Add(endpoints[1], ratelimit.Config{})
+	client := dial(t, pool, endpoints[0])
+
+	for i := 0; i < 3; i++ {
+		_, err := client.BlockNumber(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if servers[0].Calls("eth_blockNumber") != 2 || servers[1].Calls("eth_blockNumber") != 1 {
+		t.Fatalf("calls %d %d", servers[0].Calls("eth_blockNumber"), servers[1].Calls("eth_blockNumber"))
+	}
+	stats := pool.Stats()
+	if stats[0].RequestsToday != 2 || stats[0].DailyBudget != 2 || stats[1].RequestsToday != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimitedResponsesBackOff(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	servers, endpoints, closeAll := fakechain.NewServers(chain, 2)
+	defer closeAll()
+	pool := ratelimit.NewPool(endpoints, ratelimit.Config{})
+	client := dial(t, pool, endpoints[0])
+
+	//http 429
+	servers[0].SetRateLimit(1)
+	for i := 0; i < 3; i++ {
+		_, err := client.BlockNumber(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if servers[0].Calls("eth_blockNumber") != 2 || servers[1].Calls("eth_blockNumber") != 2 {
+		t.Fatalf("calls %d %d", servers[0].Calls("eth_blockNumber"), servers[1].Calls("eth_blockNumber"))
+	}
+	stats := pool.Stats()
+	if stats[0].Limited != 1 || !stats[0].BackoffUntil.After(time.Now()) {
+		t.Fatalf("unexpected stats %+v", stats[0])
+	}
+
+	//json-rpc -32005
+	servers[1].LimitNext("eth_chainId", 1)
+	servers[0].SetRateLimit(0)
+	client = dial(t, pool, endpoints[1])
+	_, err := client.ChainID(context.Background())
+	if err == nil || !strings.Contains(err.Error(), ratelimit.ErrAllEndpointsLimited.Error()) {
+		t.Fatalf("expected all endpoints limited, got %v", err)
+	}
+	if pool.Stats()[1].Limited != 1 {
+		t.Fatalf("unexpected stats %+v", pool.Stats()[1])
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	_, err = client.ChainID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type countingTransport struct {
+	calls atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSetTransportWhileRequesting(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+	pool := ratelimit.NewPool([]string{server.URL()}, ratelimit.Config{})
+	client := dial(t, pool, server.URL())
+
+	counting := &countingTransport{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			pool.SetTransport(counting)
+			pool.SetTransport(http.DefaultTransport)
+		}
+		pool.SetTransport(counting)
+	}()
+	for i := 0; i < 20; i++ {
+		_, err := client.BlockNumber(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	before := counting.calls.Load()
+	_, err := client.BlockNumber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counting.calls.Load() != before+1 {
+		t.Fatalf("request did not use the swapped transport")
+	}
+}
@@ -0,0 +1,274 @@
+package rpcauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+)
+
+//jwt token的刷新间隔,Engine API要求iat与节点时间相差不超过60秒
+const jwtRefreshInterval = 30 * time.Second
+
+//节点认证配置,各项可组合使用
+type Auth struct {
+	//附加的请求头(如Alchemy/QuickNode的api key请求头)
+	Header http.Header
+
+	//Basic认证,Infura为空用户名+project secret
+	Username string
+	Password string
+
+	//Bearer token
+	BearerToken string
+
+	//Engine API风格的jwt密钥(HS256),token定时刷新
+	JWTSecret []byte
+
+	//mTLS客户端证书及校验节点证书的CA(为nil时使用系统CA)
+	ClientCert *tls.Certificate
+	RootCAs    *x509.CertPool
+
+	lock      sync.Mutex
+	jwtToken  string
+	jwtIssued time.Time
+	//基于http.DefaultTransport的transport,多次连接时复用
+	defaultTransport http.RoundTripper
+}
+
+//附加请求头认证
+func HeaderAuth(key string, value string) *Auth {
+	header := make(http.Header)
+	header.Set(key, value)
+
+	return &Auth{Header: header}
+}
+
+//Basic认证
+func BasicAuth(username string, password string) *Auth {
+	return &Auth{Username: username, Password: password}
+}
+
+//Infura project secret认证(空用户名的Basic认证)
+func InfuraAuth(secret string) *Auth {
+	return &Auth{Password: secret}
+}
+
+//Bearer token认证
+func BearerAuth(token string) *Auth {
+	return &Auth{BearerToken: token}
+}
+
+//Engine API风格的jwt认证
+func JWTAuth(secret []byte) *Auth {
+	return &Auth{JWTSecret: secret}
+}
+
+//从hex文件(geth的jwtsecret文件格式)读取jwt密钥
+func LoadJWTSecret(path string) ([]byte, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(bytes)), "0x"))
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != 32 {
+		return nil, errors.New("jwt secret must be 32 bytes")
+	}
+
+	return secret, nil
+}
+
+//从pem文件加载mTLS客户端证书,caFile为空时使用系统CA校验节点证书
+func TLSAuth(certFile string, keyFile string, caFile string) (*Auth, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	auth := &Auth{ClientCert: &cert}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.RootCAs = x509.NewCertPool()
+		if !auth.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caFile)
+		}
+	}
+
+	return auth, nil
+}
+
+//设置认证请求头,每次请求前调用
+func (auth *Auth) Apply(header http.Header) error {
+	for key, values := range auth.Header {
+		header[key] = values
+	}
+	if auth.Username != "" || auth.Password != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)))
+	}
+	if auth.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+auth.BearerToken)
+	}
+	if len(auth.JWTSecret) > 0 {
+		token, err := auth.jwt(time.Now())
+		if err != nil {
+			return err
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	return nil
+}
+
+//获取jwt token,超过刷新间隔时重新签发
+func (auth *Auth) jwt(now time.Time) (string, error) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	if auth.jwtToken != "" && now.Sub(auth.jwtIssued) < jwtRefreshInterval {
+		return auth.jwtToken, nil
+	}
+	token, err := SignJWT(auth.JWTSecret, now)
+	if err != nil {
+		return "", err
+	}
+	auth.jwtToken = token
+	auth.jwtIssued = now
+
+	return token, nil
+}
+
+//签发HS256 jwt,claims仅包含iat
+func SignJWT(secret []byte, issuedAt time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{"iat": issuedAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+//mTLS配置,未设置客户端证书和CA时返回nil
+func (auth *Auth) TLSConfig() *tls.Config {
+	if auth.ClientCert == nil && auth.RootCAs == nil {
+		return nil
+	}
+	config := &tls.Config{RootCAs: auth.RootCAs}
+	if auth.ClientCert != nil {
+		config.Certificates = []tls.Certificate{*auth.ClientCert}
+	}
+
+	return config
+}
+
+//包装http transport,每次请求设置认证请求头,并使用mTLS配置;base为nil时使用http.DefaultTransport
+func (auth *Auth) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		auth.lock.Lock()
+		defer auth.lock.Unlock()
+		if auth.defaultTransport == nil {
+			auth.defaultTransport = auth.newTransport(http.DefaultTransport)
+		}
+		return auth.defaultTransport
+	}
+
+	return auth.newTransport(base)
+}
+
+func (auth *Auth) newTransport(base http.RoundTripper) http.RoundTripper {
+	if tlsConfig := auth.TLSConfig(); tlsConfig != nil {
+		if httpTransport, ok := base.(*http.Transport); ok {
+			httpTransport = httpTransport.Clone()
+			httpTransport.TLSClientConfig = tlsConfig
+			base = httpTransport
+		}
+	}
+
+	return &transport{auth: auth, base: base}
+}
+
+type transport struct {
+	auth *Auth
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	err := t.auth.Apply(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+//连接节点,auth和pool可为nil;http节点的认证只作用于该节点,pool将请求转到其他节点时使用其他节点的认证
+func Dial(endpoint string, auth *Auth, pool *ratelimit.Pool) (*rpc.Client, error) {
+	return DialTimeout(endpoint, auth, pool, 0)
+}
+
+//连接节点并设置超时,http节点为每个请求的超时,ws/ipc节点为建立连接的超时;timeout为0时不超时
+//非http/ws地址按ipc路径连接,不使用auth和pool
+func DialTimeout(endpoint string, auth *Auth, pool *ratelimit.Pool, timeout time.Duration) (*rpc.Client, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var options []rpc.ClientOption
+	if strings.HasPrefix(endpoint, "ws://") || strings.HasPrefix(endpoint, "wss://") {
+		if auth != nil {
+			options = append(options, rpc.WithHTTPAuth(auth.Apply))
+			if tlsConfig := auth.TLSConfig(); tlsConfig != nil {
+				dialer := *websocket.DefaultDialer
+				dialer.TLSClientConfig = tlsConfig
+				options = append(options, rpc.WithWebsocketDialer(dialer))
+			}
+		}
+	} else if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+		var httpClient *http.Client
+		if pool != nil {
+			if auth != nil {
+				pool.SetEndpointTransport(endpoint, auth.Transport(nil))
+			}
+			httpClient = pool.HTTPClient(endpoint)
+		} else if auth != nil {
+			httpClient = &http.Client{Transport: auth.Transport(nil)}
+		} else if timeout > 0 {
+			httpClient = &http.Client{}
+		}
+		if httpClient != nil {
+			httpClient.Timeout = timeout
+			options = append(options, rpc.WithHTTPClient(httpClient))
+		}
+	}
+
+	return rpc.DialOptions(ctx, endpoint, options...)
+}
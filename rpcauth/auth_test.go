@@ -0,0 +1,190 @@
+package rpcauth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+	"github.com/warrior21st/ethblockscanner/rpcauth"
+)
+
+//记录请求头的测试链节点
+type headerRecorder struct {
+	lock    sync.Mutex
+	headers []http.Header
+	rpc     *fakechain.Server
+}
+
+func newHeaderRecorder(t *testing.T) *headerRecorder {
+	recorder := &headerRecorder{rpc: fakechain.NewServer(fakechain.NewChain(1))}
+	t.Cleanup(recorder.rpc.Close)
+
+	return recorder
+}
+
+func (recorder *headerRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	recorder.lock.Lock()
+	recorder.headers = append(recorder.headers, r.Header.Clone())
+	recorder.lock.Unlock()
+	recorder.rpc.ServeHTTP(w, r)
+}
+
+func (recorder *headerRecorder) last() http.Header {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	return recorder.headers[len(recorder.headers)-1]
+}
+
+func callChainID(t *testing.T, endpoint string, auth *rpcauth.Auth, pool *ratelimit.Pool) {
+	t.Helper()
+	rpcClient, err := rpcauth.Dial(endpoint, auth, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ethclient.NewClient(rpcClient)
+	defer client.Close()
+	_, err = client.ChainID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeaderAuths(t *testing.T) {
+	recorder := newHeaderRecorder(t)
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	tests := []struct {
+		auth   *rpcauth.Auth
+		header string
+		want   string
+	}{
+		{rpcauth.HeaderAuth("X-Api-Key", "key"), "X-Api-Key", "key"},
+		{rpcauth.BasicAuth("user", "pass"), "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))},
+		{rpcauth.InfuraAuth("secret"), "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(":secret"))},
+		{rpcauth.BearerAuth("token"), "Authorization", "Bearer token"},
+	}
+	for _, test := range tests {
+		callChainID(t, server.URL, test.auth, nil)
+		if got := recorder.last().Get(test.header); got != test.want {
+			t.Fatalf("%s: got %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	recorder := newHeaderRecorder(t)
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	secret := make([]byte, 32)
+	secret[0] = 1
+
+	callChainID(t, server.URL, rpcauth.JWTAuth(secret), nil)
+	token := strings.TrimPrefix(recorder.last().Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid jwt %q", token)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) != parts[2] {
+		t.Fatal("invalid jwt signature")
+	}
+
+	older, _ := rpcauth.SignJWT(secret, time.Now().Add(-time.Hour))
+	if older == token {
+		t.Fatal("jwt does not depend on iat")
+	}
+}
+
+func TestPoolFallbackUsesEndpointAuth(t *testing.T) {
+	primary := newHeaderRecorder(t)
+	fallback := newHeaderRecorder(t)
+	primaryServer := httptest.NewServer(primary)
+	defer primaryServer.Close()
+	fallbackServer := httptest.NewServer(fallback)
+	defer fallbackServer.Close()
+
+	pool := ratelimit.NewPool(nil, ratelimit.Config{})
+	pool.Add(primaryServer.URL, ratelimit.Config{DailyBudget: 1})
+	pool.SetEndpointTransport(fallbackServer.URL, rpcauth.BearerAuth("fallback").Transport(nil))
+	callChainID(t, primaryServer.URL, rpcauth.HeaderAuth("X-Api-Key", "primary"), pool)
+	callChainID(t, primaryServer.URL, rpcauth.HeaderAuth("X-Api-Key", "primary"), pool)
+
+	if primary.last().Get("X-Api-Key") != "primary" {
+		t.Fatal("primary auth not applied")
+	}
+	header := fallback.last()
+	if header.Get("X-Api-Key") != "" || header.Get("Authorization") != "Bearer fallback" {
+		t.Fatalf("unexpected fallback headers %v", header)
+	}
+}
+
+func TestTLSAuth(t *testing.T) {
+	clientCert := newClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert.Leaf)
+
+	recorder := newHeaderRecorder(t)
+	server := httptest.NewUnstartedServer(recorder)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	callChainID(t, server.URL, &rpcauth.Auth{ClientCert: clientCert, RootCAs: rootCAs}, nil)
+
+	rpcClient, err := rpcauth.Dial(server.URL, &rpcauth.Auth{RootCAs: rootCAs}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+	_, err = ethclient.NewClient(rpcClient).ChainID(context.Background())
+	if err == nil {
+		t.Fatal("expected tls error without a client certificate")
+	}
+}
+
+func newClientCert(t *testing.T) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
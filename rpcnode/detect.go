@@ -0,0 +1,92 @@
+package rpcnode
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+//节点客户端类型
+const (
+	ClientGeth       = "geth"
+	ClientErigon     = "erigon"
+	ClientNethermind = "nethermind"
+	ClientBesu       = "besu"
+	ClientReth       = "reth"
+	ClientUnknown    = "unknown"
+)
+
+//json-rpc方法不存在的错误码
+const methodNotFoundCode = -32601
+
+//节点探测结果,用于启用节点特有的优化
+type NodeInfo struct {
+	//web3_clientVersion返回值,如Geth/v1.14.13-stable/linux-amd64/go1.22
+	ClientVersion string
+	//客户端类型,如geth/erigon
+	Client string
+	//rpc_modules返回的已开启的命名空间及版本,节点不支持时为nil
+	Modules map[string]string
+	//是否支持eth_getBlockReceipts(一次获取区块内所有receipt)
+	BlockReceipts bool
+	//是否支持trace_*(trace_block/trace_transaction等)
+	Trace bool
+}
+
+//探测节点类型及支持的方法,web3_clientVersion失败时返回错误;每个请求的超时为节点配置的超时
+func Detect(client *rpc.Client) (*NodeInfo, error) {
+	return DetectContext(context.Background(), client)
+}
+
+//探测节点类型及支持的方法,ctx用于限制整个探测的时间
+func DetectContext(ctx context.Context, client *rpc.Client) (*NodeInfo, error) {
+	info := &NodeInfo{}
+	err := client.CallContext(ctx, &info.ClientVersion, "web3_clientVersion")
+	if err != nil {
+		return nil, err
+	}
+	info.Client = ParseClient(info.ClientVersion)
+
+	var modules map[string]string
+	if client.CallContext(ctx, &modules, "rpc_modules") == nil {
+		info.Modules = modules
+	}
+	info.BlockReceipts = supports(ctx, client, "eth_getBlockReceipts", "0x0")
+	if info.Modules != nil {
+		_, info.Trace = info.Modules["trace"]
+	} else {
+		info.Trace = supports(ctx, client, "trace_block", "0x0")
+	}
+
+	return info, nil
+}
+
+//根据web3_clientVersion判断客户端类型
+func ParseClient(clientVersion string) string {
+	name := strings.ToLower(strings.SplitN(clientVersion, "/", 2)[0])
+	for _, client := range []string{ClientGeth, ClientErigon, ClientNethermind, ClientBesu, ClientReth} {
+		if name == client {
+			return client
+		}
+	}
+
+	return ClientUnknown
+}
+
+//调用方法判断节点是否支持,方法不存在或未开启时返回false
+func supports(ctx context.Context, client *rpc.Client, method string, args ...interface{}) bool {
+	var result interface{}
+	err := client.CallContext(ctx, &result, method, args...)
+	if err == nil {
+		return true
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == methodNotFoundCode {
+		return false
+	}
+
+	//其他错误(如参数错误)说明方法存在
+	return rpcErr != nil
+}
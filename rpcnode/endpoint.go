@@ -0,0 +1,96 @@
+package rpcnode
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+	"github.com/warrior21st/ethblockscanner/rpcauth"
+)
+
+//节点连接方式
+type Transport string
+
+const (
+	TransportHTTP Transport = "http"
+	TransportWS   Transport = "ws"
+	//本机节点的ipc文件(unix socket/windows named pipe)
+	TransportIPC Transport = "ipc"
+)
+
+//节点配置
+type Endpoint struct {
+	//http/ws地址或ipc文件路径
+	URL       string
+	Transport Transport
+	//建立连接及每个请求的超时(包括节点探测),为0时不超时
+	Timeout time.Duration
+}
+
+//http节点
+func HTTPEndpoint(url string, timeout time.Duration) *Endpoint {
+	return &Endpoint{URL: url, Transport: TransportHTTP, Timeout: timeout}
+}
+
+//websocket节点
+func WSEndpoint(url string, timeout time.Duration) *Endpoint {
+	return &Endpoint{URL: url, Transport: TransportWS, Timeout: timeout}
+}
+
+//ipc节点,path为节点的ipc文件路径(如/data/geth/geth.ipc)
+func IPCEndpoint(path string, timeout time.Duration) *Endpoint {
+	return &Endpoint{URL: path, Transport: TransportIPC, Timeout: timeout}
+}
+
+//根据地址前缀判断连接方式,非http/ws地址按ipc路径处理,ipc://前缀会被去除
+func ParseEndpoint(url string, timeout time.Duration) *Endpoint {
+	switch {
+	case strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://"):
+		return HTTPEndpoint(url, timeout)
+	case strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://"):
+		return WSEndpoint(url, timeout)
+	default:
+		return IPCEndpoint(strings.TrimPrefix(url, "ipc://"), timeout)
+	}
+}
+
+//批量解析节点地址
+func ParseEndpoints(urls []string, timeout time.Duration) []*Endpoint {
+	endpoints := make([]*Endpoint, len(urls))
+	for i, url := range urls {
+		endpoints[i] = ParseEndpoint(url, timeout)
+	}
+
+	return endpoints
+}
+
+//连接节点,auth和pool可为nil且只作用于http/ws节点(pool只作用于http节点);
+//设置了超时的ws/ipc节点通过转发使超时作用于每个请求,相同配置的客户端共用一个连接,连接断开或空闲后自动重新连接
+func Dial(endpoint *Endpoint, auth *rpcauth.Auth, pool *ratelimit.Pool) (*rpc.Client, error) {
+	if endpoint.Transport == TransportHTTP {
+		return rpcauth.DialTimeout(endpoint.URL, auth, pool, endpoint.Timeout)
+	}
+
+	//转发连接会被缓存并重新连接,复制配置避免调用方之后的修改影响连接
+	config := *endpoint
+	endpoint = &config
+	dial := func() (*rpc.Client, error) {
+		if endpoint.Transport == TransportWS {
+			return rpcauth.DialTimeout(endpoint.URL, auth, pool, endpoint.Timeout)
+		}
+		ctx := context.Background()
+		if endpoint.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, endpoint.Timeout)
+			defer cancel()
+		}
+		return rpc.DialIPC(ctx, endpoint.URL)
+	}
+	if endpoint.Timeout <= 0 {
+		return dial()
+	}
+
+	return dialForward(forwardKey{endpoint: config, auth: auth, pool: pool}, dial)
+}
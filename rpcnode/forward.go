@@ -0,0 +1,256 @@
+package rpcnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+	"github.com/warrior21st/ethblockscanner/rpcauth"
+)
+
+//ws/ipc连接空闲超过该时间后关闭,下次调用时重新连接
+const forwardIdleTimeout = time.Minute
+
+//将http json-rpc请求转发到ws/ipc连接的http.RoundTripper,
+//使http客户端的请求超时(Timeout)作用于ws/ipc节点的每次调用
+type forwardTransport struct {
+	dial func() (*rpc.Client, error)
+
+	lock      sync.Mutex
+	client    *rpc.Client
+	calls     int
+	idleTimer *time.Timer
+}
+
+type forwardMessage struct {
+	Version string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Params  []json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage   `json:"result,omitempty"`
+	Error   *forwardError     `json:"error,omitempty"`
+}
+
+type forwardError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+//转发的节点配置,相同配置的客户端共用一个ws/ipc连接
+type forwardKey struct {
+	endpoint Endpoint
+	auth     *rpcauth.Auth
+	pool     *ratelimit.Pool
+}
+
+var (
+	forwardsLock sync.Mutex
+	forwards     = make(map[forwardKey]*forwardTransport)
+)
+
+//连接ws/ipc节点,返回的客户端每次调用不超过timeout;相同节点配置的客户端共用一个连接,
+//关闭客户端不会关闭连接,连接断开或空闲后自动重新连接
+func dialForward(key forwardKey, dial func() (*rpc.Client, error)) (*rpc.Client, error) {
+	forwardsLock.Lock()
+	forward := forwards[key]
+	if forward == nil {
+		forward = &forwardTransport{dial: dial}
+		forward.idleTimer = time.AfterFunc(forwardIdleTimeout, forward.closeIdle)
+		forwards[key] = forward
+	}
+	forwardsLock.Unlock()
+
+	err := forward.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Transport: forward, Timeout: key.endpoint.Timeout}
+	return rpc.DialOptions(context.Background(), "http://"+string(key.endpoint.Transport), rpc.WithHTTPClient(httpClient))
+}
+
+func (forward *forwardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	client, err := forward.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer forward.release()
+
+	var result interface{}
+	if len(body) > 0 && body[0] == '[' {
+		var msgs []*forwardMessage
+		err = json.Unmarshal(body, &msgs)
+		if err != nil {
+			return nil, err
+		}
+		result, err = forwardBatch(req.Context(), client, msgs)
+	} else {
+		msg := &forwardMessage{}
+		err = json.Unmarshal(body, msg)
+		if err != nil {
+			return nil, err
+		}
+		result, err = forwardCall(req.Context(), client, msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(out)),
+		ContentLength: int64(len(out)),
+		Request:       req,
+	}, nil
+}
+
+//转发单个调用,节点返回的json-rpc错误写入响应,连接错误及超时作为请求错误返回
+func forwardCall(ctx context.Context, client *rpc.Client, msg *forwardMessage) (*forwardMessage, error) {
+	var result json.RawMessage
+	err := client.CallContext(ctx, &result, msg.Method, forwardArgs(msg.Params)...)
+	resp := &forwardMessage{Version: "2.0", ID: msg.ID}
+	if err != nil {
+		resp.Error = newForwardError(err)
+		if resp.Error == nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	resp.Result = result
+
+	return resp, nil
+}
+
+func forwardBatch(ctx context.Context, client *rpc.Client, msgs []*forwardMessage) ([]*forwardMessage, error) {
+	batch := make([]rpc.BatchElem, len(msgs))
+	for i, msg := range msgs {
+		batch[i] = rpc.BatchElem{
+			Method: msg.Method,
+			Args:   forwardArgs(msg.Params),
+			Result: &json.RawMessage{},
+		}
+	}
+	err := client.BatchCallContext(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	resps := make([]*forwardMessage, len(msgs))
+	for i, elem := range batch {
+		resps[i] = &forwardMessage{Version: "2.0", ID: msgs[i].ID}
+		if elem.Error != nil {
+			resps[i].Error = newForwardError(elem.Error)
+			if resps[i].Error == nil {
+				resps[i].Error = &forwardError{Code: -32603, Message: elem.Error.Error()}
+			}
+			continue
+		}
+		resps[i].Result = *elem.Result.(*json.RawMessage)
+	}
+
+	return resps, nil
+}
+
+func forwardArgs(params []json.RawMessage) []interface{} {
+	args := make([]interface{}, len(params))
+	for i, param := range params {
+		args[i] = param
+	}
+
+	return args
+}
+
+//节点返回的json-rpc错误,其他错误返回nil
+func newForwardError(err error) *forwardError {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return nil
+	}
+	forwardErr := &forwardError{Code: rpcErr.ErrorCode(), Message: err.Error()}
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		forwardErr.Data = dataErr.ErrorData()
+	}
+
+	return forwardErr
+}
+
+//连接已关闭时重新连接,使连接失败时Dial返回错误
+func (forward *forwardTransport) connect() error {
+	forward.lock.Lock()
+	defer forward.lock.Unlock()
+
+	if forward.client == nil {
+		client, err := forward.dial()
+		if err != nil {
+			return err
+		}
+		forward.client = client
+	}
+	if forward.calls == 0 {
+		forward.idleTimer.Reset(forwardIdleTimeout)
+	}
+
+	return nil
+}
+
+//获取ws/ipc连接,连接已关闭时重新连接
+func (forward *forwardTransport) acquire() (*rpc.Client, error) {
+	forward.lock.Lock()
+	defer forward.lock.Unlock()
+
+	if forward.client == nil {
+		client, err := forward.dial()
+		if err != nil {
+			return nil, err
+		}
+		forward.client = client
+	}
+	forward.calls++
+	forward.idleTimer.Stop()
+
+	return forward.client, nil
+}
+
+func (forward *forwardTransport) release() {
+	forward.lock.Lock()
+	defer forward.lock.Unlock()
+
+	forward.calls--
+	if forward.calls == 0 {
+		forward.idleTimer.Reset(forwardIdleTimeout)
+	}
+}
+
+//关闭空闲的连接,客户端不再使用时连接也会在空闲后关闭
+func (forward *forwardTransport) closeIdle() {
+	forward.lock.Lock()
+	defer forward.lock.Unlock()
+
+	if forward.calls == 0 && forward.client != nil {
+		forward.client.Close()
+		forward.client = nil
+	}
+}
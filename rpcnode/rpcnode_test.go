@@ -0,0 +1,204 @@
+package rpcnode_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		url       string
+		transport rpcnode.Transport
+		path      string
+	}{
+		{"https://mainnet.infura.io/v3/key", rpcnode.TransportHTTP, "https://mainnet.infura.io/v3/key"},
+		{"ws://127.0.0.1:8546", rpcnode.TransportWS, "ws://127.0.0.1:8546"},
+		{"/data/geth/geth.ipc", rpcnode.TransportIPC, "/data/geth/geth.ipc"},
+		{"ipc:///data/erigon/erigon.ipc", rpcnode.TransportIPC, "/data/erigon/erigon.ipc"},
+	}
+	for _, test := range tests {
+		endpoint := rpcnode.ParseEndpoint(test.url, time.Second)
+		if endpoint.Transport != test.transport || endpoint.URL != test.path || endpoint.Timeout != time.Second {
+			t.Fatalf("%s: got %+v", test.url, endpoint)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	server := fakechain.NewServer(fakechain.NewChain(1))
+	defer server.Close()
+
+	rpcClient, err := rpcnode.Dial(rpcnode.HTTPEndpoint(server.URL(), time.Second), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+	info, err := rpcnode.Detect(rpcClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Client != rpcnode.ClientGeth || info.ClientVersion != fakechain.ClientVersion {
+		t.Fatalf("client %s %s", info.Client, info.ClientVersion)
+	}
+	if _, ok := info.Modules["eth"]; !ok {
+		t.Fatalf("modules %v", info.Modules)
+	}
+	if !info.BlockReceipts || info.Trace {
+		t.Fatalf("blockReceipts %t trace %t", info.BlockReceipts, info.Trace)
+	}
+
+	server.DisableMethod("eth_getBlockReceipts")
+	info, err = rpcnode.Detect(rpcClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BlockReceipts {
+		t.Fatal("disabled eth_getBlockReceipts detected")
+	}
+}
+
+func TestParseClient(t *testing.T) {
+	tests := map[string]string{
+		"Geth/v1.14.13-stable/linux-amd64/go1.22":            rpcnode.ClientGeth,
+		"erigon/2.60.10/linux-amd64/go1.22":                  rpcnode.ClientErigon,
+		"Nethermind/v1.29.0+3a6b5d1b/linux-x64/dotnet8.0.10": rpcnode.ClientNethermind,
+		"besu/v24.10.0/linux-x86_64/openjdk-java-21":         rpcnode.ClientBesu,
+		"reth/v1.1.0-1ba631b/x86_64-unknown-linux-gnu":       rpcnode.ClientReth,
+		"bor/v1.5.0": rpcnode.ClientUnknown,
+	}
+	for version, want := range tests {
+		if got := rpcnode.ParseClient(version); got != want {
+			t.Fatalf("%s: got %s, want %s", version, got, want)
+		}
+	}
+}
+
+func TestIPCEndpoint(t *testing.T) {
+	chain := fakechain.NewChain(5)
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "geth.ipc")
+	err := server.ServeIPC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rpcClient, err := rpcnode.Dial(rpcnode.ParseEndpoint(path, time.Second), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ethclient.NewClient(rpcClient)
+	defer client.Close()
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chainID.Int64() != 5 {
+		t.Fatalf("chain id %s", chainID)
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	server := fakechain.NewServer(fakechain.NewChain(1))
+	defer server.Close()
+	server.SetLatency(500 * time.Millisecond)
+
+	rpcClient, err := rpcnode.Dial(rpcnode.HTTPEndpoint(server.URL(), 50*time.Millisecond), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ethclient.NewClient(rpcClient)
+	defer client.Close()
+	start := time.Now()
+	_, err = client.BlockNumber(context.Background())
+	if err == nil || time.Since(start) > 400*time.Millisecond {
+		t.Fatalf("expected timeout, got %v after %s", err, time.Since(start))
+	}
+}
+
+//响应延迟的rpc服务
+type slowService struct{}
+
+func (service *slowService) Sleep(ms int) int {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return ms
+}
+
+func TestIPCTimeout(t *testing.T) {
+	server := rpc.NewServer()
+	err := server.RegisterName("test", &slowService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	path := filepath.Join(t.TempDir(), "slow.ipc")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.ServeListener(listener)
+
+	client, err := rpcnode.Dial(rpcnode.IPCEndpoint(path, 100*time.Millisecond), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	start := time.Now()
+	var result int
+	err = client.CallContext(context.Background(), &result, "test_sleep", 1000)
+	if err == nil || time.Since(start) > 800*time.Millisecond {
+		t.Fatalf("expected timeout, got %v after %s", err, time.Since(start))
+	}
+
+	//超时后的调用正常返回,节点的json-rpc错误保留错误码
+	err = client.CallContext(context.Background(), &result, "test_sleep", 1)
+	if err != nil || result != 1 {
+		t.Fatalf("got %d %v", result, err)
+	}
+	var rpcErr rpc.Error
+	err = client.CallContext(context.Background(), &result, "test_missing")
+	if !errors.As(err, &rpcErr) || rpcErr.ErrorCode() != -32601 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	batch := []rpc.BatchElem{
+		{Method: "test_sleep", Args: []interface{}{2}, Result: new(int)},
+		{Method: "test_missing", Result: new(int)},
+	}
+	err = client.BatchCallContext(context.Background(), batch)
+	if err != nil || *batch[0].Result.(*int) != 2 || batch[0].Error != nil || batch[1].Error == nil {
+		t.Fatalf("unexpected batch %v %v %v", err, batch[0].Error, batch[1].Error)
+	}
+}
+
+func TestDetectIPCWithTimeout(t *testing.T) {
+	server := fakechain.NewServer(fakechain.NewChain(5))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "geth.ipc")
+	err := server.ServeIPC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := rpcnode.Dial(rpcnode.IPCEndpoint(path, time.Second), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	info, err := rpcnode.Detect(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Client != rpcnode.ClientGeth || !info.BlockReceipts || info.Trace {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
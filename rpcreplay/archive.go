@@ -0,0 +1,115 @@
+package rpcreplay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+//录制的一次rpc调用
+type Entry struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	//成功时的结果,结果为null时为"null"
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+//json-rpc错误
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+//方法和参数相同的调用使用同一个key
+func (entry *Entry) key() string {
+	return requestKey(entry.Method, entry.Params)
+}
+
+func requestKey(method string, params json.RawMessage) string {
+	//无参数的请求可能省略params或为null
+	compacted := &bytes.Buffer{}
+	if len(params) == 0 || json.Compact(compacted, params) != nil || compacted.String() == "null" {
+		compacted.Reset()
+		compacted.WriteString("[]")
+	}
+
+	return method + compacted.String()
+}
+
+//录制文件,每行一个json格式的Entry,多个Recorder可共用
+type Archive struct {
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+//创建录制文件,文件已存在时清空
+func CreateArchive(path string) (*Archive, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Archive{
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+//追加录制的调用,写入后立即刷新到文件,进程中断时已录制的调用不丢失
+func (archive *Archive) Add(entry *Entry) error {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	archive.lock.Lock()
+	defer archive.lock.Unlock()
+
+	_, err = archive.writer.Write(append(bytes, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return archive.writer.Flush()
+}
+
+//关闭录制文件
+func (archive *Archive) Close() error {
+	archive.lock.Lock()
+	defer archive.lock.Unlock()
+
+	err := archive.writer.Flush()
+	if err != nil {
+		archive.file.Close()
+		return err
+	}
+
+	return archive.file.Close()
+}
+
+//读取录制文件中的所有调用
+func LoadArchive(path string) ([]*Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*Entry
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		entry := &Entry{}
+		err = decoder.Decode(entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
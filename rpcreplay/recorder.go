@@ -0,0 +1,127 @@
+package rpcreplay
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+	"github.com/warrior21st/ethblockscanner/rpcauth"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
+)
+
+//录制器:将请求转发到上游节点并把每次调用的响应写入录制文件,上游节点可为http、ws或ipc;
+//NewRecorder启动本地http代理,扫描器使用URL()作为节点地址;
+//DialRecorder不启动代理,通过Client()获取进程内的录制客户端(watcher的SetRecordArchive使用该方式)
+type Recorder struct {
+	client  *rpc.Client
+	archive *Archive
+	server  *server
+}
+
+//连接上游节点并启动录制代理,使用完后需调用Close;auth为nil时不认证
+func NewRecorder(endpoint *rpcnode.Endpoint, auth *rpcauth.Auth, archive *Archive) (*Recorder, error) {
+	recorder, err := DialRecorder(endpoint, auth, nil, archive)
+	if err != nil {
+		return nil, err
+	}
+	recorder.server, err = startServer(recorder.call)
+	if err != nil {
+		recorder.client.Close()
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+//连接上游节点但不启动录制代理,使用完后需调用Close;auth、pool为nil时不认证、不限流
+func DialRecorder(endpoint *rpcnode.Endpoint, auth *rpcauth.Auth, pool *ratelimit.Pool, archive *Archive) (*Recorder, error) {
+	client, err := rpcnode.Dial(endpoint, auth, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		client:  client,
+		archive: archive,
+	}, nil
+}
+
+//代理地址,可作为watcher的endpoint;DialRecorder创建的录制器返回空字符串
+func (recorder *Recorder) URL() string {
+	if recorder.server == nil {
+		return ""
+	}
+
+	return recorder.server.url()
+}
+
+//进程内的录制客户端,调用经录制器转发到上游节点并写入录制文件,不经过本地http服务
+func (recorder *Recorder) Client() (*rpc.Client, error) {
+	return dialHandler(recorder.call)
+}
+
+//停止代理并断开上游节点,不关闭录制文件
+func (recorder *Recorder) Close() error {
+	var err error
+	if recorder.server != nil {
+		err = recorder.server.close()
+	}
+	recorder.client.Close()
+
+	return err
+}
+
+//转发请求,批量请求按批量转发;上游返回的json-rpc错误也会录制,网络等错误不录制
+func (recorder *Recorder) call(requests []*rpcRequest) ([]*Entry, error) {
+	elems := make([]rpc.BatchElem, len(requests))
+	results := make([]json.RawMessage, len(requests))
+	for i, request := range requests {
+		var params []json.RawMessage
+		if len(request.Params) > 0 {
+			err := json.Unmarshal(request.Params, &params)
+			if err != nil {
+				return nil, err
+			}
+		}
+		args := make([]interface{}, len(params))
+		for j := range params {
+			args[j] = params[j]
+		}
+		elems[i] = rpc.BatchElem{Method: request.Method, Args: args, Result: &results[i]}
+	}
+
+	if len(elems) == 1 {
+		elems[0].Error = recorder.client.CallContext(context.Background(), elems[0].Result, elems[0].Method, elems[0].Args...)
+	} else {
+		err := recorder.client.BatchCallContext(context.Background(), elems)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]*Entry, len(requests))
+	for i, elem := range elems {
+		entry := &Entry{Method: requests[i].Method, Params: requests[i].Params, Result: results[i]}
+		if elem.Error != nil {
+			rpcErr, ok := elem.Error.(rpc.Error)
+			if !ok {
+				return nil, elem.Error
+			}
+			entry.Result = nil
+			entry.Error = &Error{Code: rpcErr.ErrorCode(), Message: rpcErr.Error()}
+			if dataErr, ok := elem.Error.(rpc.DataError); ok {
+				entry.Error.Data = dataErr.ErrorData()
+			}
+		}
+		entries[i] = entry
+	}
+	for _, entry := range entries {
+		err := recorder.archive.Add(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
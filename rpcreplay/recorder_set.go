@@ -0,0 +1,61 @@
+package rpcreplay
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+	"github.com/warrior21st/ethblockscanner/rpcauth"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
+)
+
+//按节点缓存的录制器,watcher通过Dial为各节点创建进程内的录制客户端,多个节点写入同一录制文件
+type RecorderSet struct {
+	archive *Archive
+
+	lock      sync.Mutex
+	recorders map[*rpcnode.Endpoint]*Recorder
+}
+
+//构造录制器集合,使用完后需调用Close;录制文件由调用方关闭
+func NewRecorderSet(archive *Archive) *RecorderSet {
+	return &RecorderSet{
+		archive:   archive,
+		recorders: make(map[*rpcnode.Endpoint]*Recorder),
+	}
+}
+
+//连接节点的录制客户端,同一节点复用一个录制器;关闭客户端不影响录制器
+func (set *RecorderSet) Dial(endpoint *rpcnode.Endpoint, auth *rpcauth.Auth, pool *ratelimit.Pool) (*rpc.Client, error) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	recorder := set.recorders[endpoint]
+	if recorder == nil {
+		var err error
+		recorder, err = DialRecorder(endpoint, auth, pool, set.archive)
+		if err != nil {
+			return nil, err
+		}
+		set.recorders[endpoint] = recorder
+	}
+
+	return recorder.Client()
+}
+
+//关闭所有录制器,已创建的录制客户端之后的调用返回错误;关闭后仍可调用Dial
+func (set *RecorderSet) Close() error {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	var err error
+	for endpoint, recorder := range set.recorders {
+		closeErr := recorder.Close()
+		if err == nil {
+			err = closeErr
+		}
+		delete(set.recorders, endpoint)
+	}
+
+	return err
+}
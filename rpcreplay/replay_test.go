@@ -0,0 +1,201 @@
+package rpcreplay_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
+	"github.com/warrior21st/ethblockscanner/rpcreplay"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+var depositAddr = common.HexToAddress("0x00000000000000000000000000000000000000d1")
+
+//扫描到head并返回回调的tx的json,archive不为nil时由watcher录制
+func scanJSON(t *testing.T, endpoint string, head uint64, archive *rpcreplay.Archive) []string {
+	t.Helper()
+	var lock sync.Mutex
+	var txs []string
+	watcher := txscanner.NewSimpleTxWatcher([]string{endpoint}, 1, 10*time.Millisecond, func(tx *txscanner.TxInfo) error {
+		data, err := json.Marshal(tx)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+
+		txs = append(txs, string(data))
+		return nil
+	})
+	watcher.AddInterestedTo(depositAddr.Hex())
+	watcher.SetRevertReasons(txscanner.RevertReasonCall)
+	if archive != nil {
+		watcher.SetRecordArchive(archive)
+		defer watcher.SetRecordArchive(nil)
+	}
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), head, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return txs
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	stringType, _ := abi.NewType("string", "", nil)
+	reason, _ := abi.Arguments{{Type: stringType}}.Pack("insufficient balance")
+	chain := fakechain.NewChain(1)
+	chain.Transfer(1, depositAddr, big.NewInt(100))
+	chain.Mine()
+	chain.AddRevertedTx(2, &depositAddr, nil, []byte{0xa9, 0x05, 0x9c, 0xbb}, append(hexutil.MustDecode("0x08c379a0"), reason...))
+	chain.Transfer(3, depositAddr, big.NewInt(7))
+	chain.MineN(2)
+
+	path := filepath.Join(t.TempDir(), "incident.jsonl")
+	archive, err := rpcreplay.CreateArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := fakechain.NewServer(chain)
+	recorder, err := rpcreplay.NewRecorder(rpcnode.ParseEndpoint(upstream.URL(), 0), nil, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := scanJSON(t, recorder.URL(), chain.Head(), nil)
+	recorder.Close()
+	archive.Close()
+	//回放时不访问上游节点
+	upstream.Close()
+
+	if len(recorded) != 3 {
+		t.Fatalf("recorded %d txs", len(recorded))
+	}
+	tx := &txscanner.TxInfo{}
+	err = json.Unmarshal([]byte(recorded[1]), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.RevertReason == nil || tx.RevertReason.Message != "insufficient balance" {
+		t.Fatalf("unexpected revert reason %+v", tx.RevertReason)
+	}
+
+	replayer, err := rpcreplay.NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+	replayed := scanJSON(t, replayer.URL(), chain.Head(), nil)
+	if len(replayed) != len(recorded) {
+		t.Fatalf("replayed %d txs, recorded %d", len(replayed), len(recorded))
+	}
+	for i := range recorded {
+		if replayed[i] != recorded[i] {
+			t.Fatalf("tx %d: replayed %s, recorded %s", i, replayed[i], recorded[i])
+		}
+	}
+	if missing := replayer.Missing(); len(missing) != 0 {
+		t.Fatalf("missing %v", missing)
+	}
+}
+
+func TestWatcherRecordArchive(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.Transfer(1, depositAddr, big.NewInt(100))
+	chain.Mine()
+	chain.Transfer(2, depositAddr, big.NewInt(7))
+	chain.MineN(2)
+
+	path := filepath.Join(t.TempDir(), "watcher.jsonl")
+	archive, err := rpcreplay.CreateArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := fakechain.NewServer(chain)
+	//不经过录制代理,watcher直接连接上游节点并录制
+	recorded := scanJSON(t, upstream.URL(), chain.Head(), archive)
+	archive.Close()
+	upstream.Close()
+	if len(recorded) != 2 {
+		t.Fatalf("recorded %d txs", len(recorded))
+	}
+
+	replayer, err := rpcreplay.NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+	replayed := scanJSON(t, replayer.URL(), chain.Head(), nil)
+	if len(replayed) != len(recorded) {
+		t.Fatalf("replayed %d txs, recorded %d", len(replayed), len(recorded))
+	}
+	for i := range recorded {
+		if replayed[i] != recorded[i] {
+			t.Fatalf("tx %d: replayed %s, recorded %s", i, replayed[i], recorded[i])
+		}
+	}
+	if missing := replayer.Missing(); len(missing) != 0 {
+		t.Fatalf("missing %v", missing)
+	}
+
+	//进程内的回放客户端
+	client, err := replayer.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var chainID hexutil.Big
+	err = client.Call(&chainID, "eth_chainId")
+	if err != nil || chainID.ToInt().Cmp(chain.ChainID()) != 0 {
+		t.Fatalf("chain id %v, err %v", chainID.ToInt(), err)
+	}
+}
+
+func TestReplayerSequenceAndMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.jsonl")
+	archive, err := rpcreplay.CreateArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, number := range []string{`"0x1"`, `"0x2"`} {
+		archive.Add(&rpcreplay.Entry{Method: "eth_blockNumber", Params: json.RawMessage(`[]`), Result: json.RawMessage(number)})
+	}
+	archive.Add(&rpcreplay.Entry{Method: "eth_getBlockByNumber", Params: json.RawMessage(`["0x3",false]`), Error: &rpcreplay.Error{Code: -32601, Message: "disabled"}})
+	archive.Close()
+
+	replayer, err := rpcreplay.NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+	client, err := rpcnode.Dial(rpcnode.ParseEndpoint(replayer.URL(), 0), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	//相同调用按录制顺序返回,用完后重复最后一次
+	for _, want := range []uint64{1, 2, 2} {
+		var number hexutil.Uint64
+		err = client.Call(&number, "eth_blockNumber")
+		if err != nil || uint64(number) != want {
+			t.Fatalf("block number %d, want %d, err %v", number, want, err)
+		}
+	}
+	var block map[string]interface{}
+	err = client.Call(&block, "eth_getBlockByNumber", "0x3", false)
+	if rpcErr, ok := err.(interface{ ErrorCode() int }); !ok || rpcErr.ErrorCode() != -32601 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = client.Call(&block, "eth_getBlockByNumber", "0x4", false)
+	if err == nil || len(replayer.Missing()) != 1 || replayer.Missing()[0] != `eth_getBlockByNumber["0x4",false]` {
+		t.Fatalf("missing %v, err %v", replayer.Missing(), err)
+	}
+}
@@ -0,0 +1,92 @@
+package rpcreplay
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+//未录制的调用返回的json-rpc错误码
+const NotRecordedCode = -32000
+
+//回放服务:本地http json-rpc服务,按录制文件返回响应,不访问网络;
+//方法和参数相同的调用按录制顺序依次返回(如eth_blockNumber),用完后重复返回最后一次的响应;
+//录制文件不区分扫描器,多个扫描器或回溯分片并发录制时,相同调用在回放时的返回顺序可能与录制时不同,
+//按区块号、hash的调用不受影响,需完全复现依赖调用顺序的结果(如eth_blockNumber)时使用单个扫描器录制和回放
+type Replayer struct {
+	server *server
+
+	lock    sync.Mutex
+	entries map[string][]*Entry
+	next    map[string]int
+	missing []string
+}
+
+//读取录制文件并启动回放服务,使用完后需调用Close
+func NewReplayer(path string) (*Replayer, error) {
+	entries, err := LoadArchive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	replayer := &Replayer{
+		entries: make(map[string][]*Entry),
+		next:    make(map[string]int),
+	}
+	for _, entry := range entries {
+		key := entry.key()
+		replayer.entries[key] = append(replayer.entries[key], entry)
+	}
+	replayer.server, err = startServer(replayer.call)
+	if err != nil {
+		return nil, err
+	}
+
+	return replayer, nil
+}
+
+//回放服务地址,可作为watcher的endpoint
+func (replayer *Replayer) URL() string {
+	return replayer.server.url()
+}
+
+//进程内的回放客户端,不经过本地http服务
+func (replayer *Replayer) Client() (*rpc.Client, error) {
+	return dialHandler(replayer.call)
+}
+
+//停止回放服务
+func (replayer *Replayer) Close() error {
+	return replayer.server.close()
+}
+
+//未录制的调用(方法和参数),回放与录制不一致时不为空
+func (replayer *Replayer) Missing() []string {
+	replayer.lock.Lock()
+	defer replayer.lock.Unlock()
+
+	return append([]string(nil), replayer.missing...)
+}
+
+func (replayer *Replayer) call(requests []*rpcRequest) ([]*Entry, error) {
+	replayer.lock.Lock()
+	defer replayer.lock.Unlock()
+
+	entries := make([]*Entry, len(requests))
+	for i, request := range requests {
+		key := requestKey(request.Method, request.Params)
+		recorded := replayer.entries[key]
+		if len(recorded) == 0 {
+			replayer.missing = append(replayer.missing, key)
+			entries[i] = &Entry{Error: &Error{Code: NotRecordedCode, Message: "rpcreplay: no recorded response for " + key}}
+			continue
+		}
+		next := replayer.next[key]
+		if next < len(recorded)-1 {
+			replayer.next[key] = next + 1
+		}
+		entries[i] = recorded[next]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,155 @@
+package rpcreplay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+//本地json-rpc http服务,handle按请求顺序返回录制的调用
+type server struct {
+	listener   net.Listener
+	httpServer *http.Server
+	handle     func(requests []*rpcRequest) ([]*Entry, error)
+}
+
+func startServer(handle func(requests []*rpcRequest) ([]*Entry, error)) (*server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &server{
+		listener: listener,
+		handle:   handle,
+	}
+	server.httpServer = &http.Server{Handler: server}
+	go server.httpServer.Serve(listener)
+
+	return server, nil
+}
+
+func (server *server) url() string {
+	return "http://" + server.listener.Addr().String()
+}
+
+func (server *server) close() error {
+	return server.httpServer.Close()
+}
+
+func (server *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, resp := serveBody(server.handle, body)
+	if status != http.StatusOK {
+		http.Error(w, string(resp), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+//处理json-rpc请求体,返回http状态码和响应体,本地http服务和进程内客户端共用
+func serveBody(handle func(requests []*rpcRequest) ([]*Entry, error), body []byte) (int, []byte) {
+	batch := len(body) > 0 && body[0] == '['
+	var requests []*rpcRequest
+	var err error
+	if batch {
+		err = json.Unmarshal(body, &requests)
+	} else {
+		request := &rpcRequest{}
+		err = json.Unmarshal(body, request)
+		requests = []*rpcRequest{request}
+	}
+	if err != nil {
+		return http.StatusBadRequest, []byte(err.Error())
+	}
+
+	entries, err := handle(requests)
+	if err != nil {
+		//上游节点的http错误(如限流)原样返回,其它错误返回502
+		var httpErr rpc.HTTPError
+		if errors.As(err, &httpErr) {
+			return httpErr.StatusCode, httpErr.Body
+		}
+		return http.StatusBadGateway, []byte(err.Error())
+	}
+
+	responses := make([]*rpcResponse, len(requests))
+	for i, entry := range entries {
+		responses[i] = &rpcResponse{
+			JSONRPC: "2.0",
+			ID:      requests[i].ID,
+			Result:  entry.Result,
+			Error:   entry.Error,
+		}
+		if entry.Error == nil && len(entry.Result) == 0 {
+			responses[i].Result = json.RawMessage("null")
+		}
+	}
+	var resp []byte
+	if batch {
+		resp, err = json.Marshal(responses)
+	} else {
+		resp, err = json.Marshal(responses[0])
+	}
+	if err != nil {
+		return http.StatusInternalServerError, []byte(err.Error())
+	}
+
+	return http.StatusOK, resp
+}
+
+//在进程内处理请求的http.RoundTripper,客户端不经过本地http服务
+type handlerTransport struct {
+	handle func(requests []*rpcRequest) ([]*Entry, error)
+}
+
+func (transport *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	status, resp := serveBody(transport.handle, body)
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(resp)),
+		ContentLength: int64(len(resp)),
+		Request:       req,
+	}, nil
+}
+
+//构造进程内处理请求的客户端
+func dialHandler(handle func(requests []*rpcRequest) ([]*Entry, error)) (*rpc.Client, error) {
+	httpClient := &http.Client{Transport: &handlerTransport{handle: handle}}
+	return rpc.DialOptions(context.Background(), "http://rpcreplay", rpc.WithHTTPClient(httpClient))
+}
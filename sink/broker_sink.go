@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"context"
+)
+
+//kafka生产者,Produce需在broker确认后返回,可由任意kafka客户端库适配实现
+type KafkaProducer interface {
+	Produce(ctx context.Context, topic string, key []byte, value []byte) error
+	Close() error
+}
+
+//kafka sink
+type KafkaSink struct {
+	producer KafkaProducer
+	topic    string
+}
+
+//构造kafka sink
+func NewKafkaSink(producer KafkaProducer, topic string) *KafkaSink {
+	return &KafkaSink{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+func (s *KafkaSink) Send(msg *Message) error {
+	return s.producer.Produce(context.Background(), s.topic, []byte(msg.Key), msg.Payload)
+}
+
+func (s *KafkaSink) Close() error {
+	return s.producer.Close()
+}
+
+//消息key的nats消息头,JetStream按该头在去重窗口内丢弃重复消息
+const NATSMsgIDHeader = "Nats-Msg-Id"
+
+//nats发布者,nats.Conn可直接使用;需要确认时应传入JetStream的适配实现。
+//只实现Publish时消息key不会发送,消费方需按payload中的tx hash(log为tx hash和log index)去重
+type NATSPublisher interface {
+	Publish(subject string, data []byte) error
+}
+
+//支持消息头的nats发布者,如基于nats.Conn.PublishMsg或JetStream PublishMsg的适配实现;
+//实现时消息key写入Nats-Msg-Id头,消费方可按该头去重或路由
+type NATSHeaderPublisher interface {
+	NATSPublisher
+
+	PublishWithHeader(subject string, header map[string][]string, data []byte) error
+}
+
+//nats发布者可选的刷新接口,实现时每次发布后调用Flush等待服务端确认收到
+type natsFlusher interface {
+	Flush() error
+}
+
+//nats sink
+type NATSSink struct {
+	publisher NATSPublisher
+	subject   string
+}
+
+//构造nats sink
+func NewNATSSink(publisher NATSPublisher, subject string) *NATSSink {
+	return &NATSSink{
+		publisher: publisher,
+		subject:   subject,
+	}
+}
+
+func (s *NATSSink) Send(msg *Message) error {
+	var err error
+	if headerPublisher, ok := s.publisher.(NATSHeaderPublisher); ok {
+		err = headerPublisher.PublishWithHeader(s.subject, map[string][]string{NATSMsgIDHeader: {msg.Key}}, msg.Payload)
+	} else {
+		err = s.publisher.Publish(s.subject, msg.Payload)
+	}
+	if err != nil {
+		return err
+	}
+	if flusher, ok := s.publisher.(natsFlusher); ok {
+		return flusher.Flush()
+	}
+
+	return nil
+}
+
+func (s *NATSSink) Close() error {
+	return nil
+}
+
+//redis stream客户端,可由任意redis客户端库适配实现
+type RedisStreamClient interface {
+	//执行XADD,maxLen大于0时近似裁剪stream长度,返回消息id
+	XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error)
+}
+
+//redis stream sink,消息写入key和payload两个字段
+type RedisStreamSink struct {
+	client RedisStreamClient
+	stream string
+	maxLen int64
+}
+
+//构造redis stream sink,maxLen为0时不裁剪
+func NewRedisStreamSink(client RedisStreamClient, stream string, maxLen int64) *RedisStreamSink {
+	return &RedisStreamSink{
+		client: client,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (s *RedisStreamSink) Send(msg *Message) error {
+	_, err := s.client.XAdd(context.Background(), s.stream, s.maxLen, map[string]interface{}{
+		"key":     msg.Key,
+		"payload": string(msg.Payload),
+	})
+
+	return err
+}
+
+func (s *RedisStreamSink) Close() error {
+	return nil
+}
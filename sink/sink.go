@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+//输出目标,Send需在目标确认接收后才返回,返回错误时扫描进度不会前进
+type Sink interface {
+	//发送消息
+	Send(msg *Message) error
+
+	//关闭
+	Close() error
+}
+
+//输出消息
+type Message struct {
+	//消息key,tx为tx hash,log为tx hash_log index
+	Key string
+	//消息内容(json)
+	Payload []byte
+}
+
+//构造tx消息,json编码失败时返回错误,不发送空消息
+func TxMessage(tx *txscanner.TxInfo) (*Message, error) {
+	payload, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Key:     tx.TxHash,
+		Payload: payload,
+	}, nil
+}
+
+//构造log消息
+func LogMessage(txlog *types.Log) (*Message, error) {
+	payload, err := json.Marshal(txlog)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Key:     strings.ToLower(txlog.TxHash.Hex()) + "_" + strconv.FormatUint(uint64(txlog.Index), 10),
+		Payload: payload,
+	}, nil
+}
+
+//构造将tx发送到sink的回调,用于txscanner.NewSimpleTxWatcher
+func TxCallback(s Sink) func(*txscanner.TxInfo) error {
+	return func(tx *txscanner.TxInfo) error {
+		msg, err := TxMessage(tx)
+		if err != nil {
+			return err
+		}
+
+		return s.Send(msg)
+	}
+}
+
+//构造将log发送到sink的回调,用于txlogscanner.SimpleTxLogWatcher.SetCheckedCallback
+func LogCallback(s Sink) func(*types.Log) error {
+	return func(txlog *types.Log) error {
+		msg, err := LogMessage(txlog)
+		if err != nil {
+			return err
+		}
+
+		return s.Send(msg)
+	}
+}
+
+//内存sink,记录收到的消息,可在测试中替代真实的输出目标
+type MemorySink struct {
+	lock     sync.Mutex
+	messages []*Message
+	err      error
+}
+
+//构造内存sink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Send(msg *Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.messages = append(s.messages, msg)
+
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+//设置Send返回的错误,用于模拟输出目标不可用,nil表示恢复
+func (s *MemorySink) SetError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.err = err
+}
+
+//获取已收到的消息
+func (s *MemorySink) Messages() []*Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	messages := make([]*Message, len(s.messages))
+	copy(messages, s.messages)
+
+	return messages
+}
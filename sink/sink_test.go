@@ -0,0 +1,277 @@
+package sink_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/sink"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+//进程内的kafka/nats/redis stream替身,记录收到的消息
+type standIn struct {
+	lock     sync.Mutex
+	keys     []string
+	payloads []string
+	err      error
+}
+
+func (s *standIn) record(key string, payload []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.keys = append(s.keys, key)
+	s.payloads = append(s.payloads, string(payload))
+
+	return nil
+}
+
+func (s *standIn) Produce(ctx context.Context, topic string, key []byte, value []byte) error {
+	return s.record(topic+"/"+string(key), value)
+}
+
+func (s *standIn) Close() error {
+	return nil
+}
+
+func (s *standIn) Publish(subject string, data []byte) error {
+	return s.record(subject, data)
+}
+
+func (s *standIn) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	return "1-0", s.record(stream+"/"+values["key"].(string), []byte(values["payload"].(string)))
+}
+
+func TestBrokerSinks(t *testing.T) {
+	msg := &sink.Message{Key: "0xabc", Payload: []byte(`{"hash":"0xabc"}`)}
+	standIn := &standIn{}
+	sinks := []sink.Sink{
+		sink.NewKafkaSink(standIn, "txs"),
+		sink.NewNATSSink(standIn, "txs.eth"),
+		sink.NewRedisStreamSink(standIn, "txs", 1000),
+	}
+	for _, s := range sinks {
+		err := s.Send(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"txs/0xabc", "txs.eth", "txs/0xabc"}
+	for i, key := range want {
+		if standIn.keys[i] != key || standIn.payloads[i] != string(msg.Payload) {
+			t.Fatalf("message %d: %s %s", i, standIn.keys[i], standIn.payloads[i])
+		}
+	}
+
+	standIn.err = errors.New("broker down")
+	for _, s := range sinks {
+		if s.Send(msg) == nil {
+			t.Fatalf("%T: expected error", s)
+		}
+	}
+}
+
+func TestWebhookSinkSignsAndRetries(t *testing.T) {
+	secret := "secret"
+	var lock sync.Mutex
+	attempts := 0
+	var verified bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		verified = r.Header.Get(sink.WebhookKeyHeader) == "0xabc" &&
+			sink.VerifyWebhook([]byte(secret), r.Header.Get(sink.WebhookTimestampHeader), body, r.Header.Get(sink.WebhookSignatureHeader))
+	}))
+	defer server.Close()
+
+	s := sink.NewWebhookSink(server.URL, secret)
+	s.SetRetry(2, time.Millisecond)
+	err := s.Send(&sink.Message{Key: "0xabc", Payload: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || !verified {
+		t.Fatalf("attempts %d, verified %v", attempts, verified)
+	}
+	if sink.VerifyWebhook([]byte("other"), "1", []byte(`{}`), sink.SignWebhook([]byte(secret), "1", []byte(`{}`))) {
+		t.Fatal("signature verified with the wrong secret")
+	}
+}
+
+//natsHeaderStandIn记录消息头中的key
+type natsHeaderStandIn struct {
+	standIn
+}
+
+func (s *natsHeaderStandIn) PublishWithHeader(subject string, header map[string][]string, data []byte) error {
+	return s.record(subject+"/"+header[sink.NATSMsgIDHeader][0], data)
+}
+
+func TestNATSSinkSendsKeyHeader(t *testing.T) {
+	standIn := &natsHeaderStandIn{}
+	err := sink.NewNATSSink(standIn, "txs.eth").Send(&sink.Message{Key: "0xabc", Payload: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(standIn.keys) != 1 || standIn.keys[0] != "txs.eth/0xabc" {
+		t.Fatalf("unexpected messages %v", standIn.keys)
+	}
+}
+
+func TestWebhookSinkRetriesOnlyTransientErrors(t *testing.T) {
+	for _, c := range []struct {
+		status   int
+		attempts int
+	}{
+		{http.StatusBadRequest, 1},
+		{http.StatusUnauthorized, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusTooManyRequests, 3},
+		{http.StatusBadGateway, 3},
+	} {
+		var lock sync.Mutex
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			attempts++
+			w.WriteHeader(c.status)
+		}))
+
+		s := sink.NewWebhookSink(server.URL, "")
+		s.SetRetry(2, time.Millisecond)
+		err := s.Send(&sink.Message{Key: "k", Payload: []byte(`{}`)})
+		server.Close()
+		if err == nil || attempts != c.attempts {
+			t.Fatalf("status %d: attempts %d, want %d, err %v", c.status, attempts, c.attempts, err)
+		}
+	}
+}
+
+//关闭sink时结束重试等待,不阻塞扫描器停止
+func TestWebhookSinkCloseStopsRetrying(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := sink.NewWebhookSink(server.URL, "")
+	s.SetRetry(5, time.Hour)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Send(&sink.Message{Key: "k", Payload: []byte(`{}`)})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("send still retrying after close")
+	}
+}
+
+func TestTxMessage(t *testing.T) {
+	tx := &txscanner.TxInfo{TxHash: "0xabc"}
+	msg, err := sink.TxMessage(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := tx.MarshalJSON()
+	if msg.Key != "0xabc" || string(msg.Payload) != string(payload) || len(msg.Payload) == 0 {
+		t.Fatalf("unexpected message %s %s", msg.Key, msg.Payload)
+	}
+}
+
+func TestWebhookSinkGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	s := sink.NewWebhookSink(server.URL, "")
+	s.SetRetry(1, time.Millisecond)
+	if s.Send(&sink.Message{Key: "k", Payload: []byte(`{}`)}) == nil {
+		t.Fatal("expected error")
+	}
+}
+
+//sink返回错误时回调返回错误,扫描进度不前进;恢复后重新发送
+func TestLogCallbackHoldsOnSinkError(t *testing.T) {
+	memorySink := sink.NewMemorySink()
+	send := sink.LogCallback(memorySink)
+	txlog := &types.Log{TxHash: common.HexToHash("0xabc"), Index: 2}
+
+	memorySink.SetError(errors.New("sink down"))
+	if send(txlog) == nil {
+		t.Fatal("expected error while the sink is down")
+	}
+	memorySink.SetError(nil)
+	err := send(txlog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := memorySink.Messages()
+	if len(messages) != 1 || messages[0].Key != strings.ToLower(txlog.TxHash.Hex())+"_2" {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+}
+
+//sink返回错误时扫描进度不前进,恢复后tx重新发送
+func TestSinkErrorHoldsScanProgress(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	to := common.HexToAddress("0x00000000000000000000000000000000000000d1")
+	tx := chain.Transfer(1, to, big.NewInt(1))
+	chain.Mine()
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+
+	memorySink := sink.NewMemorySink()
+	send := sink.TxCallback(memorySink)
+	var scanner *txscanner.TxScanner
+	var progress []uint64
+	watcher := txscanner.NewSimpleTxWatcher([]string{server.URL()}, 1, 10*time.Millisecond, func(tx *txscanner.TxInfo) error {
+		if len(progress) < 3 {
+			progress = append(progress, scanner.GetLastScanedBlock())
+			return errors.New("sink down")
+		}
+		return send(tx)
+	})
+	watcher.AddInterestedTo(to.Hex())
+	scanner = txscanner.NewTxScanner(watcher)
+	err := fakechain.ScanTo(scanner, chain.Head(), 20*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blockNumber := range progress {
+		if blockNumber != 0 {
+			t.Fatalf("scan progress advanced to %d while the sink is down", blockNumber)
+		}
+	}
+	messages := memorySink.Messages()
+	if len(messages) != 1 || messages[0].Key != txscanner.NewTxInfo(chain.BlockByNumber(1), tx, chain.Sender(tx)).TxHash {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+}
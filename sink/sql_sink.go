@@ -0,0 +1,374 @@
+package sink
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/txlogscanner"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+//建表语句,兼容postgres和sqlite
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS %sblocks (
+		block_number BIGINT NOT NULL PRIMARY KEY,
+		block_hash TEXT NOT NULL,
+		block_unix_secs BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS %stransactions (
+		tx_hash TEXT NOT NULL PRIMARY KEY,
+		block_number BIGINT NOT NULL,
+		block_hash TEXT NOT NULL,
+		tx_from TEXT NOT NULL,
+		tx_to TEXT NOT NULL,
+		value TEXT NOT NULL,
+		gas BIGINT NOT NULL,
+		gas_price TEXT NOT NULL,
+		nonce BIGINT NOT NULL,
+		call_method_id TEXT NOT NULL,
+		input_data TEXT NOT NULL,
+		chain_id TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS %sreceipts (
+		tx_hash TEXT NOT NULL PRIMARY KEY,
+		block_number BIGINT NOT NULL,
+		status BIGINT NOT NULL,
+		transaction_index BIGINT NOT NULL,
+		gas_used BIGINT NOT NULL,
+		cumulative_gas_used BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS %slogs (
+		tx_hash TEXT NOT NULL,
+		log_index BIGINT NOT NULL,
+		block_number BIGINT NOT NULL,
+		block_hash TEXT NOT NULL,
+		address TEXT NOT NULL,
+		topics TEXT NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (tx_hash, log_index)
+	)`,
+	`CREATE TABLE IF NOT EXISTS %scheckpoints (
+		name TEXT NOT NULL PRIMARY KEY,
+		block_number BIGINT NOT NULL
+	)`,
+}
+
+//数据库sink,将tx/receipt/log幂等写入数据库(postgres或sqlite),
+//扫描进度确认时在同一个数据库事务中写入进度并提交;
+//设置区块回调(BlockCallback/LogBlockCallback)时每个区块提交一次事务,并检测区块重组
+type SQLSink struct {
+	db     *sql.DB
+	prefix string
+	name   string
+
+	lock sync.Mutex
+	tx   *sql.Tx
+	//当前事务回滚过,下次确认进度时需返回错误使扫描器回退
+	rolledBack bool
+}
+
+//构造数据库sink,prefix为表名前缀,name为扫描进度名称(多个扫描器共用数据库时区分进度)
+func NewSQLSink(db *sql.DB, prefix string, name string) *SQLSink {
+	return &SQLSink{
+		db:     db,
+		prefix: prefix,
+		name:   name,
+	}
+}
+
+//创建表
+func (s *SQLSink) CreateSchema() error {
+	for _, schema := range sqlSchema {
+		_, err := s.db.Exec(fmt.Sprintf(schema, s.prefix))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//tx回调,用于txscanner.NewSimpleTxWatcher
+func (s *SQLSink) TxCallback(tx *txscanner.TxInfo) error {
+	return s.write(func(dbTx *sql.Tx) error {
+		err := s.upsertBlock(dbTx, tx.BlockNumber.Uint64(), tx.BlockHash, tx.BlockUnixSecs)
+		if err != nil {
+			return err
+		}
+		_, err = dbTx.Exec(s.query(`INSERT INTO %stransactions (tx_hash, block_number, block_hash, tx_from, tx_to, value, gas, gas_price, nonce, call_method_id, input_data, chain_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (tx_hash) DO UPDATE SET block_number = excluded.block_number, block_hash = excluded.block_hash`),
+			tx.TxHash, tx.BlockNumber.Uint64(), tx.BlockHash, tx.From, tx.To, bigString(tx.Value), tx.Gas, bigString(tx.GasPrice), tx.Nonce, tx.CallMethodID, hexutil.Encode(tx.InputData), bigString(tx.ChainID))
+		if err != nil {
+			return err
+		}
+		_, err = dbTx.Exec(s.query(`INSERT INTO %sreceipts (tx_hash, block_number, status, transaction_index, gas_used, cumulative_gas_used)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (tx_hash) DO UPDATE SET block_number = excluded.block_number, status = excluded.status, transaction_index = excluded.transaction_index, gas_used = excluded.gas_used, cumulative_gas_used = excluded.cumulative_gas_used`),
+			tx.TxHash, tx.BlockNumber.Uint64(), tx.Status, tx.TransactionIndex, tx.GasUsed, tx.CumulativeGasUsed)
+		if err != nil {
+			return err
+		}
+		for _, txlog := range tx.Logs() {
+			err = s.upsertLog(dbTx, txlog)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+//log回调,用于txlogscanner.SimpleTxLogWatcher.SetCheckedCallback,同时写入log所在区块的hash
+func (s *SQLSink) LogCallback(txlog *types.Log) error {
+	return s.write(func(dbTx *sql.Tx) error {
+		err := s.upsertBlockHash(dbTx, txlog.BlockNumber, strings.ToLower(txlog.BlockHash.Hex()))
+		if err != nil {
+			return err
+		}
+		return s.upsertLog(dbTx, txlog)
+	})
+}
+
+//区块回调,用于txscanner.SimpleTxWatcher.SetOnBlock
+func (s *SQLSink) BlockCallback(block *txscanner.BlockInfo) error {
+	return s.commitBlock(block.BlockNumber, block.BlockHash, block.BlockUnixSecs, block.Header.ParentHash)
+}
+
+//区块回调,用于txlogscanner.SimpleTxLogWatcher.SetOnBlock
+func (s *SQLSink) LogBlockCallback(block *txlogscanner.BlockInfo) error {
+	return s.commitBlock(block.BlockNumber, block.BlockHash, block.BlockUnixSecs, block.Header.ParentHash)
+}
+
+//写入区块及扫描进度并提交当前事务;已写入的上一区块hash与parentHash不同时(区块重组)
+//删除上一区块及之后的数据,返回txscanner.RescanError使扫描器从上一区块重新扫描,
+//依次回退直到找到分叉点
+func (s *SQLSink) commitBlock(blockNumber uint64, blockHash string, blockUnixSecs uint64, parentHash common.Hash) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.rolledBack {
+		s.rolledBack = false
+		return errors.New("sql sink transaction rolled back before block commit")
+	}
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+	}
+
+	dbTx := s.tx
+	s.tx = nil
+	if blockNumber > 0 {
+		var storedHash string
+		err := dbTx.QueryRow(s.query(`SELECT block_hash FROM %sblocks WHERE block_number = $1`), blockNumber-1).Scan(&storedHash)
+		if err != nil && err != sql.ErrNoRows {
+			dbTx.Rollback()
+			return err
+		}
+		if storedHash != "" && storedHash != strings.ToLower(parentHash.Hex()) {
+			dbTx.Rollback()
+			err = s.deleteFrom(blockNumber - 1)
+			if err != nil {
+				return err
+			}
+			return &txscanner.RescanError{FromBlock: blockNumber - 1, Reason: "block " + strconv.FormatUint(blockNumber-1, 10) + " reorged"}
+		}
+	}
+	err := s.upsertBlock(dbTx, blockNumber, blockHash, blockUnixSecs)
+	if err == nil {
+		err = s.upsertCheckpoint(dbTx, blockNumber)
+	}
+	if err != nil {
+		dbTx.Rollback()
+		return err
+	}
+
+	return dbTx.Commit()
+}
+
+//在当前事务中写入扫描进度并提交,用于SetUpdateMaxScanedBlock/SetCheckedUpdateMaxScanedBlock
+func (s *SQLSink) UpdateMaxScanedBlock(blockNumber uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.rolledBack {
+		s.rolledBack = false
+		return errors.New("sql sink transaction rolled back before checkpoint")
+	}
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+	}
+
+	dbTx := s.tx
+	s.tx = nil
+	err := s.upsertCheckpoint(dbTx, blockNumber)
+	if err != nil {
+		dbTx.Rollback()
+		return err
+	}
+
+	return dbTx.Commit()
+}
+
+//获取已确认的扫描进度,未确认过时返回0
+func (s *SQLSink) GetMaxScanedBlock() (uint64, error) {
+	var blockNumber uint64
+	err := s.db.QueryRow(s.query(`SELECT block_number FROM %scheckpoints WHERE name = $1`), s.name).Scan(&blockNumber)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return blockNumber, err
+}
+
+//获取已写入的区块hash,用于和链上区块比较检测重组,区块不存在时返回空字符串
+func (s *SQLSink) GetBlockHash(blockNumber uint64) (string, error) {
+	var blockHash string
+	err := s.db.QueryRow(s.query(`SELECT block_hash FROM %sblocks WHERE block_number = $1`), blockNumber).Scan(&blockHash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return blockHash, err
+}
+
+//区块重组时删除fromBlock及之后的数据,并将扫描进度回退到fromBlock-1
+func (s *SQLSink) Rollback(fromBlock uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+		s.rolledBack = true
+	}
+
+	return s.deleteFrom(fromBlock)
+}
+
+//在新事务中删除fromBlock及之后的数据并回退扫描进度,调用方需持有锁且没有进行中的事务
+func (s *SQLSink) deleteFrom(fromBlock uint64) error {
+	dbTx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"logs", "receipts", "transactions", "blocks"} {
+		_, err = dbTx.Exec(fmt.Sprintf("DELETE FROM %s%s WHERE block_number >= $1", s.prefix, table), fromBlock)
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+	}
+	if fromBlock > 0 {
+		err = s.upsertCheckpoint(dbTx, fromBlock-1)
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+	}
+
+	return dbTx.Commit()
+}
+
+//回滚未提交的数据
+func (s *SQLSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Rollback()
+	s.tx = nil
+
+	return err
+}
+
+//在当前事务中执行写入,出错时回滚当前事务
+func (s *SQLSink) write(fn func(dbTx *sql.Tx) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+	}
+	err := fn(s.tx)
+	if err != nil {
+		s.tx.Rollback()
+		s.tx = nil
+		s.rolledBack = true
+	}
+
+	return err
+}
+
+func (s *SQLSink) upsertBlock(dbTx *sql.Tx, blockNumber uint64, blockHash string, blockUnixSecs uint64) error {
+	_, err := dbTx.Exec(s.query(`INSERT INTO %sblocks (block_number, block_hash, block_unix_secs) VALUES ($1, $2, $3)
+		ON CONFLICT (block_number) DO UPDATE SET block_hash = excluded.block_hash, block_unix_secs = excluded.block_unix_secs`),
+		blockNumber, blockHash, blockUnixSecs)
+
+	return err
+}
+
+//只写入区块hash,区块已存在时保留区块时间
+func (s *SQLSink) upsertBlockHash(dbTx *sql.Tx, blockNumber uint64, blockHash string) error {
+	_, err := dbTx.Exec(s.query(`INSERT INTO %sblocks (block_number, block_hash, block_unix_secs) VALUES ($1, $2, 0)
+		ON CONFLICT (block_number) DO UPDATE SET block_hash = excluded.block_hash`),
+		blockNumber, blockHash)
+
+	return err
+}
+
+func (s *SQLSink) upsertLog(dbTx *sql.Tx, txlog *types.Log) error {
+	topics := make([]string, len(txlog.Topics))
+	for i, topic := range txlog.Topics {
+		topics[i] = strings.ToLower(topic.Hex())
+	}
+	_, err := dbTx.Exec(s.query(`INSERT INTO %slogs (tx_hash, log_index, block_number, block_hash, address, topics, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (tx_hash, log_index) DO UPDATE SET block_number = excluded.block_number, block_hash = excluded.block_hash`),
+		strings.ToLower(txlog.TxHash.Hex()), txlog.Index, txlog.BlockNumber, strings.ToLower(txlog.BlockHash.Hex()),
+		strings.ToLower(txlog.Address.Hex()), strings.Join(topics, ","), hexutil.Encode(txlog.Data))
+
+	return err
+}
+
+func (s *SQLSink) upsertCheckpoint(dbTx *sql.Tx, blockNumber uint64) error {
+	_, err := dbTx.Exec(s.query(`INSERT INTO %scheckpoints (name, block_number) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET block_number = excluded.block_number`),
+		s.name, blockNumber)
+
+	return err
+}
+
+func (s *SQLSink) query(format string) string {
+	return fmt.Sprintf(format, s.prefix)
+}
+
+func bigString(n *big.Int) string {
+	if n == nil {
+		return ""
+	}
+
+	return n.String()
+}
@@ -0,0 +1,292 @@
+package sink_test
+
+import (
+	"database/sql"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/sink"
+	"github.com/warrior21st/ethblockscanner/txlogscanner"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	depositAddr   = common.HexToAddress("0x00000000000000000000000000000000000000d1")
+	tokenAddr     = common.HexToAddress("0x00000000000000000000000000000000000000e1")
+	transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+)
+
+func newSQLSink(t *testing.T) (*sink.SQLSink, *sql.DB) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "sink.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dbSink := sink.NewSQLSink(db, "scan_", "deposits")
+	err = dbSink.CreateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dbSink, db
+}
+
+func count(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM scan_" + table).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func assertCheckpoint(t *testing.T, dbSink *sink.SQLSink, want uint64) {
+	t.Helper()
+	got, err := dbSink.GetMaxScanedBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("checkpoint %d, want %d", got, want)
+	}
+}
+
+//构造带receipt的已打包tx
+func minedTxInfo(chain *fakechain.Chain, tx *types.Transaction) *txscanner.TxInfo {
+	txInfo := txscanner.NewTxInfo(chain.TxBlock(tx.Hash()), tx, chain.Sender(tx))
+	txInfo.SetReceipt(chain.Receipt(tx.Hash()))
+
+	return txInfo
+}
+
+func TestSQLSinkUpsertIsIdempotent(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	tx := chain.AddTx(1, &tokenAddr, nil, nil, fakechain.NewLog(tokenAddr, []common.Hash{transferTopic}, nil))
+	chain.Mine()
+	dbSink, db := newSQLSink(t)
+
+	//重新扫描时重复写入同一tx和log
+	for i := 0; i < 2; i++ {
+		txInfo := minedTxInfo(chain, tx)
+		err := dbSink.TxCallback(txInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dbSink.LogCallback(txInfo.Logs()[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dbSink.UpdateMaxScanedBlock(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for table, want := range map[string]int{"blocks": 1, "transactions": 1, "receipts": 1, "logs": 1, "checkpoints": 1} {
+		if n := count(t, db, table); n != want {
+			t.Fatalf("%s: got %d rows, want %d", table, n, want)
+		}
+	}
+	blockHash, err := dbSink.GetBlockHash(1)
+	if err != nil || blockHash != strings.ToLower(chain.BlockByNumber(1).Hash().Hex()) {
+		t.Fatalf("block hash %s, err %v", blockHash, err)
+	}
+	assertCheckpoint(t, dbSink, 1)
+}
+
+func TestSQLSinkCheckpointIsAtomic(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	first := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+	second := chain.AddTx(1, &tokenAddr, nil, nil, fakechain.NewLog(tokenAddr, []common.Hash{transferTopic}, nil))
+	chain.Mine()
+	dbSink, db := newSQLSink(t)
+
+	//未确认进度的数据不提交
+	err := dbSink.TxCallback(minedTxInfo(chain, first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbSink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "transactions"); n != 0 {
+		t.Fatalf("got %d uncommitted transactions", n)
+	}
+
+	err = dbSink.TxCallback(minedTxInfo(chain, first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbSink.UpdateMaxScanedBlock(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCheckpoint(t, dbSink, 1)
+
+	//写入失败时回滚同一事务中已写入的数据,确认进度返回错误
+	_, err = db.Exec("DROP TABLE scan_logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbSink.TxCallback(minedTxInfo(chain, second))
+	if err == nil {
+		t.Fatal("expected write error")
+	}
+	err = dbSink.UpdateMaxScanedBlock(2)
+	if err == nil {
+		t.Fatal("expected checkpoint error after rollback")
+	}
+	if n := count(t, db, "transactions"); n != 1 {
+		t.Fatalf("got %d transactions", n)
+	}
+	assertCheckpoint(t, dbSink, 1)
+}
+
+func TestSQLSinkRollback(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	var txs []*types.Transaction
+	for i := 0; i < 3; i++ {
+		txs = append(txs, chain.Transfer(1, depositAddr, big.NewInt(1)))
+		chain.Mine()
+	}
+	dbSink, db := newSQLSink(t)
+	for _, tx := range txs {
+		err := dbSink.TxCallback(minedTxInfo(chain, tx))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := dbSink.UpdateMaxScanedBlock(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dbSink.Rollback(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count(t, db, "transactions") != 1 || count(t, db, "receipts") != 1 || count(t, db, "blocks") != 1 {
+		t.Fatal("rolled back blocks were not deleted")
+	}
+	if blockHash, err := dbSink.GetBlockHash(2); err != nil || blockHash != "" {
+		t.Fatalf("block hash %s, err %v", blockHash, err)
+	}
+	assertCheckpoint(t, dbSink, 1)
+}
+
+func TestSQLSinkRescansReorgedBlocks(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	kept := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+	chain.Transfer(2, depositAddr, big.NewInt(2))
+	chain.Mine()
+	chain.Transfer(3, depositAddr, big.NewInt(3))
+	chain.Mine()
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+
+	dbSink, db := newSQLSink(t)
+	watcher := txscanner.NewSimpleTxWatcher([]string{server.URL()}, 1, 10*time.Millisecond, dbSink.TxCallback)
+	watcher.AddInterestedTo(depositAddr.Hex())
+	watcher.SetOnBlock(dbSink.BlockCallback)
+	watcher.SetUpdateMaxScanedBlock(dbSink.UpdateMaxScanedBlock)
+	scanner := txscanner.NewTxScanner(watcher)
+	done := make(chan error, 1)
+	go func() {
+		done <- scanner.Start()
+	}()
+	waitBlock := func(blockNumber uint64) {
+		deadline := time.Now().Add(20 * time.Second)
+		for scanner.GetLastScanedBlock() < blockNumber {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for block %d", blockNumber)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitBlock(3)
+	if n := count(t, db, "transactions"); n != 3 {
+		t.Fatalf("got %d transactions", n)
+	}
+
+	//区块2及之后被重组,新分叉的区块3包含另一笔tx
+	chain.Reorg(2)
+	chain.Mine()
+	replaced := chain.Transfer(4, depositAddr, big.NewInt(4))
+	chain.Mine()
+	chain.Mine()
+	waitBlock(chain.Head())
+	scanner.Stop()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hashes []string
+	rows, err := db.Query("SELECT tx_hash FROM scan_transactions ORDER BY block_number")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		rows.Scan(&hash)
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) != 2 || common.HexToHash(hashes[0]) != kept.Hash() || common.HexToHash(hashes[1]) != replaced.Hash() {
+		t.Fatalf("unexpected transactions %v", hashes)
+	}
+	for number := uint64(1); number <= chain.Head(); number++ {
+		blockHash, err := dbSink.GetBlockHash(number)
+		if err != nil || blockHash != strings.ToLower(chain.BlockByNumber(number).Hash().Hex()) {
+			t.Fatalf("block %d hash %s, err %v", number, blockHash, err)
+		}
+	}
+	assertCheckpoint(t, dbSink, chain.Head())
+}
+
+func TestSQLSinkStoresLogBlocks(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.AddTx(1, &tokenAddr, nil, nil, fakechain.NewLog(tokenAddr, []common.Hash{transferTopic}, nil))
+	chain.Mine()
+	chain.Mine()
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+
+	dbSink, db := newSQLSink(t)
+	watcher := txlogscanner.NewSimpleTxLogWatcher([]string{server.URL()}, 1, 10*time.Millisecond, nil)
+	watcher.AddInterestedParams(tokenAddr.Hex(), transferTopic.Hex())
+	watcher.SetCheckedCallback(dbSink.LogCallback)
+	watcher.SetOnBlock(dbSink.LogBlockCallback)
+	watcher.SetCheckedUpdateMaxScanedBlock(dbSink.UpdateMaxScanedBlock)
+	err := fakechain.ScanTo(txlogscanner.NewTxlogScanner(watcher), chain.Head(), 20*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//只扫描log时也写入每个区块的hash和时间
+	if n := count(t, db, "logs"); n != 1 {
+		t.Fatalf("got %d logs", n)
+	}
+	var unixSecs uint64
+	err = db.QueryRow("SELECT block_unix_secs FROM scan_blocks WHERE block_number = 1").Scan(&unixSecs)
+	if err != nil || unixSecs != chain.BlockByNumber(1).Time() {
+		t.Fatalf("block time %d, err %v", unixSecs, err)
+	}
+	blockHash, err := dbSink.GetBlockHash(2)
+	if err != nil || blockHash != strings.ToLower(chain.BlockByNumber(2).Hash().Hex()) {
+		t.Fatalf("block hash %s, err %v", blockHash, err)
+	}
+	assertCheckpoint(t, dbSink, 2)
+}
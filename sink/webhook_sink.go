@@ -0,0 +1,164 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	//消息key请求头
+	WebhookKeyHeader = "X-Scanner-Key"
+	//签名时间戳请求头(unix秒)
+	WebhookTimestampHeader = "X-Scanner-Timestamp"
+	//签名请求头,值为hex(hmac_sha256(secret, timestamp + "." + body))
+	WebhookSignatureHeader = "X-Scanner-Signature"
+)
+
+//http webhook sink,以POST发送json消息,返回2xx视为确认;5xx、429及网络错误时重试,其他状态码不重试
+type WebhookSink struct {
+	url           string
+	secret        []byte
+	client        *http.Client
+	maxRetries    int
+	retryInterval time.Duration
+	ctx           context.Context
+	cancel        context.CancelFunc
+}
+
+//webhook返回的非2xx状态码
+type webhookStatusError struct {
+	url    string
+	status int
+}
+
+func (err *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook %s response status %d", err.url, err.status)
+}
+
+//构造http webhook sink,secret为空时不签名(默认重试5次,首次重试间隔1秒,之后翻倍)
+func NewWebhookSink(url string, secret string) *WebhookSink {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &WebhookSink{
+		url:           url,
+		secret:        []byte(secret),
+		client:        &http.Client{Timeout: 10 * time.Second},
+		maxRetries:    5,
+		retryInterval: time.Second,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+//设置http client
+func (s *WebhookSink) SetHTTPClient(client *http.Client) {
+	s.client = client
+}
+
+//设置最大重试次数和首次重试间隔
+func (s *WebhookSink) SetRetry(maxRetries int, retryInterval time.Duration) {
+	s.maxRetries = maxRetries
+	s.retryInterval = retryInterval
+}
+
+//发送消息,Close后正在进行的请求和重试等待立即结束并返回错误
+func (s *WebhookSink) Send(msg *Message) error {
+	interval := s.retryInterval
+	var err error
+	for i := 0; i <= s.maxRetries; i++ {
+		if i > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-s.ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("webhook %s closed while retrying: %w", s.url, err)
+			}
+			interval *= 2
+		}
+		err = s.post(msg)
+		if err == nil || !retryable(err) || s.ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+//5xx、429及网络错误可重试,其他状态码(如400、401、404)重试也不会成功
+func retryable(err error) bool {
+	var statusErr *webhookStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+
+	return statusErr.status >= 500 || statusErr.status == http.StatusTooManyRequests
+}
+
+func (s *WebhookSink) post(msg *Message) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookKeyHeader, msg.Key)
+	if len(s.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(s.secret, timestamp, msg.Payload))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &webhookStatusError{url: s.url, status: resp.StatusCode}
+	}
+
+	return nil
+}
+
+//关闭sink,取消正在进行的请求和重试;停止扫描时可先关闭sink,使阻塞在重试中的回调立即返回
+func (s *WebhookSink) Close() error {
+	s.cancel()
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+//计算webhook签名
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//校验webhook签名,供接收方使用
+func VerifyWebhook(secret []byte, timestamp string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(SignWebhook(secret, timestamp, body))
+	if err != nil {
+		return false
+	}
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(expected, actual)
+}
@@ -1,35 +1,65 @@
 package txlogscanner
 
 import (
-	"encoding/base64"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/warrior21st/ethblockscanner/filewatch"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+	"github.com/warrior21st/ethblockscanner/rpcauth"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
+	"github.com/warrior21st/ethblockscanner/rpcreplay"
+	"github.com/warrior21st/ethblockscanner/txscanner"
 )
 
 //简单交易管理结构
 type SimpleTxLogWatcher struct {
-	endpoints            []string
+	endpoints            []*rpcnode.Endpoint
+	rateLimitPool        *ratelimit.Pool
+	endpointAuths        []*rpcauth.Auth
 	infuraSecrets        []string
 	perScanBlockCount    uint64
 	scanStartBlock       uint64
-	interestedLogs       map[string]interface{}
-	interestedAddresses  []common.Address
-	interestedTopics     []common.Hash
+	interestedLogs       map[string]*InterestedLog
 	scanInterval         time.Duration
 	callback             func(*types.Log)
+	checkedCallback      func(*types.Log) error
 	updateMaxScanedBlock func(uint64)
+
+	checkedUpdateMaxScanedBlock func(uint64) error
+	onBlock                     func(*BlockInfo) error
+	enricher                    *txscanner.Enricher
+	enrichedCallback            func(*types.Log, *txscanner.AddressInfo) error
+
+	lock             sync.RWMutex
+	backfillRequests []*BackfillRequest
+	recorders        *rpcreplay.RecorderSet
+}
+
+//关注的log参数
+type InterestedLog struct {
+	Address string `json:"address"`
+	Topic0  string `json:"topic0"`
+}
+
+//关注log配置文件结构
+type InterestedConfig struct {
+	Logs []*InterestedLog `json:"logs"`
+
+	//新增的log参数从该区块开始回溯扫描,为0则不回溯
+	BackfillFromBlock uint64 `json:"backfillFromBlock"`
 }
 
 //构造一个新的简单tx管理结构(默认3秒钟扫描一次)
 func NewSimpleTxLogWatcher(endpoints []string, scanStartBlock uint64, scanInterval time.Duration, callback func(*types.Log)) *SimpleTxLogWatcher {
 
 	return &SimpleTxLogWatcher{
-		endpoints:         endpoints,
+		endpoints:         rpcnode.ParseEndpoints(endpoints, 0),
 		scanStartBlock:    scanStartBlock,
 		scanInterval:      scanInterval,
 		callback:          callback,
@@ -41,30 +71,186 @@ func (watcher *SimpleTxLogWatcher) SetUpdateMaxScanedBlock(callback func(uint64)
 	watcher.updateMaxScanedBlock = callback
 }
 
+//设置可返回错误的扫描进度确认回调,返回错误时扫描进度不会前进
+func (watcher *SimpleTxLogWatcher) SetCheckedUpdateMaxScanedBlock(callback func(uint64) error) {
+	watcher.checkedUpdateMaxScanedBlock = callback
+}
+
+func (watcher *SimpleTxLogWatcher) CheckedUpdateMaxScanedBlock(blockNumber uint64) error {
+	if watcher.checkedUpdateMaxScanedBlock != nil {
+		return watcher.checkedUpdateMaxScanedBlock(blockNumber)
+	}
+	watcher.UpdateMaxScanedBlock(blockNumber)
+
+	return nil
+}
+
+//设置区块扫描完成回调,区块内所有log回调成功后调用
+func (watcher *SimpleTxLogWatcher) SetOnBlock(onBlock func(*BlockInfo) error) {
+	watcher.onBlock = onBlock
+}
+
+func (watcher *SimpleTxLogWatcher) GetOnBlock() func(*BlockInfo) error {
+	return watcher.onBlock
+}
+
+//设置节点限流池,节点被限流或额度用尽时请求转到池中的其他节点
+func (watcher *SimpleTxLogWatcher) SetRateLimitPool(pool *ratelimit.Pool) {
+	watcher.rateLimitPool = pool
+}
+
+//设置节点(http/ws/ipc及超时),替换构造时传入的节点地址
+func (watcher *SimpleTxLogWatcher) SetEndpoints(endpoints []*rpcnode.Endpoint) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	watcher.endpoints = endpoints
+	if watcher.recorders != nil {
+		watcher.recorders.Close()
+	}
+}
+
+//设置录制文件,设置后各节点的请求经进程内的录制器转发,每次调用的响应写入录制文件,可用rpcreplay.NewReplayer回放;
+//为nil时停止录制,录制文件由调用方关闭
+func (watcher *SimpleTxLogWatcher) SetRecordArchive(archive *rpcreplay.Archive) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	if watcher.recorders != nil {
+		watcher.recorders.Close()
+		watcher.recorders = nil
+	}
+	if archive != nil {
+		watcher.recorders = rpcreplay.NewRecorderSet(archive)
+	}
+}
+
+//设置Infura project secret,按下标对应节点
 func (watcher *SimpleTxLogWatcher) SetInfuraSecrets(secrets []string) {
 	watcher.infuraSecrets = secrets
 }
 
-//添加关注的from address
+//设置节点认证(请求头/Basic/Bearer/jwt/mTLS),按下标对应节点,为nil的节点使用Infura secret或不认证
+func (watcher *SimpleTxLogWatcher) SetEndpointAuths(auths []*rpcauth.Auth) {
+	watcher.endpointAuths = auths
+}
+
+func (watcher *SimpleTxLogWatcher) endpointAuth(i int) *rpcauth.Auth {
+	if i < len(watcher.endpointAuths) && watcher.endpointAuths[i] != nil {
+		return watcher.endpointAuths[i]
+	}
+	if i < len(watcher.infuraSecrets) && strings.Trim(watcher.infuraSecrets[i], " ") != "" {
+		return rpcauth.InfuraAuth(watcher.infuraSecrets[i])
+	}
+
+	return nil
+}
+
+//添加关注的log参数
 func (watcher *SimpleTxLogWatcher) AddInterestedParams(address string, topic0 string) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
 	if watcher.interestedLogs == nil {
-		watcher.interestedLogs = make(map[string]interface{})
+		watcher.interestedLogs = make(map[string]*InterestedLog)
+	}
+	watcher.interestedLogs[interestedLogKey(address, topic0)] = &InterestedLog{Address: address, Topic0: topic0}
+}
+
+//添加关注的log参数,并从fromBlock开始回溯扫描
+func (watcher *SimpleTxLogWatcher) AddInterestedParamsWithBackfill(address string, topic0 string, fromBlock uint64) {
+	watcher.AddInterestedParams(address, topic0)
+
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	watcher.backfillRequests = append(watcher.backfillRequests, &BackfillRequest{Address: address, Topic0: topic0, FromBlock: fromBlock})
+}
+
+//移除关注的log参数
+func (watcher *SimpleTxLogWatcher) RemoveInterestedParams(address string, topic0 string) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	delete(watcher.interestedLogs, interestedLogKey(address, topic0))
+}
+
+//从配置文件加载关注的log参数,替换当前的关注列表
+func (watcher *SimpleTxLogWatcher) LoadInterestedFile(path string) error {
+	config := &InterestedConfig{}
+	err := filewatch.LoadJSON(path, config)
+	if err != nil {
+		return err
 	}
-	watcher.interestedLogs[strings.ToLower(address+"_"+topic0)] = true
 
-	if watcher.interestedAddresses == nil {
-		watcher.interestedAddresses = make([]common.Address, 1)
+	interestedLogs := make(map[string]*InterestedLog, len(config.Logs))
+	for _, interestedLog := range config.Logs {
+		interestedLogs[interestedLogKey(interestedLog.Address, interestedLog.Topic0)] = interestedLog
 	}
-	watcher.interestedAddresses = append(watcher.interestedAddresses, common.HexToAddress(address))
-	watcher.interestedTopics = append(watcher.interestedTopics, common.HexToHash(topic0))
+
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	if config.BackfillFromBlock > 0 {
+		for key, interestedLog := range interestedLogs {
+			if _, b := watcher.interestedLogs[key]; !b {
+				watcher.backfillRequests = append(watcher.backfillRequests, &BackfillRequest{Address: interestedLog.Address, Topic0: interestedLog.Topic0, FromBlock: config.BackfillFromBlock})
+			}
+		}
+	}
+	watcher.interestedLogs = interestedLogs
+
+	return nil
+}
+
+//定时检查配置文件,文件修改后自动重新加载关注的log参数,返回停止检查的方法
+func (watcher *SimpleTxLogWatcher) WatchInterestedFile(path string, interval time.Duration) (func(), error) {
+	return filewatch.Watch(path, interval, watcher.LoadInterestedFile, LogToConsole)
+}
+
+//取出待回溯扫描的请求
+func (watcher *SimpleTxLogWatcher) PopBackfillRequests() []*BackfillRequest {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	requests := watcher.backfillRequests
+	watcher.backfillRequests = nil
+
+	return requests
 }
 
 func (watcher *SimpleTxLogWatcher) GetInterestedAddresses() []common.Address {
-	return watcher.interestedAddresses
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	exists := make(map[common.Address]bool, len(watcher.interestedLogs))
+	addresses := make([]common.Address, 0, len(watcher.interestedLogs))
+	for _, interestedLog := range watcher.interestedLogs {
+		address := common.HexToAddress(interestedLog.Address)
+		if !exists[address] {
+			exists[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
 }
 
 func (watcher *SimpleTxLogWatcher) GetInterestedTopics() []common.Hash {
-	return watcher.interestedTopics
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	exists := make(map[common.Hash]bool, len(watcher.interestedLogs))
+	topics := make([]common.Hash, 0, len(watcher.interestedLogs))
+	for _, interestedLog := range watcher.interestedLogs {
+		topic := common.HexToHash(interestedLog.Topic0)
+		if !exists[topic] {
+			exists[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
 }
 
 func (watcher *SimpleTxLogWatcher) GetScanStartBlock() uint64 {
@@ -73,15 +259,22 @@ func (watcher *SimpleTxLogWatcher) GetScanStartBlock() uint64 {
 }
 
 func (watcher *SimpleTxLogWatcher) GetEthClients() ([]*ethclient.Client, error) {
-	clients := make([]*ethclient.Client, len(watcher.endpoints))
-	for i := 0; i < len(watcher.endpoints); i++ {
-		rpcClient, err := rpc.Dial(watcher.endpoints[i])
+	watcher.lock.RLock()
+	endpoints := watcher.endpoints
+	recorders := watcher.recorders
+	watcher.lock.RUnlock()
+	clients := make([]*ethclient.Client, len(endpoints))
+	for i := 0; i < len(endpoints); i++ {
+		var rpcClient *rpc.Client
+		var err error
+		if recorders != nil {
+			rpcClient, err = recorders.Dial(endpoints[i], watcher.endpointAuth(i), watcher.rateLimitPool)
+		} else {
+			rpcClient, err = rpcnode.Dial(endpoints[i], watcher.endpointAuth(i), watcher.rateLimitPool)
+		}
 		if err != nil {
 			return nil, err
 		}
-		if i < len(watcher.infuraSecrets) && strings.Trim(watcher.infuraSecrets[i], " ") != "" {
-			rpcClient.SetHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+watcher.infuraSecrets[i])))
-		}
 
 		clients[i] = ethclient.NewClient(rpcClient)
 	}
@@ -90,8 +283,10 @@ func (watcher *SimpleTxLogWatcher) GetEthClients() ([]*ethclient.Client, error)
 }
 
 func (watcher *SimpleTxLogWatcher) IsInterestedLog(addr string, topic0 string) bool {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
 
-	return watcher.interestedLogs[strings.ToLower(addr+"_"+topic0)] != nil
+	return watcher.interestedLogs[interestedLogKey(addr, topic0)] != nil
 }
 
 //tx回调处理方法
@@ -99,6 +294,44 @@ func (watcher *SimpleTxLogWatcher) Callback(tx *types.Log) {
 	watcher.callback(tx)
 }
 
+//设置可返回错误的回调,设置后回调返回错误时扫描进度不会前进
+func (watcher *SimpleTxLogWatcher) SetCheckedCallback(callback func(*types.Log) error) {
+	watcher.checkedCallback = callback
+}
+
+func (watcher *SimpleTxLogWatcher) CheckedCallback(tx *types.Log) error {
+	if watcher.checkedCallback != nil {
+		return watcher.checkedCallback(tx)
+	}
+	if watcher.callback != nil {
+		watcher.callback(tx)
+	}
+
+	return nil
+}
+
+//设置log标注,log回调前标注log的合约地址
+func (watcher *SimpleTxLogWatcher) SetEnricher(enricher *txscanner.Enricher) {
+	watcher.enricher = enricher
+}
+
+func (watcher *SimpleTxLogWatcher) GetEnricher() *txscanner.Enricher {
+	return watcher.enricher
+}
+
+//设置标注后的log回调,未设置时使用CheckedCallback
+func (watcher *SimpleTxLogWatcher) SetEnrichedCallback(callback func(*types.Log, *txscanner.AddressInfo) error) {
+	watcher.enrichedCallback = callback
+}
+
+func (watcher *SimpleTxLogWatcher) EnrichedCallback(tx *types.Log, info *txscanner.AddressInfo) error {
+	if watcher.enrichedCallback != nil {
+		return watcher.enrichedCallback(tx, info)
+	}
+
+	return watcher.CheckedCallback(tx)
+}
+
 //获取区块扫描间隔
 func (watcher *SimpleTxLogWatcher) GetScanInterval() time.Duration {
 	return watcher.scanInterval
@@ -122,3 +355,7 @@ func (watcher *SimpleTxLogWatcher) GetPerScanBlockCount() uint64 {
 func (watcher *SimpleTxLogWatcher) SetPerScanBlockCount(perScanBlockCount uint64) {
 	watcher.perScanBlockCount = perScanBlockCount
 }
+
+func interestedLogKey(address string, topic0 string) string {
+	return strings.ToLower(address + "_" + topic0)
+}
@@ -2,15 +2,21 @@ package txlogscanner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/warrior21st/ethblockscanner/txscanner"
 )
 
 // var (
@@ -46,17 +52,117 @@ type TxlogWatcher interface {
 	UpdateMaxScanedBlock(blockNumber uint64)
 }
 
+//回调可返回错误的watcher,回调返回错误时本次扫描的区块不会被确认,下次重新扫描
+type CheckedTxlogWatcher interface {
+	TxlogWatcher
+
+	//tx log回调处理方法
+	CheckedCallback(txlog *types.Log) error
+}
+
+//扫描进度确认可返回错误的watcher,返回错误时本次扫描的区块不会被确认,下次重新扫描
+type CheckpointTxlogWatcher interface {
+	TxlogWatcher
+
+	//更新已扫描的最大区块号
+	CheckedUpdateMaxScanedBlock(blockNumber uint64) error
+}
+
+//支持区块扫描完成回调的watcher
+type BlockTxlogWatcher interface {
+	TxlogWatcher
+
+	//获取区块扫描完成回调,区块内所有log回调成功后调用(包括没有匹配log的区块),
+	//回调返回错误时从该区块重新扫描,返回txscanner.RescanError时从其FromBlock重新扫描;为nil时不回调.
+	//设置后每个区块需额外查询header和交易数
+	GetOnBlock() func(block *BlockInfo) error
+}
+
+//区块扫描完成信息
+type BlockInfo struct {
+	Header        *types.Header
+	BlockNumber   uint64
+	BlockHash     string
+	BlockUnixSecs uint64
+	//London之前的区块为nil
+	BaseFee  *big.Int
+	GasUsed  uint64
+	GasLimit uint64
+	//区块内的交易数
+	TxCount int
+	//匹配并回调成功的log数
+	MatchedLogCount int
+}
+
+//标注log合约地址的watcher,扫描器在本次扫描的log回调前标注所有匹配log的合约地址,
+//标注出错时不回调并重新扫描
+type EnrichTxlogWatcher interface {
+	TxlogWatcher
+
+	//获取log标注,为nil时不标注
+	GetEnricher() *txscanner.Enricher
+
+	//标注后的log回调,info为log合约地址的标注信息,返回错误时重新扫描
+	EnrichedCallback(txlog *types.Log, info *txscanner.AddressInfo) error
+}
+
+//回溯扫描请求
+type BackfillRequest struct {
+	Address   string
+	Topic0    string
+	FromBlock uint64
+}
+
+//支持运行时新增log参数回溯扫描的watcher
+type BackfillTxlogWatcher interface {
+	TxlogWatcher
+
+	//取出待回溯扫描的请求
+	PopBackfillRequests() []*BackfillRequest
+}
+
+//回溯扫描时单次查询的区块数
+const backfillPerScanBlockCount = 2000
+
+//获取区块扫描完成信息出错时的最大重试次数
+const maxBlockInfoRetries = 3
+
+//交易log扫描器,同一进程可运行多个实例
+type TxlogScanner struct {
+	lastScanedBlockNumber uint64
+
+	txlogWatcher TxlogWatcher
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+//构造交易log扫描器
+func NewTxlogScanner(txlogWatcher TxlogWatcher) *TxlogScanner {
+	return &TxlogScanner{
+		txlogWatcher: txlogWatcher,
+		stop:         make(chan struct{}),
+	}
+}
+
 //开始扫描
 func StartScanTxLogs(txlogWatcher TxlogWatcher) error {
+	return NewTxlogScanner(txlogWatcher).Start()
+}
+
+//开始扫描,阻塞直到调用Stop;出错后再次调用时从上次扫描到的区块继续
+func (scanner *TxlogScanner) Start() error {
 	LogToConsole("eth tx log scanner starting...")
 	// _clientSleepTimes = make(map[int]int64)
-	startBlock := txlogWatcher.GetScanStartBlock()
-	if startBlock > 0 {
-		startBlock = startBlock - 2
-	}
-	lastScanedBlockNumber := uint64(0)
-	if startBlock > 0 {
-		lastScanedBlockNumber = startBlock
+	txlogWatcher := scanner.txlogWatcher
+	lastScanedBlockNumber := scanner.GetLastScanedBlock()
+	if lastScanedBlockNumber == 0 {
+		startBlock := txlogWatcher.GetScanStartBlock()
+		if startBlock > 0 {
+			startBlock = startBlock - 2
+		}
+		if startBlock > 0 {
+			lastScanedBlockNumber = startBlock
+		}
 	}
 	clients, err := txlogWatcher.GetEthClients()
 	if err != nil {
@@ -72,24 +178,37 @@ func StartScanTxLogs(txlogWatcher TxlogWatcher) error {
 	// 	scanInterval = 0
 	// }
 	errCount := 0
-	for true {
+	for !scanner.isStopped() {
 		scanedBlock, err := scanTxLogs(clients[0], lastScanedBlockNumber+1, txlogWatcher)
-		if err != nil {
+		var rescan *txscanner.RescanError
+		if errors.As(err, &rescan) && rescan.FromBlock > 0 && rescan.FromBlock <= scanedBlock+1 {
+			LogToConsole(err.Error())
+			lastScanedBlockNumber = rescan.FromBlock - 1
+		} else if err != nil {
 			if scanedBlock > 0 {
 				lastScanedBlockNumber = scanedBlock
 			} else {
 				errCount++
 			}
+		} else if err = updateMaxScanedBlock(txlogWatcher, scanedBlock); err != nil {
+			LogToConsole(fmt.Sprintf("update max scaned block error: %s,rescan from block %d.", err.Error(), lastScanedBlockNumber+1))
+			errCount++
 		} else {
-			txlogWatcher.UpdateMaxScanedBlock(scanedBlock)
 			lastScanedBlockNumber = scanedBlock
 			errCount = 0
 		}
+		atomic.StoreUint64(&scanner.lastScanedBlockNumber, lastScanedBlockNumber)
+
+		if backfillWatcher, ok := txlogWatcher.(BackfillTxlogWatcher); ok {
+			for _, request := range backfillWatcher.PopBackfillRequests() {
+				backfillTxLogs(clients[0], request, lastScanedBlockNumber, txlogWatcher)
+			}
+		}
 
 		//如果连续报错达到10次，则线程睡眠10秒后继续
 		if errCount == 10 {
 			LogToConsole("scaning block continuous error " + strconv.Itoa(errCount) + " times,sleep 30s...")
-			time.Sleep(30 * time.Second)
+			scanner.sleep(30 * time.Second)
 			errCount = 0
 		}
 
@@ -138,15 +257,207 @@ func scanTxLogs(client *ethclient.Client, startBlock uint64, txlogWatcher TxlogW
 		logs, err = client.FilterLogs(context.Background(), filter)
 	}
 
-	for _, log := range logs {
-		if txlogWatcher.IsInterestedLog(log.Address.Hex(), log.Topics[0].Hex()) {
-			txlogWatcher.Callback(&log)
+	var onBlock func(*BlockInfo) error
+	if blockWatcher, ok := txlogWatcher.(BlockTxlogWatcher); ok {
+		onBlock = blockWatcher.GetOnBlock()
+	}
+	if onBlock == nil {
+		infos, err := enrichLogs(txlogWatcher, logs)
+		if err != nil {
+			LogToConsole(fmt.Sprintf("enrich tx logs error: %s,rescan block %s - %s after 1s...", err.Error(), filter.FromBlock.String(), filter.ToBlock.String()))
+			time.Sleep(time.Second)
+			return startBlock - 1, err
+		}
+		for i, log := range logs {
+			if txlogWatcher.IsInterestedLog(log.Address.Hex(), log.Topics[0].Hex()) {
+				err = callback(txlogWatcher, &log, infos[i])
+				if err != nil {
+					LogToConsole(fmt.Sprintf("tx log callback error: %s,rescan block %s - %s after 1s...", err.Error(), filter.FromBlock.String(), filter.ToBlock.String()))
+					time.Sleep(time.Second)
+					return startBlock - 1, err
+				}
+			}
+		}
+
+		return filter.ToBlock.Uint64(), nil
+	}
+
+	//按区块依次回调log,每个区块的log回调成功后回调区块扫描完成
+	sort.SliceStable(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+	infos, err := enrichLogs(txlogWatcher, logs)
+	if err != nil {
+		LogToConsole(fmt.Sprintf("enrich tx logs error: %s,rescan block %s - %s after 1s...", err.Error(), filter.FromBlock.String(), filter.ToBlock.String()))
+		time.Sleep(time.Second)
+		return startBlock - 1, err
+	}
+	logIndex := 0
+	for blockNumber := filter.FromBlock.Uint64(); blockNumber <= filter.ToBlock.Uint64(); blockNumber++ {
+		matchedLogCount := 0
+		for ; logIndex < len(logs) && logs[logIndex].BlockNumber == blockNumber; logIndex++ {
+			log := &logs[logIndex]
+			if !txlogWatcher.IsInterestedLog(log.Address.Hex(), log.Topics[0].Hex()) {
+				continue
+			}
+			err = callback(txlogWatcher, log, infos[logIndex])
+			if err != nil {
+				//之前的区块已回调完成,从当前区块重新扫描
+				LogToConsole(fmt.Sprintf("tx log callback error: %s,rescan from block %d after 1s...", err.Error(), blockNumber))
+				time.Sleep(time.Second)
+				return blockNumber - 1, err
+			}
+			matchedLogCount++
+		}
+
+		blockInfo, err := getBlockInfo(client, blockNumber)
+		if err != nil {
+			LogToConsole(fmt.Sprintf("get block %d info error: %s,rescan from block %d after 1s...", blockNumber, err.Error(), blockNumber))
+			time.Sleep(time.Second)
+			return blockNumber - 1, err
+		}
+		blockInfo.MatchedLogCount = matchedLogCount
+		err = onBlock(blockInfo)
+		if err != nil {
+			LogToConsole(fmt.Sprintf("block %d callback error: %s,rescan from block %d after 1s...", blockNumber, err.Error(), blockNumber))
+			time.Sleep(time.Second)
+			return blockNumber - 1, err
 		}
 	}
 
 	return filter.ToBlock.Uint64(), nil
 }
 
+//获取已扫描的最大区块号
+func (scanner *TxlogScanner) GetLastScanedBlock() uint64 {
+	return atomic.LoadUint64(&scanner.lastScanedBlockNumber)
+}
+
+//停止扫描,Start在本轮扫描完成后返回
+func (scanner *TxlogScanner) Stop() {
+	scanner.stopOnce.Do(func() { close(scanner.stop) })
+}
+
+//重置停止状态,Stop后需要再次Start时调用,只能在Start返回后调用
+func (scanner *TxlogScanner) Reset() {
+	scanner.stop = make(chan struct{})
+	scanner.stopOnce = sync.Once{}
+}
+
+func (scanner *TxlogScanner) isStopped() bool {
+	select {
+	case <-scanner.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//睡眠指定时间,调用Stop时提前返回
+func (scanner *TxlogScanner) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-scanner.stop:
+	case <-timer.C:
+	}
+}
+
+//回溯扫描新增的log参数,扫描到endBlock为止
+func backfillTxLogs(client *ethclient.Client, request *BackfillRequest, endBlock uint64, txlogWatcher TxlogWatcher) {
+	if request.FromBlock > endBlock {
+		return
+	}
+	LogToConsole(fmt.Sprintf("backfilling %s %s tx logs blocks %d - %d...", request.Address, request.Topic0, request.FromBlock, endBlock))
+
+	address := common.HexToAddress(request.Address)
+	topic0 := common.HexToHash(request.Topic0)
+	filter := ethereum.FilterQuery{
+		Addresses: []common.Address{address},
+		Topics:    [][]common.Hash{{topic0}},
+	}
+	for from := request.FromBlock; from <= endBlock; {
+		to := from + backfillPerScanBlockCount - 1
+		if to > endBlock {
+			to = endBlock
+		}
+		filter.FromBlock = new(big.Int).SetUint64(from)
+		filter.ToBlock = new(big.Int).SetUint64(to)
+
+		logs, err := client.FilterLogs(context.Background(), filter)
+		for err != nil {
+			LogToConsole(fmt.Sprintf("get logs error: %s,sleep 1s...", err.Error()))
+			time.Sleep(time.Second)
+			logs, err = client.FilterLogs(context.Background(), filter)
+		}
+
+		infos, err := enrichLogs(txlogWatcher, logs)
+		for i := range logs {
+			if err != nil {
+				break
+			}
+			if logs[i].Address == address && len(logs[i].Topics) > 0 && logs[i].Topics[0] == topic0 {
+				err = callback(txlogWatcher, &logs[i], infos[i])
+			}
+		}
+		if err != nil {
+			LogToConsole(fmt.Sprintf("tx log callback error: %s,rescan block %d - %d after 1s...", err.Error(), from, to))
+			time.Sleep(time.Second)
+			continue
+		}
+		from = to + 1
+	}
+	LogToConsole(fmt.Sprintf("backfill %s %s tx logs finished.", request.Address, request.Topic0))
+}
+
+//标注logs中关注的log的合约地址,返回与logs对应的标注信息,未标注的log为nil
+func enrichLogs(txlogWatcher TxlogWatcher, logs []types.Log) ([]*txscanner.AddressInfo, error) {
+	infos := make([]*txscanner.AddressInfo, len(logs))
+	enrichWatcher, ok := txlogWatcher.(EnrichTxlogWatcher)
+	if !ok || enrichWatcher.GetEnricher() == nil {
+		return infos, nil
+	}
+	enricher := enrichWatcher.GetEnricher()
+	for i := range logs {
+		if len(logs[i].Topics) == 0 || !txlogWatcher.IsInterestedLog(logs[i].Address.Hex(), logs[i].Topics[0].Hex()) {
+			continue
+		}
+		info, err := enricher.EnrichLog(&logs[i])
+		if err != nil {
+			return nil, err
+		}
+		infos[i] = info
+	}
+
+	return infos, nil
+}
+
+//调用watcher的回调,log已标注时使用标注后的回调,watcher支持时使用可返回错误的回调
+func callback(txlogWatcher TxlogWatcher, txlog *types.Log, info *txscanner.AddressInfo) error {
+	if enrichWatcher, ok := txlogWatcher.(EnrichTxlogWatcher); ok && info != nil {
+		return enrichWatcher.EnrichedCallback(txlog, info)
+	}
+	if checkedWatcher, ok := txlogWatcher.(CheckedTxlogWatcher); ok {
+		return checkedWatcher.CheckedCallback(txlog)
+	}
+	txlogWatcher.Callback(txlog)
+
+	return nil
+}
+
+//更新watcher已扫描的最大区块号,watcher支持时使用可返回错误的方法
+func updateMaxScanedBlock(txlogWatcher TxlogWatcher, blockNumber uint64) error {
+	if checkpointWatcher, ok := txlogWatcher.(CheckpointTxlogWatcher); ok {
+		return checkpointWatcher.CheckedUpdateMaxScanedBlock(blockNumber)
+	}
+	txlogWatcher.UpdateMaxScanedBlock(blockNumber)
+
+	return nil
+}
+
 func LogToConsole(msg string) {
 	fmt.Println(time.Now().Add(8*time.Hour).Format("2006-01-02 15:04:05") + "  " + msg)
 }
@@ -163,6 +474,39 @@ func RebuildAvaiIndexes(clientsCount int, clientSleepTimes *map[int]int64) []int
 	return avaiIndexes
 }
 
+//获取区块扫描完成信息,出错时最多重试maxBlockInfoRetries次
+func getBlockInfo(client *ethclient.Client, blockNumber uint64) (*BlockInfo, error) {
+	header, err := client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(blockNumber))
+	for i := 0; err != nil && i < maxBlockInfoRetries; i++ {
+		LogToConsole(fmt.Sprintf("get block %d header error: %s,sleep 1s...", blockNumber, err.Error()))
+		time.Sleep(time.Second)
+		header, err = client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(blockNumber))
+	}
+	if err != nil {
+		return nil, err
+	}
+	txCount, err := client.TransactionCount(context.Background(), header.Hash())
+	for i := 0; err != nil && i < maxBlockInfoRetries; i++ {
+		LogToConsole(fmt.Sprintf("get block %d tx count error: %s,sleep 1s...", blockNumber, err.Error()))
+		time.Sleep(time.Second)
+		txCount, err = client.TransactionCount(context.Background(), header.Hash())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockInfo{
+		Header:        header,
+		BlockNumber:   blockNumber,
+		BlockHash:     strings.ToLower(header.Hash().Hex()),
+		BlockUnixSecs: header.Time,
+		BaseFee:       header.BaseFee,
+		GasUsed:       header.GasUsed,
+		GasLimit:      header.GasLimit,
+		TxCount:       int(txCount),
+	}, nil
+}
+
 func getBlockNumber(client *ethclient.Client) uint64 {
 	blockNumber, err := client.BlockNumber(context.Background())
 	for err != nil {
@@ -0,0 +1,324 @@
+package txlogscanner_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/addrlabel"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txlogscanner"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+const scanTimeout = 20 * time.Second
+
+var (
+	tokenA        = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	tokenB        = common.HexToAddress("0x00000000000000000000000000000000000000b1")
+	transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	approvalTopic = common.HexToHash("0x8c5be1e5ebec7d5bd14f71427e1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+)
+
+//记录回调的log,可并发调用
+type logRecorder struct {
+	lock sync.Mutex
+	logs []*types.Log
+}
+
+func (recorder *logRecorder) callback(log *types.Log) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.logs = append(recorder.logs, log)
+}
+
+func (recorder *logRecorder) txHashes() []common.Hash {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	hashes := make([]common.Hash, len(recorder.logs))
+	for i, log := range recorder.logs {
+		hashes[i] = log.TxHash
+	}
+
+	return hashes
+}
+
+func emit(chain *fakechain.Chain, from int, token common.Address, topic common.Hash) *types.Transaction {
+	return chain.AddTx(from, &token, nil, nil, fakechain.NewLog(token, []common.Hash{topic}, []byte{0x01}))
+}
+
+func newWatcher(t *testing.T, chain *fakechain.Chain, recorder *logRecorder) (*txlogscanner.SimpleTxLogWatcher, *fakechain.Server) {
+	server := fakechain.NewServer(chain)
+	t.Cleanup(server.Close)
+	watcher := txlogscanner.NewSimpleTxLogWatcher([]string{server.URL()}, 1, 10*time.Millisecond, recorder.callback)
+
+	return watcher, server
+}
+
+func assertTxHashes(t *testing.T, got []common.Hash, want ...*types.Transaction) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d logs, want %d", len(got), len(want))
+	}
+	for i, tx := range want {
+		if got[i] != tx.Hash() {
+			t.Fatalf("log %d: got tx %s, want %s", i, got[i].Hex(), tx.Hash().Hex())
+		}
+	}
+}
+
+func TestScanTxLogsMatchesInterestedLogs(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	first := emit(chain, 1, tokenA, transferTopic)
+	emit(chain, 1, tokenA, approvalTopic)
+	emit(chain, 2, tokenB, approvalTopic)
+	chain.Mine()
+	chain.MineN(2)
+	second := emit(chain, 3, tokenA, transferTopic)
+	third := emit(chain, 3, tokenB, transferTopic)
+	chain.Mine()
+
+	recorder := &logRecorder{}
+	watcher, _ := newWatcher(t, chain, recorder)
+	watcher.SetPerScanBlockCount(2)
+	watcher.AddInterestedParams(tokenA.Hex(), transferTopic.Hex())
+	watcher.AddInterestedParams(tokenB.Hex(), transferTopic.Hex())
+
+	err := fakechain.ScanTo(txlogscanner.NewTxlogScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTxHashes(t, recorder.txHashes(), first, second, third)
+	log := recorder.logs[0]
+	if log.BlockNumber != 1 || log.BlockHash != chain.BlockByNumber(1).Hash() || log.Address != tokenA {
+		t.Fatalf("unexpected log %+v", log)
+	}
+}
+
+func TestScanTxLogsOnBlock(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	emit(chain, 1, tokenA, transferTopic)
+	emit(chain, 2, tokenA, transferTopic)
+	chain.Mine()
+	chain.Transfer(1, tokenB, nil)
+	chain.Mine()
+	chain.Mine()
+
+	recorder := &logRecorder{}
+	watcher, _ := newWatcher(t, chain, recorder)
+	watcher.AddInterestedParams(tokenA.Hex(), transferTopic.Hex())
+	var blocks []*txlogscanner.BlockInfo
+	watcher.SetOnBlock(func(block *txlogscanner.BlockInfo) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+
+	err := fakechain.ScanTo(txlogscanner.NewTxlogScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//从创世区块开始回调,每个区块回调一次
+	byNumber := make(map[uint64]*txlogscanner.BlockInfo)
+	for i, block := range blocks {
+		if i > 0 && block.BlockNumber != blocks[i-1].BlockNumber+1 {
+			t.Fatalf("block %d after block %d", block.BlockNumber, blocks[i-1].BlockNumber)
+		}
+		byNumber[block.BlockNumber] = block
+	}
+	for number, want := range map[uint64][2]int{1: {2, 2}, 2: {1, 0}, 3: {0, 0}} {
+		block := byNumber[number]
+		if block == nil || block.TxCount != want[0] || block.MatchedLogCount != want[1] {
+			t.Fatalf("unexpected block %d: %+v", number, block)
+		}
+		if block.BlockHash != strings.ToLower(chain.BlockByNumber(number).Hash().Hex()) {
+			t.Fatalf("unexpected block %d hash", number)
+		}
+	}
+}
+
+func TestScanTxLogsCallbackErrorRescans(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	tx := emit(chain, 1, tokenA, transferTopic)
+	chain.Mine()
+
+	recorder := &logRecorder{}
+	watcher, _ := newWatcher(t, chain, recorder)
+	watcher.AddInterestedParams(tokenA.Hex(), transferTopic.Hex())
+	calls := 0
+	watcher.SetCheckedCallback(func(log *types.Log) error {
+		calls++
+		if calls == 1 {
+			return errors.New("callback failed")
+		}
+		recorder.callback(log)
+		return nil
+	})
+	var checkpoints []uint64
+	watcher.SetCheckedUpdateMaxScanedBlock(func(blockNumber uint64) error {
+		checkpoints = append(checkpoints, blockNumber)
+		return nil
+	})
+
+	err := fakechain.ScanTo(txlogscanner.NewTxlogScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTxHashes(t, recorder.txHashes(), tx)
+	if len(checkpoints) == 0 || checkpoints[len(checkpoints)-1] != chain.Head() {
+		t.Fatalf("unexpected checkpoints %v", checkpoints)
+	}
+}
+
+func TestScanTxLogsOnBlockErrorRescansFromFailedBlock(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.Mine()
+	first := emit(chain, 1, tokenA, transferTopic)
+	chain.Mine()
+	second := emit(chain, 1, tokenA, transferTopic)
+	chain.Mine()
+	third := emit(chain, 1, tokenA, transferTopic)
+	chain.Mine()
+
+	recorder := &logRecorder{}
+	server := fakechain.NewServer(chain)
+	defer server.Close()
+	//从区块2开始扫描
+	watcher := txlogscanner.NewSimpleTxLogWatcher([]string{server.URL()}, 3, 10*time.Millisecond, recorder.callback)
+	watcher.SetPerScanBlockCount(5)
+	watcher.AddInterestedParams(tokenA.Hex(), transferTopic.Hex())
+	failed := false
+	var blocks []uint64
+	watcher.SetOnBlock(func(block *txlogscanner.BlockInfo) error {
+		if block.BlockNumber == 3 && !failed {
+			failed = true
+			return errors.New("save block failed")
+		}
+		blocks = append(blocks, block.BlockNumber)
+		return nil
+	})
+	//区块2的header获取失败超过重试次数
+	server.FailNext("eth_getBlockByNumber", 6, "internal error")
+
+	err := fakechain.ScanTo(txlogscanner.NewTxlogScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//只重新回调失败区块的log,已完成的区块不重复回调
+	assertTxHashes(t, recorder.txHashes(), first, first, second, second, third)
+	if len(blocks) != 3 || blocks[0] != 2 || blocks[1] != 3 || blocks[2] != 4 {
+		t.Fatalf("unexpected blocks %v", blocks)
+	}
+}
+
+//首次查询failAddr时返回错误的标签提供者
+type flakyLabels struct {
+	*addrlabel.MemoryProvider
+	failAddr common.Address
+	failed   bool
+}
+
+func (labels *flakyLabels) Label(addr common.Address) (*addrlabel.Label, error) {
+	if addr == labels.failAddr && !labels.failed {
+		labels.failed = true
+		return nil, errors.New("label store unavailable")
+	}
+
+	return labels.MemoryProvider.Label(addr)
+}
+
+func TestScanTxLogsEnrichesLogs(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	first := emit(chain, 1, tokenA, transferTopic)
+	second := emit(chain, 2, tokenB, transferTopic)
+	chain.Mine()
+
+	recorder := &logRecorder{}
+	watcher, _ := newWatcher(t, chain, recorder)
+	watcher.AddInterestedParams(tokenA.Hex(), transferTopic.Hex())
+	watcher.AddInterestedParams(tokenB.Hex(), transferTopic.Hex())
+	labels := &flakyLabels{MemoryProvider: addrlabel.NewMemoryProvider(), failAddr: tokenB}
+	labels.Set(tokenA, &addrlabel.Label{Name: "USDT contract", Category: "token"})
+	watcher.SetEnricher(txscanner.NewEnricher(labels, 16))
+	var infos []*txscanner.AddressInfo
+	watcher.SetEnrichedCallback(func(log *types.Log, info *txscanner.AddressInfo) error {
+		recorder.callback(log)
+		infos = append(infos, info)
+		return nil
+	})
+
+	err := fakechain.ScanTo(txlogscanner.NewTxlogScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//第二个log标注失败时第一个log尚未回调
+	assertTxHashes(t, recorder.txHashes(), first, second)
+	if infos[0].Label.Name != "USDT contract" || infos[0].Kind != txscanner.AddressContract || infos[1].Label != nil || infos[1].Kind != txscanner.AddressContract {
+		t.Fatalf("unexpected infos %+v %+v", infos[0], infos[1])
+	}
+}
+
+func TestScanTxLogsRetriesRPCErrors(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	tx := emit(chain, 1, tokenA, transferTopic)
+	chain.Mine()
+
+	recorder := &logRecorder{}
+	watcher, server := newWatcher(t, chain, recorder)
+	watcher.AddInterestedParams(tokenA.Hex(), transferTopic.Hex())
+	server.FailNext("eth_getLogs", 1, "query timeout")
+	server.FailNext("eth_blockNumber", 1, "internal error")
+
+	err := fakechain.ScanTo(txlogscanner.NewTxlogScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTxHashes(t, recorder.txHashes(), tx)
+	if server.Calls("eth_getLogs") < 2 || server.Calls("eth_blockNumber") < 2 {
+		t.Fatal("failed calls were not retried")
+	}
+}
+
+func TestScanTxLogsBackfillsNewParams(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	early := emit(chain, 1, tokenB, approvalTopic)
+	chain.MineN(3)
+
+	recorder := &logRecorder{}
+	watcher, _ := newWatcher(t, chain, recorder)
+	watcher.AddInterestedParams(tokenA.Hex(), transferTopic.Hex())
+	scanner := txlogscanner.NewTxlogScanner(watcher)
+	done := make(chan error, 1)
+	go func() {
+		done <- fakechain.ScanTo(scanner, 4, scanTimeout)
+	}()
+	deadline := time.Now().Add(scanTimeout)
+	for scanner.GetLastScanedBlock() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for block 3")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	watcher.AddInterestedParamsWithBackfill(tokenB.Hex(), approvalTopic.Hex(), 1)
+	late := emit(chain, 2, tokenB, approvalTopic)
+	chain.Mine()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//回溯范围可能与加入参数后的实时扫描重叠,log至少回调一次
+	found := make(map[common.Hash]bool)
+	for _, hash := range recorder.txHashes() {
+		found[hash] = true
+	}
+	if !found[early.Hash()] || !found[late.Hash()] {
+		t.Fatalf("unexpected logs %v", recorder.txHashes())
+	}
+}
@@ -0,0 +1,350 @@
+package txscanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//每扫描该数量的区块记录一次分片进度
+const backfillProgressBlockCount = 100
+
+//分片回溯进度存储,中断后再次运行时从记录的进度继续
+type BackfillProgressStore interface {
+	//获取分片已扫描的最大区块号,未记录时返回0
+	GetShardProgress(fromBlock uint64, toBlock uint64) (uint64, error)
+
+	//记录分片已扫描的最大区块号
+	UpdateShardProgress(fromBlock uint64, toBlock uint64, scanedBlock uint64) error
+}
+
+//内存分片进度,进程退出后丢失
+type MemoryBackfillProgress struct {
+	lock     sync.Mutex
+	progress map[string]uint64
+}
+
+func NewMemoryBackfillProgress() *MemoryBackfillProgress {
+	return &MemoryBackfillProgress{progress: make(map[string]uint64)}
+}
+
+func (store *MemoryBackfillProgress) GetShardProgress(fromBlock uint64, toBlock uint64) (uint64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.progress[shardKey(fromBlock, toBlock)], nil
+}
+
+func (store *MemoryBackfillProgress) UpdateShardProgress(fromBlock uint64, toBlock uint64, scanedBlock uint64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.progress[shardKey(fromBlock, toBlock)] = scanedBlock
+
+	return nil
+}
+
+//json文件分片进度,每次更新时重写文件
+type FileBackfillProgress struct {
+	path     string
+	lock     sync.Mutex
+	progress map[string]uint64
+}
+
+//打开json进度文件,文件不存在时新建
+func NewFileBackfillProgress(path string) (*FileBackfillProgress, error) {
+	store := &FileBackfillProgress{
+		path:     path,
+		progress: make(map[string]uint64),
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, &store.progress)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (store *FileBackfillProgress) GetShardProgress(fromBlock uint64, toBlock uint64) (uint64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.progress[shardKey(fromBlock, toBlock)], nil
+}
+
+func (store *FileBackfillProgress) UpdateShardProgress(fromBlock uint64, toBlock uint64, scanedBlock uint64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.progress[shardKey(fromBlock, toBlock)] = scanedBlock
+	bytes, err := json.MarshalIndent(store.progress, "", "  ")
+	if err != nil {
+		return err
+	}
+	//先写临时文件再重命名,避免中断时进度文件损坏
+	tmpPath := store.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, bytes, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, store.path)
+}
+
+func shardKey(fromBlock uint64, toBlock uint64) string {
+	return strconv.FormatUint(fromBlock, 10) + "-" + strconv.FormatUint(toBlock, 10)
+}
+
+//区块分片
+type BackfillShard struct {
+	FromBlock uint64
+	ToBlock   uint64
+}
+
+//将[fromBlock, toBlock]按shardSize切分为分片
+func SplitShards(fromBlock uint64, toBlock uint64, shardSize uint64) []*BackfillShard {
+	if shardSize == 0 {
+		shardSize = 1
+	}
+	var shards []*BackfillShard
+	for from := fromBlock; from <= toBlock; from += shardSize {
+		to := from + shardSize - 1
+		if to > toBlock || to < from {
+			to = toBlock
+		}
+		shards = append(shards, &BackfillShard{FromBlock: from, ToBlock: to})
+		if to == toBlock {
+			break
+		}
+	}
+
+	return shards
+}
+
+//分片并行回溯扫描器,使用watcher的关注规则和回调处理历史区块
+//分片并行处理,watcher的Callback和OnBlock需支持并发调用,且不保证区块顺序
+type Backfiller struct {
+	txWatcher   TxWatcher
+	shardSize   uint64
+	concurrency int
+	progress    BackfillProgressStore
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+//构造分片回溯扫描器,默认并发数为节点数量,进度保存在内存
+func NewBackfiller(txWatcher TxWatcher, shardSize uint64) *Backfiller {
+	return &Backfiller{
+		txWatcher: txWatcher,
+		shardSize: shardSize,
+		progress:  NewMemoryBackfillProgress(),
+		stop:      make(chan struct{}),
+	}
+}
+
+//设置并行处理的分片数量
+func (backfiller *Backfiller) SetConcurrency(concurrency int) {
+	backfiller.concurrency = concurrency
+}
+
+//设置分片进度存储,用于中断后继续
+func (backfiller *Backfiller) SetProgressStore(progress BackfillProgressStore) {
+	backfiller.progress = progress
+}
+
+//停止回溯,正在处理的分片记录进度后返回
+func (backfiller *Backfiller) Stop() {
+	backfiller.stopOnce.Do(func() { close(backfiller.stop) })
+}
+
+func (backfiller *Backfiller) isStopped() bool {
+	select {
+	case <-backfiller.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//回溯扫描[fromBlock, toBlock](toBlock为0时扫描到当前最新区块),阻塞直到所有分片完成,返回第一个失败分片的错误
+func (backfiller *Backfiller) Run(fromBlock uint64, toBlock uint64) error {
+	if fromBlock == 0 {
+		fromBlock = 1
+	}
+	if toBlock == 0 {
+		headBlock, err := backfiller.headBlock()
+		if err != nil {
+			return err
+		}
+		toBlock = headBlock
+	}
+	if fromBlock > toBlock {
+		return nil
+	}
+
+	base := NewTxScanner(backfiller.txWatcher)
+	err := base.prepare()
+	if err != nil {
+		return err
+	}
+	concurrency := backfiller.concurrency
+	if concurrency <= 0 {
+		clients, err := backfiller.txWatcher.GetEthClients()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(clients); i++ {
+			clients[i].Close()
+		}
+		concurrency = len(clients)
+	}
+
+	shards := SplitShards(fromBlock, toBlock, backfiller.shardSize)
+	LogToConsole(fmt.Sprintf("backfilling blocks %d - %d in %d shards,concurrency %d...", fromBlock, toBlock, len(shards), concurrency))
+
+	shardCh := make(chan *BackfillShard)
+	errCh := make(chan error, len(shards))
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range shardCh {
+				err := backfiller.runShard(base, shard)
+				if err != nil {
+					LogToConsole(fmt.Sprintf("backfill shard %d - %d error: %s", shard.FromBlock, shard.ToBlock, err.Error()))
+					errCh <- err
+				}
+			}
+		}()
+	}
+	for _, shard := range shards {
+		if backfiller.isStopped() {
+			break
+		}
+		shardCh <- shard
+	}
+	close(shardCh)
+	wg.Wait()
+	close(errCh)
+
+	err = <-errCh
+	if err != nil {
+		return err
+	}
+	if backfiller.isStopped() {
+		return errors.New("backfill stopped")
+	}
+	LogToConsole(fmt.Sprintf("backfill blocks %d - %d finished.", fromBlock, toBlock))
+
+	return nil
+}
+
+//扫描单个分片,从记录的进度继续,连续出错10次时放弃
+func (backfiller *Backfiller) runShard(base *TxScanner, shard *BackfillShard) error {
+	scanedBlock, err := backfiller.progress.GetShardProgress(shard.FromBlock, shard.ToBlock)
+	if err != nil {
+		return err
+	}
+	next := shard.FromBlock
+	if scanedBlock >= next {
+		next = scanedBlock + 1
+	}
+	if next > shard.ToBlock {
+		return nil
+	}
+
+	scanner := base.child()
+	scanner.stop = backfiller.stop
+
+	var onBlock func(*BlockInfo) error
+	if blockWatcher, ok := backfiller.txWatcher.(BlockTxWatcher); ok {
+		onBlock = blockWatcher.GetOnBlock()
+	}
+	matcher := newTxMatcher(backfiller.txWatcher)
+	//余额变化、nonce和gas统计只跟踪实时区块,新增地址的回溯请求由实时扫描取出
+	matcher.balanceWatcher = nil
+	matcher.nonceTracker = nil
+	matcher.gasAnalytics = nil
+	matcher.backfillWatcher = nil
+	errCount := 0
+	for next <= shard.ToBlock && !backfiller.isStopped() {
+		endBlock := next + backfillProgressBlockCount - 1
+		if endBlock > shard.ToBlock {
+			endBlock = shard.ToBlock
+		}
+		scanedBlock, err := scanner.scanTx(next, endBlock, matcher, onBlock)
+		if scanedBlock >= next {
+			next = scanedBlock + 1
+			updateErr := backfiller.progress.UpdateShardProgress(shard.FromBlock, shard.ToBlock, scanedBlock)
+			if updateErr != nil {
+				return updateErr
+			}
+			if err == nil {
+				errCount = 0
+				continue
+			}
+		}
+
+		errCount++
+		if errCount == 10 {
+			if err == nil {
+				err = errors.New("no available client")
+			}
+			return fmt.Errorf("continuous error %d times at block %d: %w", errCount, next, err)
+		}
+		scanner.sleep(time.Second)
+	}
+
+	return nil
+}
+
+//第一个节点的最新区块号
+func (backfiller *Backfiller) headBlock() (uint64, error) {
+	clients, err := backfiller.txWatcher.GetEthClients()
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(clients); i++ {
+		defer clients[i].Close()
+	}
+
+	return clients[0].BlockNumber(context.Background())
+}
+
+//回溯扫描fromBlock至当前最新区块,完成后从最新区块继续实时扫描,阻塞直到scanner停止
+func (backfiller *Backfiller) RunThenScan(scanner *TxScanner, fromBlock uint64) error {
+	headBlock, err := backfiller.headBlock()
+	if err != nil {
+		return err
+	}
+
+	err = backfiller.Run(fromBlock, headBlock)
+	if err != nil {
+		return err
+	}
+	if checkpointWatcher, ok := backfiller.txWatcher.(CheckpointTxWatcher); ok {
+		err = checkpointWatcher.UpdateMaxScanedBlock(headBlock)
+		if err != nil {
+			return err
+		}
+	}
+	if scanner.GetLastScanedBlock() < headBlock {
+		scanner.setLastScanedBlock(headBlock)
+	}
+
+	return scanner.Start()
+}
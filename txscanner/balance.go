@@ -0,0 +1,171 @@
+package txscanner
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+//跟踪原生币余额的watcher,每个区块获取跟踪地址的余额并回调变化;
+//开启后不再使用bloom预检查跳过区块
+type BalanceTxWatcher interface {
+	TxWatcher
+
+	//获取跟踪余额的地址
+	GetBalanceAddresses() []common.Address
+
+	//余额变化回调,区块内所有tx回调后、区块扫描完成回调前调用,返回错误时重新扫描该区块
+	OnBalanceChange(change *BalanceChange) error
+}
+
+//区块内地址的余额变化
+type BalanceChange struct {
+	Address     common.Address
+	BlockNumber uint64
+	BlockHash   string
+	//父区块时的余额
+	PrevBalance *big.Int
+	Balance     *big.Int
+	//Balance - PrevBalance
+	Delta *big.Int
+	//区块内与该地址相关交易(from或to为该地址)的转账金额和手续费之和
+	TxDelta *big.Int
+	//相关交易的hash
+	TxHashes []string
+	//区块内该地址的信标链提款金额之和
+	Withdrawals *big.Int
+	//Delta与TxDelta+Withdrawals不一致,说明有交易和提款之外的余额变化(如出块奖励、内部转账)
+	Mismatch bool
+}
+
+//区块余额缓存,用于计算下一个区块的余额变化
+type balanceCache struct {
+	blockHash common.Hash
+	balances  map[common.Address]*big.Int
+}
+
+//获取区块内跟踪地址的余额变化,只返回余额或相关交易有变化的地址
+//receipts为已获取的区块receipt,可为nil
+func (scanner *TxScanner) getBalanceChanges(client *ethclient.Client, block *Block, addresses []common.Address, receipts map[common.Hash]*types.Receipt) ([]*BalanceChange, error) {
+	//父区块的余额优先使用上个区块的缓存
+	var prevBalances map[common.Address]*big.Int
+	if scanner.balances != nil && scanner.balances.blockHash == block.Header.ParentHash {
+		prevBalances = scanner.balances.balances
+	}
+	balances := make(map[common.Address]*big.Int, len(addresses))
+	var elems []rpc.BatchElem
+	for _, addr := range addresses {
+		balance := new(hexutil.Big)
+		elems = append(elems, rpc.BatchElem{Method: "eth_getBalance", Args: []interface{}{addr, block.Hash}, Result: balance})
+		balances[addr] = (*big.Int)(balance)
+		if prevBalances[addr] == nil {
+			prevBalance := new(hexutil.Big)
+			elems = append(elems, rpc.BatchElem{Method: "eth_getBalance", Args: []interface{}{addr, block.Header.ParentHash}, Result: prevBalance})
+			if prevBalances == nil {
+				prevBalances = make(map[common.Address]*big.Int)
+			}
+			prevBalances[addr] = (*big.Int)(prevBalance)
+		}
+	}
+	err := client.Client().BatchCallContext(context.Background(), elems)
+	if err != nil {
+		return nil, err
+	}
+	for _, elem := range elems {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+	}
+
+	changes := make(map[common.Address]*BalanceChange, len(addresses))
+	for _, addr := range addresses {
+		changes[addr] = &BalanceChange{
+			Address:     addr,
+			BlockNumber: block.Header.Number.Uint64(),
+			BlockHash:   hashString(block.Hash),
+			PrevBalance: prevBalances[addr],
+			Balance:     balances[addr],
+			Delta:       new(big.Int).Sub(balances[addr], prevBalances[addr]),
+			TxDelta:     new(big.Int),
+			Withdrawals: new(big.Int),
+		}
+	}
+	for _, withdrawal := range block.Withdrawals {
+		if change := changes[withdrawal.Address]; change != nil {
+			change.Withdrawals.Add(change.Withdrawals, withdrawalAmount(withdrawal))
+		}
+	}
+	for _, btx := range block.Txs {
+		fromChange := changes[btx.From]
+		var toChange *BalanceChange
+		if btx.To != nil {
+			toChange = changes[*btx.To]
+		}
+		if fromChange == nil && toChange == nil {
+			continue
+		}
+
+		txInfo := btx.TxInfo(block)
+		if receipt := receipts[btx.Hash]; receipt != nil {
+			txInfo.SetReceipt(receipt)
+		} else {
+			err = LoadReceipt(client, txInfo, scanner.profile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		value := new(big.Int)
+		if txInfo.Status == types.ReceiptStatusSuccessful && txInfo.Value != nil {
+			value = txInfo.Value
+		}
+		if fromChange != nil {
+			fromChange.TxDelta.Sub(fromChange.TxDelta, value)
+			fromChange.TxDelta.Sub(fromChange.TxDelta, txFee(txInfo))
+			fromChange.TxHashes = append(fromChange.TxHashes, txInfo.TxHash)
+		}
+		if toChange != nil {
+			toChange.TxDelta.Add(toChange.TxDelta, value)
+			if toChange != fromChange {
+				toChange.TxHashes = append(toChange.TxHashes, txInfo.TxHash)
+			}
+		}
+	}
+
+	scanner.balances = &balanceCache{blockHash: block.Hash, balances: balances}
+	var result []*BalanceChange
+	for _, addr := range addresses {
+		change := changes[addr]
+		if change.Delta.Sign() == 0 && len(change.TxHashes) == 0 && change.Withdrawals.Sign() == 0 {
+			continue
+		}
+		change.Mismatch = change.Delta.Cmp(new(big.Int).Add(change.TxDelta, change.Withdrawals)) != 0
+		result = append(result, change)
+	}
+
+	return result, nil
+}
+
+//交易手续费:gasUsed * 实际gas price,L2链加上L1费用,blob交易加上blob gas费用
+func txFee(txInfo *TxInfo) *big.Int {
+	gasPrice := txInfo.GasPrice
+	if receipt := txInfo.Receipt(); receipt != nil && receipt.EffectiveGasPrice != nil {
+		gasPrice = receipt.EffectiveGasPrice
+	}
+	fee := new(big.Int)
+	if gasPrice != nil {
+		fee.Mul(new(big.Int).SetUint64(txInfo.GasUsed), gasPrice)
+	}
+	if txInfo.L1Fee != nil {
+		fee.Add(fee, txInfo.L1Fee)
+	}
+	if txInfo.BlobGasPrice != nil {
+		fee.Add(fee, new(big.Int).Mul(new(big.Int).SetUint64(txInfo.BlobGasUsed), txInfo.BlobGasPrice))
+	}
+
+	return fee
+}
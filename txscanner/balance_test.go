@@ -0,0 +1,70 @@
+package txscanner_test
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+func TestScanTxBalanceChanges(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.Transfer(1, depositAddr, big.NewInt(100))
+	chain.Transfer(2, fakechain.Address(1), big.NewInt(50))
+	chain.Mine()
+	//交易之外的转入(如内部转账)
+	chain.Credit(depositAddr, big.NewInt(7))
+	chain.Mine()
+	failed := chain.AddFailedTx(1, &depositAddr, big.NewInt(1000), nil)
+	chain.Mine()
+
+	var lock sync.Mutex
+	var changes []*txscanner.BalanceChange
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddBalanceAddress(hexAddress(depositAddr))
+	watcher.AddBalanceAddress(hexAddress(fakechain.Address(1)))
+	watcher.SetOnBalanceChange(func(change *txscanner.BalanceChange) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		changes = append(changes, change)
+		return nil
+	})
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(fakechain.TransferGas), new(big.Int).Mul(fakechain.BaseFee, big.NewInt(2)))
+	want := []struct {
+		block    uint64
+		delta    *big.Int
+		mismatch bool
+		txs      int
+	}{
+		{1, big.NewInt(100), false, 1},
+		{1, new(big.Int).Sub(big.NewInt(-50), fee), false, 2},
+		{2, big.NewInt(7), true, 0},
+		//失败交易不转账,只扣除发送者的手续费
+		{3, big.NewInt(0), false, 1},
+		{3, new(big.Int).Neg(fee), false, 1},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(want))
+	}
+	for i, w := range want {
+		change := changes[i]
+		if change.BlockNumber != w.block || change.Delta.Cmp(w.delta) != 0 || change.Mismatch != w.mismatch || len(change.TxHashes) != w.txs {
+			t.Fatalf("change %d: %+v", i, change)
+		}
+		if new(big.Int).Sub(change.Balance, change.PrevBalance).Cmp(change.Delta) != 0 {
+			t.Fatalf("change %d: inconsistent balances %+v", i, change)
+		}
+	}
+	if changes[4].TxHashes[0] != hexHash(failed) {
+		t.Fatalf("failed tx %s", changes[4].TxHashes[0])
+	}
+}
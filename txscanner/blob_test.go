@@ -0,0 +1,53 @@
+package txscanner_test
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+func TestScanTxBlobTx(t *testing.T) {
+	blobHashes := []common.Hash{
+		common.HexToHash("0x01" + strings.Repeat("11", 31)),
+		common.HexToHash("0x01" + strings.Repeat("22", 31)),
+	}
+	//链id 10使用原始rpc数据解析区块,两种解析路径结果应一致
+	for _, chainID := range []int64{1, 10} {
+		chain := fakechain.NewChain(chainID)
+		chain.Transfer(2, depositAddr, big.NewInt(1))
+		blobTx := chain.AddBlobTx(1, depositAddr, blobHashes)
+		chain.Mine()
+
+		recorder := &txRecorder{}
+		watcher, _ := newWatcher(t, chain, 1, recorder)
+		watcher.AddInterestedFrom(hexAddress(fakechain.Address(1)))
+		var changes []*txscanner.BalanceChange
+		watcher.AddBalanceAddress(hexAddress(fakechain.Address(1)))
+		watcher.SetOnBalanceChange(func(change *txscanner.BalanceChange) error {
+			changes = append(changes, change)
+			return nil
+		})
+		err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertHashes(t, recorder.hashes(), blobTx)
+		tx := recorder.txs[0]
+		if tx.From != hexAddress(fakechain.Address(1)) || len(tx.BlobVersionedHashes) != 2 || tx.BlobVersionedHashes[1] != strings.ToLower(blobHashes[1].Hex()) {
+			t.Fatalf("chain %d: unexpected tx %+v", chainID, tx)
+		}
+		if tx.BlobGasFeeCap.Cmp(blobTx.BlobGasFeeCap()) != 0 || tx.BlobGasUsed != 2*params.BlobTxBlobGasPerBlob || tx.BlobGasPrice.Cmp(fakechain.BlobBaseFee) != 0 {
+			t.Fatalf("chain %d: blob gas %v %d %v", chainID, tx.BlobGasFeeCap, tx.BlobGasUsed, tx.BlobGasPrice)
+		}
+		//blob gas费用计入交易手续费,余额变化一致
+		if len(changes) != 1 || changes[0].Mismatch {
+			t.Fatalf("chain %d: balance changes %+v", chainID, changes)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package txscanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+//区块及其中的交易
+type Block struct {
+	Header *types.Header
+	//区块hash,原始rpc解析时为节点返回的hash
+	Hash common.Hash
+	Txs  []*BlockTx
+	//信标链提款,Shanghai之前的区块为nil
+	Withdrawals []*types.Withdrawal
+}
+
+//区块内的交易
+type BlockTx struct {
+	Hash common.Hash
+	Type uint8
+	From common.Address
+	//合约创建交易为nil
+	To *common.Address
+
+	tx    *types.Transaction
+	rpcTx *rpcTransaction
+}
+
+//原始rpc交易,包含L2特有交易类型的公共字段
+type rpcTransaction struct {
+	Hash     common.Hash     `json:"hash"`
+	Type     hexutil.Uint64  `json:"type"`
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Nonce    hexutil.Uint64  `json:"nonce"`
+	Value    *hexutil.Big    `json:"value"`
+	Input    hexutil.Bytes   `json:"input"`
+	V        *hexutil.Big    `json:"v"`
+	R        *hexutil.Big    `json:"r"`
+	S        *hexutil.Big    `json:"s"`
+	ChainID  *hexutil.Big    `json:"chainId"`
+	//EIP-4844 blob交易
+	MaxFeePerBlobGas    *hexutil.Big  `json:"maxFeePerBlobGas"`
+	BlobVersionedHashes []common.Hash `json:"blobVersionedHashes"`
+}
+
+//原始rpc receipt中L2的L1费用字段
+type rpcReceiptL1Fee struct {
+	//Optimism
+	L1GasUsed  *hexutil.Big `json:"l1GasUsed"`
+	L1GasPrice *hexutil.Big `json:"l1GasPrice"`
+	L1Fee      *hexutil.Big `json:"l1Fee"`
+	//Arbitrum
+	GasUsedForL1 *hexutil.Big `json:"gasUsedForL1"`
+}
+
+//获取区块及交易发送者,区块不存在时返回ethereum.NotFound
+func GetBlock(client *ethclient.Client, blockNumber uint64, profile *ChainProfile, signer types.Signer) (*Block, error) {
+	if profile != nil && profile.RawBlocks {
+		return getRawBlock(client, "eth_getBlockByNumber", hexutil.EncodeUint64(blockNumber))
+	}
+
+	block, err := client.BlockByNumber(context.Background(), new(big.Int).SetUint64(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlock(block, signer)
+}
+
+//按区块hash获取区块及交易发送者,区块不存在时返回ethereum.NotFound
+func GetBlockByHash(client *ethclient.Client, blockHash common.Hash, profile *ChainProfile, signer types.Signer) (*Block, error) {
+	if profile != nil && profile.RawBlocks {
+		return getRawBlock(client, "eth_getBlockByHash", blockHash)
+	}
+
+	block, err := client.BlockByHash(context.Background(), blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlock(block, signer)
+}
+
+func newBlock(block *types.Block, signer types.Signer) (*Block, error) {
+	txs := block.Transactions()
+	result := &Block{
+		Header:      block.Header(),
+		Hash:        block.Hash(),
+		Txs:         make([]*BlockTx, len(txs)),
+		Withdrawals: block.Withdrawals(),
+	}
+	for i, tx := range txs {
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			return nil, err
+		}
+		result.Txs[i] = &BlockTx{
+			Hash: tx.Hash(),
+			Type: tx.Type(),
+			From: from,
+			To:   tx.To(),
+			tx:   tx,
+		}
+	}
+
+	return result, nil
+}
+
+func getRawBlock(client *ethclient.Client, method string, blockID interface{}) (*Block, error) {
+	var raw json.RawMessage
+	err := client.Client().CallContext(context.Background(), &raw, method, blockID, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+
+	header := &types.Header{}
+	err = json.Unmarshal(raw, header)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Hash         common.Hash         `json:"hash"`
+		Transactions []*rpcTransaction   `json:"transactions"`
+		Withdrawals  []*types.Withdrawal `json:"withdrawals"`
+	}
+	err = json.Unmarshal(raw, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Block{
+		Header:      header,
+		Hash:        body.Hash,
+		Txs:         make([]*BlockTx, len(body.Transactions)),
+		Withdrawals: body.Withdrawals,
+	}
+	for i, rpcTx := range body.Transactions {
+		result.Txs[i] = &BlockTx{
+			Hash:  rpcTx.Hash,
+			Type:  uint8(rpcTx.Type),
+			From:  rpcTx.From,
+			To:    rpcTx.To,
+			rpcTx: rpcTx,
+		}
+	}
+
+	return result, nil
+}
+
+//构造tx信息,receipt相关字段需调用LoadReceipt或SetReceipt设置
+func (btx *BlockTx) TxInfo(block *Block) *TxInfo {
+	if btx.tx != nil {
+		return newTxInfo(block.Header, block.Hash, btx.tx, btx.From)
+	}
+
+	rpcTx := btx.rpcTx
+	txInfo := &TxInfo{
+		TxHash:        hashString(rpcTx.Hash),
+		Type:          uint8(rpcTx.Type),
+		From:          addressString(rpcTx.From),
+		Gas:           uint64(rpcTx.Gas),
+		GasPrice:      (*big.Int)(rpcTx.GasPrice),
+		Nonce:         uint64(rpcTx.Nonce),
+		Value:         (*big.Int)(rpcTx.Value),
+		V:             bigToBytes(rpcTx.V),
+		R:             bigToBytes(rpcTx.R),
+		S:             bigToBytes(rpcTx.S),
+		BlockHash:     hashString(block.Hash),
+		BlockNumber:   block.Header.Number,
+		BlockUnixSecs: block.Header.Time,
+		ChainID:       (*big.Int)(rpcTx.ChainID),
+	}
+	if rpcTx.To != nil {
+		txInfo.To = addressString(*rpcTx.To)
+	}
+	setInputData(txInfo, rpcTx.Input)
+	if len(rpcTx.BlobVersionedHashes) > 0 {
+		txInfo.BlobVersionedHashes = hashStrings(rpcTx.BlobVersionedHashes)
+		txInfo.BlobGasFeeCap = (*big.Int)(rpcTx.MaxFeePerBlobGas)
+	}
+
+	return txInfo
+}
+
+//计算tx的实际gas price:EIP-1559交易为min(fee cap, base fee + tip),原始rpc解析时使用节点返回的gasPrice
+func (btx *BlockTx) EffectiveGasPrice(baseFee *big.Int) *big.Int {
+	if btx.tx == nil {
+		if btx.rpcTx.GasPrice == nil {
+			return new(big.Int)
+		}
+		return new(big.Int).Set((*big.Int)(btx.rpcTx.GasPrice))
+	}
+	if baseFee == nil || btx.tx.Type() == types.LegacyTxType || btx.tx.Type() == types.AccessListTxType {
+		return new(big.Int).Set(btx.tx.GasPrice())
+	}
+
+	gasPrice := new(big.Int).Add(baseFee, btx.tx.GasTipCap())
+	if gasPrice.Cmp(btx.tx.GasFeeCap()) > 0 {
+		gasPrice.Set(btx.tx.GasFeeCap())
+	}
+
+	return gasPrice
+}
+
+//获取tx的receipt并设置receipt相关字段,原始rpc解析时同时设置L1费用字段
+func LoadReceipt(client *ethclient.Client, txInfo *TxInfo, profile *ChainProfile) error {
+	txHash := common.HexToHash(txInfo.TxHash)
+	if profile == nil || !profile.RawBlocks {
+		receipt, err := client.TransactionReceipt(context.Background(), txHash)
+		if err != nil {
+			return err
+		}
+		txInfo.SetReceipt(receipt)
+
+		return nil
+	}
+
+	var raw json.RawMessage
+	err := client.Client().CallContext(context.Background(), &raw, "eth_getTransactionReceipt", txHash)
+	if err != nil {
+		return err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return ethereum.NotFound
+	}
+	receipt := &types.Receipt{}
+	err = json.Unmarshal(raw, receipt)
+	if err != nil {
+		return err
+	}
+	l1Fee := &rpcReceiptL1Fee{}
+	err = json.Unmarshal(raw, l1Fee)
+	if err != nil {
+		return err
+	}
+
+	txInfo.SetReceipt(receipt)
+	txInfo.L1GasUsed = (*big.Int)(l1Fee.L1GasUsed)
+	if l1Fee.GasUsedForL1 != nil {
+		txInfo.L1GasUsed = (*big.Int)(l1Fee.GasUsedForL1)
+	}
+	txInfo.L1GasPrice = (*big.Int)(l1Fee.L1GasPrice)
+	txInfo.L1Fee = (*big.Int)(l1Fee.L1Fee)
+
+	return nil
+}
+
+//获取区块内所有交易的receipt(eth_getBlockReceipts),按交易hash索引
+func GetBlockReceipts(client *ethclient.Client, block *Block) (map[common.Hash]*types.Receipt, error) {
+	receipts, err := client.BlockReceipts(context.Background(), rpc.BlockNumberOrHashWithHash(block.Hash, false))
+	if err != nil {
+		return nil, err
+	}
+	//区块已被重组或节点返回不完整时数量不一致
+	if len(receipts) != len(block.Txs) {
+		return nil, errors.New("block receipts count mismatch")
+	}
+
+	result := make(map[common.Hash]*types.Receipt, len(receipts))
+	for _, receipt := range receipts {
+		if receipt != nil {
+			result[receipt.TxHash] = receipt
+		}
+	}
+
+	return result, nil
+}
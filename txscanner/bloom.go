@@ -0,0 +1,212 @@
+package txscanner
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+//批量预取header的最大区块数
+const maxHeaderBatch = 32
+
+//LogMatched规则使用bloom预检查的最大关注地址数,地址过多时几乎所有区块都会命中bloom
+const maxBloomLogAddresses = 256
+
+//区块bloom预检查条件,任一组中的所有项都在bloom中时区块可能包含关注的log
+type bloomQuery [][][]byte
+
+//区块bloom是否可能包含关注的log
+func (query bloomQuery) matches(bloom types.Bloom) bool {
+	for _, group := range query {
+		matched := true
+		for _, item := range group {
+			if !bloom.Test(item) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+//获取规则匹配的交易必须产生的log对应的bloom条件,规则可匹配不产生log的交易时返回false;
+//logAddresses获取LogMatched规则的关注地址,不可枚举时返回false
+func ruleBloom(rule TxRule, logAddresses func() ([]common.Address, bool)) (bloomQuery, bool) {
+	switch r := rule.(type) {
+	case *logMatchedRule:
+		//关注地址作为indexed topic出现在log中
+		addrs, ok := logAddresses()
+		if !ok {
+			return nil, false
+		}
+		query := make(bloomQuery, len(addrs))
+		for i, addr := range addrs {
+			query[i] = [][]byte{common.BytesToHash(addr.Bytes()).Bytes()}
+		}
+		return query, len(query) > 0
+	case *logRule:
+		var group [][]byte
+		if r.address != nil {
+			group = append(group, r.address.Bytes())
+		}
+		if r.topic0 != nil {
+			group = append(group, r.topic0.Bytes())
+		}
+		return bloomQuery{group}, len(group) > 0
+	case *andRule:
+		//任一子规则只匹配产生log的交易时,使用该子规则的条件
+		for _, sub := range r.rules {
+			if query, ok := ruleBloom(sub, logAddresses); ok {
+				return query, true
+			}
+		}
+	case *orRule:
+		//所有子规则都只匹配产生log的交易时,合并各子规则的条件
+		var query bloomQuery
+		for _, sub := range r.rules {
+			subQuery, ok := ruleBloom(sub, logAddresses)
+			if !ok {
+				return nil, false
+			}
+			query = append(query, subQuery...)
+		}
+		return query, len(query) > 0
+	}
+
+	return nil, false
+}
+
+//获取bloom预检查条件,只有关注的交易都需产生特定log时才使用bloom预检查;
+//LogMatched规则的条件随关注地址变化,每个区块重新获取
+func (matcher *txMatcher) bloomQuery(txWatcher TxWatcher) (bloomQuery, bool) {
+	bloomWatcher, ok := txWatcher.(BloomTxWatcher)
+	if !ok || !bloomWatcher.BloomSkipping() {
+		return nil, false
+	}
+	//按from/to匹配时普通转账等不产生log的交易也可能匹配;提款和gas统计需要每个区块,跟踪余额时由扫描器按区块判断
+	if matcher.isInterestedTx != nil || matcher.rule == nil || matcher.withdrawalWatcher != nil || matcher.gasAnalytics != nil {
+		return nil, false
+	}
+
+	return ruleBloom(matcher.rule, func() ([]common.Address, bool) {
+		logWatcher, ok := txWatcher.(LogAddressTxWatcher)
+		if !ok || matcher.isInterestedLogAddress == nil {
+			return nil, false
+		}
+		return logWatcher.GetLogAddresses(maxBloomLogAddresses)
+	})
+}
+
+//bloom预检查的header缓存,追块时批量预取后续区块的header,
+//连续取满时加倍批量大小,取到未出块的区块时回到单个区块
+type headerCache struct {
+	batch   int
+	headers map[uint64]*blockHeader
+}
+
+//区块header及节点返回的区块hash
+type blockHeader struct {
+	header *types.Header
+	hash   common.Hash
+}
+
+func newHeaderCache() *headerCache {
+	return &headerCache{
+		batch:   1,
+		headers: make(map[uint64]*blockHeader),
+	}
+}
+
+//获取区块header,未缓存时从blockNumber开始批量获取,不超过endBlock(为0时不限制);区块不存在时返回ethereum.NotFound
+func (cache *headerCache) get(client *ethclient.Client, blockNumber uint64, endBlock uint64) (*blockHeader, error) {
+	if header := cache.headers[blockNumber]; header != nil {
+		delete(cache.headers, blockNumber)
+		return header, nil
+	}
+
+	count := cache.batch
+	if endBlock > 0 && blockNumber+uint64(count)-1 > endBlock {
+		count = int(endBlock - blockNumber + 1)
+	}
+	headers, err := getHeaders(client, blockNumber, count)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == count {
+		cache.batch = min(cache.batch*2, maxHeaderBatch)
+	} else {
+		cache.batch = 1
+	}
+	if len(headers) == 0 {
+		return nil, ethereum.NotFound
+	}
+	for i, header := range headers[1:] {
+		cache.headers[blockNumber+uint64(i)+1] = header
+	}
+
+	return headers[0], nil
+}
+
+//丢弃缓存的header,区块重新扫描时使用
+func (cache *headerCache) reset() {
+	if len(cache.headers) > 0 {
+		cache.headers = make(map[uint64]*blockHeader)
+	}
+	cache.batch = 1
+}
+
+//批量获取从fromBlock开始的count个区块header(eth_getBlockByNumber,不含交易),返回连续的已出块header
+func getHeaders(client *ethclient.Client, fromBlock uint64, count int) ([]*blockHeader, error) {
+	batch := make([]rpc.BatchElem, count)
+	for i := range batch {
+		batch[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(fromBlock + uint64(i)), false},
+			Result: &json.RawMessage{},
+		}
+	}
+	err := client.Client().BatchCallContext(context.Background(), batch)
+	if err != nil {
+		return nil, err
+	}
+
+	var headers []*blockHeader
+	for i, elem := range batch {
+		raw := *elem.Result.(*json.RawMessage)
+		if elem.Error != nil {
+			//之后的区块出错时只返回已获取的header
+			if i == 0 {
+				return nil, elem.Error
+			}
+			break
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			break
+		}
+		header := &types.Header{}
+		err = json.Unmarshal(raw, header)
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Hash common.Hash `json:"hash"`
+		}
+		err = json.Unmarshal(raw, &body)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, &blockHeader{header: header, hash: body.Hash})
+	}
+
+	return headers, nil
+}
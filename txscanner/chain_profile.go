@@ -0,0 +1,100 @@
+package txscanner
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	//以太坊PoW/PoS共识
+	ConsensusEthereum = ""
+	//clique POA共识,出块地址由header extraData中的签名恢复
+	ConsensusClique = "clique"
+	//parlia POA共识(BSC),出块地址为header的coinbase
+	ConsensusParlia = "parlia"
+)
+
+//链配置,描述L2/侧链与以太坊主网的差异
+type ChainProfile struct {
+	Name string
+
+	//使用原始rpc数据解析区块和receipt:支持L2特有的交易类型(如Optimism deposit交易、Arbitrum系统交易),
+	//receipt中的L1费用字段,以及使用节点返回的区块hash(侧链header含额外字段时本地计算的hash可能不一致)
+	RawBlocks bool
+
+	//共识类型,POA链可通过BlockSigner获取出块地址
+	Consensus string
+}
+
+var (
+	ProfileEthereum  = &ChainProfile{Name: "ethereum"}
+	ProfileOptimism  = &ChainProfile{Name: "optimism", RawBlocks: true}
+	ProfileBase      = &ChainProfile{Name: "base", RawBlocks: true}
+	ProfileArbitrum  = &ChainProfile{Name: "arbitrum", RawBlocks: true}
+	ProfileBSC       = &ChainProfile{Name: "bsc", RawBlocks: true, Consensus: ConsensusParlia}
+	ProfilePolygon   = &ChainProfile{Name: "polygon", RawBlocks: true}
+	ProfileCliquePOA = &ChainProfile{Name: "clique", RawBlocks: true, Consensus: ConsensusClique}
+	_chainProfiles   = map[uint64]*ChainProfile{
+		1:        ProfileEthereum,
+		10:       ProfileOptimism,
+		56:       ProfileBSC,
+		97:       ProfileBSC,
+		137:      ProfilePolygon,
+		8453:     ProfileBase,
+		42161:    ProfileArbitrum,
+		42170:    ProfileArbitrum,
+		11155420: ProfileOptimism,
+		84532:    ProfileBase,
+		421614:   ProfileArbitrum,
+	}
+	_chainProfilesLock sync.RWMutex
+)
+
+//根据链id获取已知的链配置,未知链返回以太坊配置
+func GetChainProfile(chainID *big.Int) *ChainProfile {
+	if chainID != nil && chainID.IsUint64() {
+		_chainProfilesLock.RLock()
+		profile, b := _chainProfiles[chainID.Uint64()]
+		_chainProfilesLock.RUnlock()
+		if b {
+			return profile
+		}
+	}
+
+	return ProfileEthereum
+}
+
+//注册链配置,覆盖已有的配置,可与扫描并发调用
+func RegisterChainProfile(chainID uint64, profile *ChainProfile) {
+	_chainProfilesLock.Lock()
+	defer _chainProfilesLock.Unlock()
+
+	_chainProfiles[chainID] = profile
+}
+
+//获取POA区块的出块地址,非POA链返回header的coinbase
+func (profile *ChainProfile) BlockSigner(header *types.Header) (common.Address, error) {
+	if profile.Consensus != ConsensusClique {
+		return header.Coinbase, nil
+	}
+
+	extraSeal := crypto.SignatureLength
+	if len(header.Extra) < extraSeal {
+		return common.Address{}, errors.New("clique header extra-data missing signature")
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+	pubkey, err := crypto.Ecrecover(clique.SealHash(header).Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	return signer, nil
+}
@@ -0,0 +1,139 @@
+package txscanner_test
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+func TestScanTxOptimismDepositAndL1Fee(t *testing.T) {
+	chain := fakechain.NewChain(10)
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(5))
+	transfer := chain.Transfer(2, depositAddr, big.NewInt(6))
+	//deposit交易(0x7e)没有签名,包含sourceHash和mint字段
+	chain.SetTxFields(deposit.Hash(), map[string]interface{}{
+		"type":       "0x7e",
+		"sourceHash": hexutil.Encode(make([]byte, 32)),
+		"mint":       "0x5",
+		"isSystemTx": false,
+		"v":          nil,
+		"r":          nil,
+		"s":          nil,
+	})
+	chain.SetReceiptFields(transfer.Hash(), map[string]interface{}{
+		"l1GasUsed":  "0x640",
+		"l1GasPrice": "0x3b9aca00",
+		"l1Fee":      "0x2e90edd000",
+	})
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit, transfer)
+
+	tx := recorder.txs[0]
+	if tx.Type != 0x7e || tx.From != hexAddress(fakechain.Address(1)) || tx.Value.Int64() != 5 || tx.V != nil || tx.L1Fee != nil {
+		t.Fatalf("unexpected deposit %+v", tx)
+	}
+	tx = recorder.txs[1]
+	if tx.L1GasUsed.Int64() != 0x640 || tx.L1GasPrice.Int64() != 0x3b9aca00 || tx.L1Fee.Int64() != 0x2e90edd000 {
+		t.Fatalf("unexpected l1 fee %v %v %v", tx.L1GasUsed, tx.L1GasPrice, tx.L1Fee)
+	}
+}
+
+func TestScanTxArbitrumTxTypes(t *testing.T) {
+	chain := fakechain.NewChain(42161)
+	internal := chain.Transfer(1, depositAddr, big.NewInt(1))
+	retry := chain.Transfer(2, depositAddr, big.NewInt(2))
+	//0x6a为ArbOS内部交易,0x68为retryable的自动重试交易
+	chain.SetTxFields(internal.Hash(), map[string]interface{}{"type": "0x6a"})
+	chain.SetTxFields(retry.Hash(), map[string]interface{}{"type": "0x68", "ticketId": hexutil.Encode(make([]byte, 32))})
+	chain.SetReceiptFields(retry.Hash(), map[string]interface{}{"gasUsedForL1": "0x2a"})
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), internal, retry)
+	if recorder.txs[0].Type != 0x6a || recorder.txs[0].L1GasUsed != nil {
+		t.Fatalf("unexpected internal tx %+v", recorder.txs[0])
+	}
+	if recorder.txs[1].Type != 0x68 || recorder.txs[1].L1GasUsed.Int64() != 0x2a || recorder.txs[1].L1Fee != nil {
+		t.Fatalf("unexpected retry tx %+v", recorder.txs[1])
+	}
+}
+
+func TestScanTxCliqueBlockSigner(t *testing.T) {
+	chain := fakechain.NewChain(12345)
+	chain.SetCliqueSigner(fakechain.Key(7))
+	chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.MineN(2)
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.SetChainProfile(txscanner.ProfileCliquePOA)
+	var lock sync.Mutex
+	var signers []string
+	watcher.SetOnBlock(func(block *txscanner.BlockInfo) error {
+		lock.Lock()
+		defer lock.Unlock()
+		signers = append(signers, block.Signer)
+		return nil
+	})
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(signers) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(signers))
+	}
+	for _, signer := range signers {
+		if signer != hexAddress(fakechain.Address(7)) {
+			t.Fatalf("got signer %s, want %s", signer, hexAddress(fakechain.Address(7)))
+		}
+	}
+
+	//非clique链返回coinbase
+	header := chain.BlockByNumber(1).Header()
+	signer, err := txscanner.ProfileEthereum.BlockSigner(header)
+	if err != nil || signer != header.Coinbase {
+		t.Fatalf("unexpected signer %s %v", signer.Hex(), err)
+	}
+	header.Extra = header.Extra[:10]
+	if _, err = txscanner.ProfileCliquePOA.BlockSigner(header); err == nil {
+		t.Fatal("expected missing signature error")
+	}
+}
+
+func TestRegisterChainProfile(t *testing.T) {
+	profile := &txscanner.ChainProfile{Name: "mychain", RawBlocks: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			txscanner.RegisterChainProfile(uint64(900000+i), profile)
+			txscanner.GetChainProfile(big.NewInt(int64(900000 + i)))
+		}(i)
+	}
+	wg.Wait()
+	if txscanner.GetChainProfile(big.NewInt(900003)) != profile || txscanner.GetChainProfile(big.NewInt(900099)) != txscanner.ProfileEthereum {
+		t.Fatal("unexpected profile")
+	}
+}
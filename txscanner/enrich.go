@@ -0,0 +1,168 @@
+package txscanner
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/warrior21st/ethblockscanner/addrlabel"
+)
+
+//标注交易地址的watcher,扫描器在tx回调前使用Enricher标注from、to及receipt中log的合约地址
+type EnrichTxWatcher interface {
+	TxWatcher
+
+	//获取交易标注,为nil时不标注
+	GetEnricher() *Enricher
+}
+
+//地址类型
+type AddressKind uint8
+
+const (
+	//未分类
+	AddressUnknown AddressKind = iota
+	//外部账户,包括EIP-7702委托代码的账户
+	AddressEOA
+	//合约
+	AddressContract
+)
+
+var addressKindNames = map[AddressKind]string{
+	AddressUnknown:  "unknown",
+	AddressEOA:      "eoa",
+	AddressContract: "contract",
+}
+
+func (kind AddressKind) String() string {
+	if name, ok := addressKindNames[kind]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+//根据名称解析地址类型,未知名称返回AddressUnknown
+func ParseAddressKind(name string) AddressKind {
+	for kind, kindName := range addressKindNames {
+		if strings.EqualFold(name, kindName) {
+			return kind
+		}
+	}
+
+	return AddressUnknown
+}
+
+//地址标注信息
+type AddressInfo struct {
+	//地址标签,未标注时为nil
+	Label *addrlabel.Label
+	//未开启分类时为AddressUnknown
+	Kind AddressKind
+}
+
+//EIP-7702委托代码前缀
+var delegationPrefix = []byte{0xef, 0x01, 0x00}
+
+//交易标注:从标签提供者获取地址标签,并区分合约和EOA地址;
+//from总是EOA,log地址总是合约,只有to地址需要查询最新区块的eth_getCode,查询结果按地址缓存;
+//之后才部署合约的地址(如CREATE2预计算地址)在缓存淘汰前仍为EOA
+type Enricher struct {
+	provider  addrlabel.Provider
+	codeKinds *lru.Cache[common.Address, AddressKind]
+}
+
+//构造交易标注,provider为nil时不标注标签,codeCacheSize大于0时区分合约和EOA地址
+func NewEnricher(provider addrlabel.Provider, codeCacheSize int) *Enricher {
+	enricher := &Enricher{provider: provider}
+	if codeCacheSize > 0 {
+		enricher.codeKinds = lru.NewCache[common.Address, AddressKind](codeCacheSize)
+	}
+
+	return enricher
+}
+
+//标注tx的from、to及receipt中log的合约地址,需在设置receipt后调用
+func (enricher *Enricher) Enrich(client *ethclient.Client, tx *TxInfo) error {
+	var err error
+	tx.FromInfo, err = enricher.addressInfo(client, common.HexToAddress(tx.From), AddressEOA)
+	if err != nil {
+		return err
+	}
+	if tx.To != "" {
+		tx.ToInfo, err = enricher.addressInfo(client, common.HexToAddress(tx.To), AddressUnknown)
+		if err != nil {
+			return err
+		}
+	}
+
+	receipt := tx.Receipt()
+	if receipt == nil || len(receipt.Logs) == 0 {
+		return nil
+	}
+	tx.LogAddressInfos = make(map[string]*AddressInfo)
+	for _, log := range receipt.Logs {
+		key := addressString(log.Address)
+		if tx.LogAddressInfos[key] != nil {
+			continue
+		}
+		tx.LogAddressInfos[key], err = enricher.addressInfo(client, log.Address, AddressContract)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//标注log的合约地址,不需要查询节点
+func (enricher *Enricher) EnrichLog(log *types.Log) (*AddressInfo, error) {
+	return enricher.addressInfo(nil, log.Address, AddressContract)
+}
+
+//获取地址标注信息,kind为AddressUnknown时查询地址代码
+func (enricher *Enricher) addressInfo(client *ethclient.Client, addr common.Address, kind AddressKind) (*AddressInfo, error) {
+	info := &AddressInfo{}
+	if enricher.provider != nil {
+		label, err := enricher.provider.Label(addr)
+		if err != nil {
+			return nil, err
+		}
+		info.Label = label
+	}
+	if enricher.codeKinds == nil {
+		return info, nil
+	}
+
+	if kind == AddressUnknown {
+		var err error
+		kind, err = enricher.codeKind(client, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	info.Kind = kind
+
+	return info, nil
+}
+
+func (enricher *Enricher) codeKind(client *ethclient.Client, addr common.Address) (AddressKind, error) {
+	if kind, ok := enricher.codeKinds.Get(addr); ok {
+		return kind, nil
+	}
+	code, err := client.CodeAt(context.Background(), addr, nil)
+	if err != nil {
+		return AddressUnknown, err
+	}
+	kind := AddressEOA
+	if len(code) > 0 && !bytes.HasPrefix(code, delegationPrefix) {
+		kind = AddressContract
+	}
+	enricher.codeKinds.Add(addr, kind)
+
+	return kind, nil
+}
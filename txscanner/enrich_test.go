@@ -0,0 +1,112 @@
+package txscanner_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/warrior21st/ethblockscanner/addrlabel"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+func TestScanTxEnricher(t *testing.T) {
+	hotWallet := fakechain.Address(1)
+	chain := fakechain.NewChain(1)
+	chain.SetCode(tokenAddr, []byte{0x60, 0x80})
+	//EIP-7702委托代码的账户仍为EOA
+	chain.SetCode(depositAddr, append([]byte{0xef, 0x01, 0x00}, tokenAddr.Bytes()...))
+	transfer := chain.AddTx(1, &tokenAddr, nil, []byte{0xa9, 0x05, 0x9c, 0xbb}, fakechain.NewLog(tokenAddr, []common.Hash{transferTopic}, nil))
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+	again := chain.AddTx(1, &tokenAddr, nil, nil)
+	chain.Mine()
+
+	labels := addrlabel.NewMemoryProvider()
+	labels.Set(hotWallet, &addrlabel.Label{Name: "our hot wallet", Category: "internal"})
+	labels.Set(tokenAddr, &addrlabel.Label{Name: "USDT contract", Category: "token"})
+	recorder := &txRecorder{}
+	watcher, servers := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedFrom(hexAddress(hotWallet))
+	watcher.SetEnricher(txscanner.NewEnricher(labels, 16))
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertHashes(t, recorder.hashes(), transfer, deposit, again)
+	tx := recorder.txs[0]
+	if tx.FromInfo.Label.Name != "our hot wallet" || tx.FromInfo.Kind != txscanner.AddressEOA {
+		t.Fatalf("unexpected from info %+v", tx.FromInfo)
+	}
+	if tx.ToInfo.Label.Category != "token" || tx.ToInfo.Kind != txscanner.AddressContract {
+		t.Fatalf("unexpected to info %+v", tx.ToInfo)
+	}
+	if info := tx.LogAddressInfos[hexAddress(tokenAddr)]; len(tx.LogAddressInfos) != 1 || info.Label.Name != "USDT contract" || info.Kind != txscanner.AddressContract {
+		t.Fatalf("unexpected log address infos %+v", tx.LogAddressInfos)
+	}
+	if info := recorder.txs[1].ToInfo; info.Label != nil || info.Kind != txscanner.AddressEOA || recorder.txs[1].LogAddressInfos != nil {
+		t.Fatalf("unexpected deposit to info %+v", info)
+	}
+	//to地址的代码类型已缓存
+	if servers[0].Calls("eth_getCode") != 2 {
+		t.Fatalf("eth_getCode calls %d", servers[0].Calls("eth_getCode"))
+	}
+
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &txscanner.TxInfo{}
+	err = json.Unmarshal(data, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded.FromInfo.Label != *tx.FromInfo.Label || decoded.ToInfo.Kind != txscanner.AddressContract ||
+		decoded.LogAddressInfos[hexAddress(tokenAddr)].Label.Name != "USDT contract" {
+		t.Fatalf("round trip %s", data)
+	}
+}
+
+//首次查询failAddr时返回错误的标签提供者
+type flakyLabels struct {
+	lock     sync.Mutex
+	failAddr common.Address
+	failed   bool
+}
+
+func (labels *flakyLabels) Label(addr common.Address) (*addrlabel.Label, error) {
+	labels.lock.Lock()
+	defer labels.lock.Unlock()
+
+	if addr == labels.failAddr && !labels.failed {
+		labels.failed = true
+		return nil, errors.New("label store unavailable")
+	}
+
+	return nil, nil
+}
+
+func TestScanTxEnrichErrorDoesNotRedeliver(t *testing.T) {
+	other := common.HexToAddress("0x00000000000000000000000000000000000000f2")
+	chain := fakechain.NewChain(1)
+	first := chain.Transfer(1, depositAddr, big.NewInt(1))
+	second := chain.Transfer(2, other, big.NewInt(1))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 2, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	watcher.AddInterestedTo(hexAddress(other))
+	watcher.SetEnricher(txscanner.NewEnricher(&flakyLabels{failAddr: other}, 0))
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//第二笔交易标注失败时第一笔交易尚未回调
+	assertHashes(t, recorder.hashes(), first, second)
+}
@@ -0,0 +1,230 @@
+package txscanner
+
+import (
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+//统计区块gas和手续费的watcher,扫描器在每个区块的回调完成后将区块交给GasAnalytics;
+//开启后不再使用bloom预检查跳过区块
+type GasTxWatcher interface {
+	TxWatcher
+
+	//获取gas统计,为nil时不统计
+	GetGasAnalytics() *GasAnalytics
+}
+
+//默认统计的百分位
+var DefaultGasPercentiles = []float64{10, 25, 50, 75, 90}
+
+//区块的gas和手续费统计
+type BlockGasStats struct {
+	BlockNumber   uint64
+	BlockHash     string
+	BlockUnixSecs uint64
+	//London之前的区块为nil
+	BaseFee  *big.Int
+	GasUsed  uint64
+	GasLimit uint64
+	//gasUsed / gasLimit
+	Utilization float64
+	TxCount     int
+	//按GasAnalytics的百分位统计的小费(实际gas price - base fee)和实际gas price,区块无交易时为nil
+	PriorityFeePercentiles       []*big.Int
+	EffectiveGasPricePercentiles []*big.Int
+	//区块内交易实际gas price的最小值和最大值,区块无交易时为nil
+	MinEffectiveGasPrice *big.Int
+	MaxEffectiveGasPrice *big.Int
+
+	//排序后的每笔交易的小费和实际gas price,用于窗口内查询
+	priorityFees       []*big.Int
+	effectiveGasPrices []*big.Int
+}
+
+//区块gas和手续费统计,保留最近window个区块用于滚动窗口查询
+type GasAnalytics struct {
+	window      int
+	percentiles []float64
+	onStats     func(*BlockGasStats) error
+
+	lock   sync.RWMutex
+	blocks []*BlockGasStats
+}
+
+//构造gas统计,percentiles为nil时使用DefaultGasPercentiles,onStats为每个区块统计完成后的回调,可为nil
+func NewGasAnalytics(window int, percentiles []float64, onStats func(*BlockGasStats) error) *GasAnalytics {
+	if percentiles == nil {
+		percentiles = DefaultGasPercentiles
+	}
+	if window < 1 {
+		window = 1
+	}
+
+	return &GasAnalytics{
+		window:      window,
+		percentiles: percentiles,
+		onStats:     onStats,
+	}
+}
+
+//统计区块并加入窗口,区块号不大于窗口内最新区块时(重组或重新扫描)替换该区块及之后的统计,返回回调的错误
+func (analytics *GasAnalytics) AddBlock(block *Block) error {
+	stats := analytics.newBlockGasStats(block)
+
+	analytics.lock.Lock()
+	i := sort.Search(len(analytics.blocks), func(i int) bool {
+		return analytics.blocks[i].BlockNumber >= stats.BlockNumber
+	})
+	analytics.blocks = append(analytics.blocks[:i], stats)
+	if len(analytics.blocks) > analytics.window {
+		analytics.blocks = analytics.blocks[len(analytics.blocks)-analytics.window:]
+	}
+	analytics.lock.Unlock()
+
+	if analytics.onStats != nil {
+		return analytics.onStats(stats)
+	}
+
+	return nil
+}
+
+func (analytics *GasAnalytics) newBlockGasStats(block *Block) *BlockGasStats {
+	header := block.Header
+	stats := &BlockGasStats{
+		BlockNumber:   header.Number.Uint64(),
+		BlockHash:     hashString(block.Hash),
+		BlockUnixSecs: header.Time,
+		BaseFee:       header.BaseFee,
+		GasUsed:       header.GasUsed,
+		GasLimit:      header.GasLimit,
+		TxCount:       len(block.Txs),
+	}
+	if header.GasLimit > 0 {
+		stats.Utilization = float64(header.GasUsed) / float64(header.GasLimit)
+	}
+	if len(block.Txs) == 0 {
+		return stats
+	}
+
+	for _, btx := range block.Txs {
+		gasPrice := btx.EffectiveGasPrice(header.BaseFee)
+		priorityFee := new(big.Int).Set(gasPrice)
+		if header.BaseFee != nil {
+			priorityFee.Sub(priorityFee, header.BaseFee)
+			//L2系统交易等gas price低于base fee的交易小费记为0
+			if priorityFee.Sign() < 0 {
+				priorityFee.SetUint64(0)
+			}
+		}
+		stats.effectiveGasPrices = append(stats.effectiveGasPrices, gasPrice)
+		stats.priorityFees = append(stats.priorityFees, priorityFee)
+	}
+	sortBigInts(stats.effectiveGasPrices)
+	sortBigInts(stats.priorityFees)
+	stats.MinEffectiveGasPrice = stats.effectiveGasPrices[0]
+	stats.MaxEffectiveGasPrice = stats.effectiveGasPrices[len(stats.effectiveGasPrices)-1]
+	for _, percentile := range analytics.percentiles {
+		stats.PriorityFeePercentiles = append(stats.PriorityFeePercentiles, percentileOf(stats.priorityFees, percentile))
+		stats.EffectiveGasPricePercentiles = append(stats.EffectiveGasPricePercentiles, percentileOf(stats.effectiveGasPrices, percentile))
+	}
+
+	return stats
+}
+
+//获取窗口内最新区块的统计,窗口为空时返回nil
+func (analytics *GasAnalytics) Latest() *BlockGasStats {
+	analytics.lock.RLock()
+	defer analytics.lock.RUnlock()
+
+	if len(analytics.blocks) == 0 {
+		return nil
+	}
+
+	return analytics.blocks[len(analytics.blocks)-1]
+}
+
+//获取窗口内最近n个区块的统计(按区块号升序),n不大于0时返回窗口内全部区块
+func (analytics *GasAnalytics) Blocks(n int) []*BlockGasStats {
+	analytics.lock.RLock()
+	defer analytics.lock.RUnlock()
+
+	return append([]*BlockGasStats(nil), analytics.lastBlocks(n)...)
+}
+
+//最近n个区块内所有交易小费的百分位,无交易时返回nil
+func (analytics *GasAnalytics) PriorityFeePercentile(percentile float64, n int) *big.Int {
+	analytics.lock.RLock()
+	defer analytics.lock.RUnlock()
+
+	var fees []*big.Int
+	for _, stats := range analytics.lastBlocks(n) {
+		fees = append(fees, stats.priorityFees...)
+	}
+	sortBigInts(fees)
+
+	return percentileOf(fees, percentile)
+}
+
+//最近n个区块内所有交易实际gas price的百分位,无交易时返回nil
+func (analytics *GasAnalytics) EffectiveGasPricePercentile(percentile float64, n int) *big.Int {
+	analytics.lock.RLock()
+	defer analytics.lock.RUnlock()
+
+	var prices []*big.Int
+	for _, stats := range analytics.lastBlocks(n) {
+		prices = append(prices, stats.effectiveGasPrices...)
+	}
+	sortBigInts(prices)
+
+	return percentileOf(prices, percentile)
+}
+
+//最近n个区块的平均gas使用率,窗口为空时返回0
+func (analytics *GasAnalytics) AverageUtilization(n int) float64 {
+	analytics.lock.RLock()
+	defer analytics.lock.RUnlock()
+
+	blocks := analytics.lastBlocks(n)
+	if len(blocks) == 0 {
+		return 0
+	}
+	total := float64(0)
+	for _, stats := range blocks {
+		total += stats.Utilization
+	}
+
+	return total / float64(len(blocks))
+}
+
+//调用方需持有锁
+func (analytics *GasAnalytics) lastBlocks(n int) []*BlockGasStats {
+	if n <= 0 || n > len(analytics.blocks) {
+		n = len(analytics.blocks)
+	}
+
+	return analytics.blocks[len(analytics.blocks)-n:]
+}
+
+func sortBigInts(values []*big.Int) {
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Cmp(values[j]) < 0
+	})
+}
+
+//按最近秩法计算已排序数据的百分位(0-100),数据为空时返回nil
+func percentileOf(sorted []*big.Int, percentile float64) *big.Int {
+	if len(sorted) == 0 {
+		return nil
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+
+	return sorted[rank-1]
+}
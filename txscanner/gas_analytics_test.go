@@ -0,0 +1,115 @@
+package txscanner_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+func gwei(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(params.GWei))
+}
+
+func TestScanTxGasAnalytics(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	for i := 1; i <= 4; i++ {
+		chain.TransferWithTip(i, depositAddr, big.NewInt(1), gwei(int64(i)))
+	}
+	chain.Mine()
+	chain.Mine()
+
+	var blocks []*txscanner.BlockGasStats
+	analytics := txscanner.NewGasAnalytics(2, nil, func(stats *txscanner.BlockGasStats) error {
+		blocks = append(blocks, stats)
+		return nil
+	})
+	watcher, _ := newWatcher(t, chain, 1, &txRecorder{})
+	//bloom预检查会跳过所有区块,开启gas统计后不再跳过
+	watcher.SetTxRule(txscanner.LogEmitted(hexAddress(tokenAddr), ""))
+	watcher.SetBloomSkipping(true)
+	watcher.SetGasAnalytics(analytics)
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blocks) != 2 {
+		t.Fatalf("got %d block stats", len(blocks))
+	}
+	stats := blocks[0]
+	if stats.BlockNumber != 1 || stats.TxCount != 4 || stats.BaseFee.Cmp(fakechain.BaseFee) != 0 ||
+		stats.Utilization != float64(4*fakechain.TransferGas)/float64(fakechain.BlockGasLimit) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	//最近秩法:10/25/50/75/90百分位分别为第1/1/2/3/4笔
+	for i, want := range []int64{1, 1, 2, 3, 4} {
+		if stats.PriorityFeePercentiles[i].Cmp(gwei(want)) != 0 || stats.EffectiveGasPricePercentiles[i].Cmp(gwei(want+1)) != 0 {
+			t.Fatalf("percentile %v: %v %v", txscanner.DefaultGasPercentiles[i], stats.PriorityFeePercentiles[i], stats.EffectiveGasPricePercentiles[i])
+		}
+	}
+	if stats.MinEffectiveGasPrice.Cmp(gwei(2)) != 0 || stats.MaxEffectiveGasPrice.Cmp(gwei(5)) != 0 {
+		t.Fatalf("min %v max %v", stats.MinEffectiveGasPrice, stats.MaxEffectiveGasPrice)
+	}
+	if blocks[1].TxCount != 0 || blocks[1].PriorityFeePercentiles != nil || analytics.Latest() != blocks[1] {
+		t.Fatalf("unexpected empty block stats %+v", blocks[1])
+	}
+
+	if fee := analytics.PriorityFeePercentile(50, 0); fee.Cmp(gwei(2)) != 0 {
+		t.Fatalf("window priority fee %v", fee)
+	}
+	if price := analytics.EffectiveGasPricePercentile(90, 0); price.Cmp(gwei(5)) != 0 {
+		t.Fatalf("window gas price %v", price)
+	}
+	if analytics.PriorityFeePercentile(50, 1) != nil || analytics.AverageUtilization(0) != stats.Utilization/2 {
+		t.Fatalf("unexpected window utilization %v", analytics.AverageUtilization(0))
+	}
+
+	//重新统计已有区块时替换该区块及之后的统计,窗口只保留最近2个区块
+	analytics.AddBlock(&txscanner.Block{Header: &types.Header{Number: big.NewInt(2), GasLimit: 10, GasUsed: 5}})
+	analytics.AddBlock(&txscanner.Block{Header: &types.Header{Number: big.NewInt(3), GasLimit: 10, GasUsed: 10}})
+	window := analytics.Blocks(0)
+	if len(window) != 2 || window[0].BlockNumber != 2 || window[0].Utilization != 0.5 || analytics.AverageUtilization(2) != 0.75 {
+		t.Fatalf("unexpected window %+v", window)
+	}
+}
+
+func TestBackfillerSkipsGasAnalytics(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	for i := 1; i <= 4; i++ {
+		chain.TransferWithTip(i, depositAddr, big.NewInt(1), gwei(int64(i)))
+		chain.Mine()
+	}
+
+	var blocks []*txscanner.BlockGasStats
+	analytics := txscanner.NewGasAnalytics(2, nil, func(stats *txscanner.BlockGasStats) error {
+		blocks = append(blocks, stats)
+		return nil
+	})
+	watcher, _ := newWatcher(t, chain, 2, &txRecorder{})
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	watcher.SetGasAnalytics(analytics)
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := analytics.Latest()
+	if len(blocks) != 4 || latest.BlockNumber != 4 {
+		t.Fatalf("unexpected live stats %d %+v", len(blocks), latest)
+	}
+
+	//回溯扫描旧区块不影响实时统计窗口
+	backfiller := txscanner.NewBackfiller(watcher, 1)
+	backfiller.SetConcurrency(2)
+	err = backfiller.Run(1, chain.Head())
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := analytics.Blocks(0)
+	if len(blocks) != 4 || analytics.Latest() != latest || len(window) != 2 || window[0].BlockNumber != 3 {
+		t.Fatalf("backfill changed gas window %+v", window)
+	}
+}
@@ -0,0 +1,309 @@
+package txscanner
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+//跟踪发送地址nonce的watcher,扫描器在tx回调后将tx交给NonceTracker(告警出错时只输出日志),每轮扫描后按检查间隔检查交易池
+type NonceTxWatcher interface {
+	TxWatcher
+
+	//获取nonce跟踪器,为nil时不跟踪
+	GetNonceTracker() *NonceTracker
+}
+
+//nonce告警类型
+type NonceAlertKind int
+
+const (
+	//跟踪的tx之前有nonce不在交易池中,tx无法打包
+	NonceGap NonceAlertKind = iota + 1
+	//跟踪的tx被相同nonce、不同hash的tx替换
+	NonceReplaced
+	//跟踪的tx未打包且不在交易池中
+	NonceDropped
+	//地址的下一个nonce在交易池中等待打包超过pendingTimeout
+	NonceLongPending
+)
+
+var nonceAlertKindNames = map[NonceAlertKind]string{
+	NonceGap:         "gap",
+	NonceReplaced:    "replaced",
+	NonceDropped:     "dropped",
+	NonceLongPending: "long-pending",
+}
+
+func (kind NonceAlertKind) String() string {
+	if name, ok := nonceAlertKindNames[kind]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+//nonce告警
+type NonceAlert struct {
+	Kind    NonceAlertKind
+	Address string
+	Nonce   uint64
+	//跟踪的tx hash,NonceLongPending时未跟踪该nonce的tx则为空
+	TxHash string
+	//NonceReplaced时替换tx的hash和所在区块号,替换tx未被扫描到时为空
+	ReplacedBy  string
+	BlockNumber uint64
+	//NonceGap时缺失的第一个nonce,缺失范围为[MissingFrom, Nonce)
+	MissingFrom uint64
+	//NonceLongPending时开始等待的时间
+	PendingSince time.Time
+}
+
+//发送地址的nonce跟踪器:根据扫描到的tx(TxInfo.Nonce)、链上及交易池中的nonce(eth_getTransactionCount latest/pending)
+//和跟踪的tx在交易池中的状态(eth_getTransactionByHash),检测nonce缺失、tx被替换、被丢弃和长时间未打包
+type NonceTracker struct {
+	checkInterval  time.Duration
+	pendingTimeout time.Duration
+	onAlert        func(*NonceAlert) error
+
+	lock      sync.Mutex
+	senders   map[common.Address]*senderNonces
+	lastCheck time.Time
+}
+
+type senderNonces struct {
+	//已发出未确认的tx,按nonce索引
+	sent map[uint64]*sentTx
+	//最近一次检查时的链上nonce及该nonce开始等待打包的时间
+	nonce               uint64
+	pendingSince        time.Time
+	longPendingReported bool
+}
+
+type sentTx struct {
+	hash   common.Hash
+	sentAt time.Time
+	//已回调的告警类型,每笔tx每种告警只回调一次
+	reported map[NonceAlertKind]bool
+}
+
+//构造nonce跟踪器,checkInterval为扫描器检查交易池的间隔,pendingTimeout为告警长时间未打包的等待时间,onAlert为nil时忽略告警
+func NewNonceTracker(checkInterval time.Duration, pendingTimeout time.Duration, onAlert func(*NonceAlert) error) *NonceTracker {
+	if onAlert == nil {
+		onAlert = func(*NonceAlert) error { return nil }
+	}
+	return &NonceTracker{
+		checkInterval:  checkInterval,
+		pendingTimeout: pendingTimeout,
+		onAlert:        onAlert,
+		senders:        make(map[common.Address]*senderNonces),
+	}
+}
+
+//添加跟踪的发送地址
+func (tracker *NonceTracker) AddSender(address string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker.sender(common.HexToAddress(address))
+}
+
+//移除跟踪的发送地址及其跟踪的tx
+func (tracker *NonceTracker) RemoveSender(address string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	delete(tracker.senders, common.HexToAddress(address))
+}
+
+//跟踪已发出的tx,发送地址未添加时自动添加;相同nonce再次发出时(如加速)替换之前跟踪的tx
+func (tracker *NonceTracker) TrackSent(from string, nonce uint64, txHash string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker.sender(common.HexToAddress(from)).sent[nonce] = &sentTx{
+		hash:     common.HexToHash(txHash),
+		sentAt:   time.Now(),
+		reported: make(map[NonceAlertKind]bool),
+	}
+}
+
+//获取跟踪的发送地址,调用方需持有锁
+func (tracker *NonceTracker) sender(address common.Address) *senderNonces {
+	sender := tracker.senders[address]
+	if sender == nil {
+		sender = &senderNonces{sent: make(map[uint64]*sentTx)}
+		tracker.senders[address] = sender
+	}
+
+	return sender
+}
+
+//处理扫描到的tx,发送地址跟踪的相同nonce的tx hash不同时回调NonceReplaced
+func (tracker *NonceTracker) OnTx(tx *TxInfo) error {
+	tracker.lock.Lock()
+	sender := tracker.senders[common.HexToAddress(tx.From)]
+	if sender == nil {
+		tracker.lock.Unlock()
+		return nil
+	}
+	sent := sender.sent[tx.Nonce]
+	delete(sender.sent, tx.Nonce)
+	tracker.lock.Unlock()
+
+	if sent == nil || sent.hash == common.HexToHash(tx.TxHash) {
+		return nil
+	}
+	alert := &NonceAlert{
+		Kind:       NonceReplaced,
+		Address:    tx.From,
+		Nonce:      tx.Nonce,
+		TxHash:     hashString(sent.hash),
+		ReplacedBy: tx.TxHash,
+	}
+	if tx.BlockNumber != nil {
+		alert.BlockNumber = tx.BlockNumber.Uint64()
+	}
+
+	return tracker.onAlert(alert)
+}
+
+//距上次检查是否已超过检查间隔
+func (tracker *NonceTracker) checkDue() bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	return time.Since(tracker.lastCheck) >= tracker.checkInterval
+}
+
+//检查所有跟踪地址的链上nonce、交易池nonce和跟踪的tx状态,回调告警,回调返回错误时中止检查
+func (tracker *NonceTracker) Check(client *ethclient.Client) error {
+	tracker.lock.Lock()
+	tracker.lastCheck = time.Now()
+	addresses := make([]common.Address, 0, len(tracker.senders))
+	for address := range tracker.senders {
+		addresses = append(addresses, address)
+	}
+	tracker.lock.Unlock()
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].Cmp(addresses[j]) < 0
+	})
+
+	for _, address := range addresses {
+		err := tracker.checkSender(client, address)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tracker *NonceTracker) checkSender(client *ethclient.Client, address common.Address) error {
+	nonce, err := client.NonceAt(context.Background(), address, nil)
+	if err != nil {
+		return err
+	}
+	pendingNonce, err := client.PendingNonceAt(context.Background(), address)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tracker.lock.Lock()
+	sender := tracker.senders[address]
+	if sender == nil {
+		tracker.lock.Unlock()
+		return nil
+	}
+	if nonce != sender.nonce || pendingNonce <= nonce || sender.pendingSince.IsZero() {
+		sender.nonce = nonce
+		sender.pendingSince = now
+		sender.longPendingReported = false
+	}
+	nonces := make([]uint64, 0, len(sender.sent))
+	sents := make(map[uint64]*sentTx, len(sender.sent))
+	for n, sent := range sender.sent {
+		nonces = append(nonces, n)
+		sents[n] = sent
+	}
+	pendingSince := sender.pendingSince
+	longPendingReported := sender.longPendingReported
+	tracker.lock.Unlock()
+	sort.Slice(nonces, func(i, j int) bool {
+		return nonces[i] < nonces[j]
+	})
+
+	gapReported := false
+	for _, n := range nonces {
+		sent := sents[n]
+		_, isPending, err := client.TransactionByHash(context.Background(), sent.hash)
+		notFound := errors.Is(err, ethereum.NotFound)
+		if err != nil && !notFound {
+			return err
+		}
+
+		alert := &NonceAlert{Address: addressString(address), Nonce: n, TxHash: hashString(sent.hash)}
+		resolved := false
+		switch {
+		case !notFound && !isPending:
+			//已打包
+			resolved = true
+		case notFound && n < pendingNonce:
+			//链上或交易池中该nonce已被其它tx占用
+			alert.Kind = NonceReplaced
+			resolved = true
+		case notFound:
+			alert.Kind = NonceDropped
+		case n > pendingNonce && !gapReported:
+			//tx在交易池的queued队列中,等待缺失的nonce
+			alert.Kind = NonceGap
+			alert.MissingFrom = pendingNonce
+			gapReported = true
+		}
+		if alert.Kind != 0 && !sent.reported[alert.Kind] {
+			err = tracker.onAlert(alert)
+			if err != nil {
+				return err
+			}
+		}
+
+		tracker.lock.Lock()
+		if alert.Kind != 0 {
+			sent.reported[alert.Kind] = true
+		}
+		if resolved && sender.sent[n] == sent {
+			delete(sender.sent, n)
+		}
+		//链上nonce对应的tx发出时间早于开始等待的时间时,从发出时开始计算等待时间
+		if n == nonce && sent.sentAt.Before(pendingSince) {
+			pendingSince = sent.sentAt
+			if sender.nonce == nonce {
+				sender.pendingSince = pendingSince
+			}
+		}
+		tracker.lock.Unlock()
+	}
+
+	if pendingNonce > nonce && !longPendingReported && now.Sub(pendingSince) >= tracker.pendingTimeout {
+		alert := &NonceAlert{Kind: NonceLongPending, Address: addressString(address), Nonce: nonce, PendingSince: pendingSince}
+		if sent := sents[nonce]; sent != nil {
+			alert.TxHash = hashString(sent.hash)
+		}
+		err = tracker.onAlert(alert)
+		if err != nil {
+			return err
+		}
+		tracker.lock.Lock()
+		sender.longPendingReported = true
+		tracker.lock.Unlock()
+	}
+
+	return nil
+}
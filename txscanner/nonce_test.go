@@ -0,0 +1,191 @@
+package txscanner_test
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+type alertRecorder struct {
+	lock   sync.Mutex
+	alerts []*txscanner.NonceAlert
+}
+
+func (recorder *alertRecorder) onAlert(alert *txscanner.NonceAlert) error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.alerts = append(recorder.alerts, alert)
+	return nil
+}
+
+func (recorder *alertRecorder) take() []*txscanner.NonceAlert {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	alerts := recorder.alerts
+	recorder.alerts = nil
+	return alerts
+}
+
+func TestScanTxNonceTracker(t *testing.T) {
+	hotWallet := hexAddress(fakechain.Address(1))
+	chain := fakechain.NewChain(1)
+	mined := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+	pending := chain.Transfer(1, depositAddr, big.NewInt(2))
+
+	alerts := &alertRecorder{}
+	tracker := txscanner.NewNonceTracker(0, 0, alerts.onAlert)
+	//发出的nonce 0的tx被其它tx替换
+	tracker.TrackSent(hotWallet, 0, common.HexToHash("0x01").Hex())
+	tracker.TrackSent(hotWallet, 1, hexHash(pending))
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedFrom(hotWallet)
+	watcher.SetNonceTracker(tracker)
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertHashes(t, recorder.hashes(), mined)
+	got := alerts.take()
+	if len(got) != 2 {
+		t.Fatalf("got %d alerts", len(got))
+	}
+	if got[0].Kind != txscanner.NonceReplaced || got[0].Nonce != 0 || got[0].ReplacedBy != hexHash(mined) || got[0].BlockNumber != 1 {
+		t.Fatalf("unexpected alert %+v", got[0])
+	}
+	//nonce 1在交易池中等待,pendingTimeout为0时立即告警
+	if got[1].Kind != txscanner.NonceLongPending || got[1].Nonce != 1 || got[1].TxHash != hexHash(pending) || got[1].Address != hotWallet {
+		t.Fatalf("unexpected alert %+v", got[1])
+	}
+}
+
+func TestScanTxNonceAlertErrorKeepsScanning(t *testing.T) {
+	hotWallet := hexAddress(fakechain.Address(1))
+	chain := fakechain.NewChain(1)
+	mined := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+
+	alerts := 0
+	tracker := txscanner.NewNonceTracker(time.Hour, time.Hour, func(alert *txscanner.NonceAlert) error {
+		alerts++
+		return errors.New("alert failed")
+	})
+	tracker.TrackSent(hotWallet, 0, common.HexToHash("0x01").Hex())
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedFrom(hotWallet)
+	watcher.SetNonceTracker(tracker)
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//告警出错不重新扫描区块
+	assertHashes(t, recorder.hashes(), mined)
+	if alerts != 1 {
+		t.Fatalf("got %d alerts", alerts)
+	}
+}
+
+func TestNonceTrackerCheckPool(t *testing.T) {
+	sender := hexAddress(fakechain.Address(2))
+	chain := fakechain.NewChain(1)
+	first := chain.Transfer(2, depositAddr, big.NewInt(1))
+	dropped := chain.Transfer(2, depositAddr, big.NewInt(2))
+	queued := chain.Transfer(2, depositAddr, big.NewInt(3))
+	chain.DropTx(dropped.Hash())
+
+	alerts := &alertRecorder{}
+	tracker := txscanner.NewNonceTracker(time.Minute, time.Hour, alerts.onAlert)
+	for _, tx := range []*types.Transaction{first, dropped, queued} {
+		tracker.TrackSent(sender, tx.Nonce(), tx.Hash().Hex())
+	}
+	watcher, _ := newWatcher(t, chain, 1, &txRecorder{})
+	clients, err := watcher.GetEthClients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clients[0].Close()
+
+	err = tracker.Check(clients[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := alerts.take()
+	if len(got) != 2 || got[0].Kind != txscanner.NonceDropped || got[0].Nonce != 1 ||
+		got[1].Kind != txscanner.NonceGap || got[1].Nonce != 2 || got[1].MissingFrom != 1 || got[1].TxHash != hexHash(queued) {
+		t.Fatalf("unexpected alerts %+v", got)
+	}
+
+	//再次检查不重复告警
+	err = tracker.Check(clients[0])
+	if err != nil || len(alerts.take()) != 0 {
+		t.Fatalf("repeated alerts, err %v", err)
+	}
+
+	//nonce 1已被链上其它tx占用
+	chain.Mine()
+	err = tracker.Check(clients[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = alerts.take()
+	if len(got) != 1 || got[0].Kind != txscanner.NonceReplaced || got[0].Nonce != 1 || got[0].ReplacedBy != "" {
+		t.Fatalf("unexpected alerts %+v", got)
+	}
+}
+
+func TestNonceTrackerKeepsPendingSince(t *testing.T) {
+	sender := hexAddress(fakechain.Address(3))
+	chain := fakechain.NewChain(1)
+	chain.Transfer(3, depositAddr, big.NewInt(1))
+	watcher, _ := newWatcher(t, chain, 1, &txRecorder{})
+	clients, err := watcher.GetEthClients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clients[0].Close()
+
+	//onAlert为nil时忽略告警
+	err = txscanner.NewNonceTracker(0, 0, nil).Check(clients[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alerts := &alertRecorder{}
+	tracker := txscanner.NewNonceTracker(0, 300*time.Millisecond, alerts.onAlert)
+	//跟踪的nonce 0的tx被交易池中的其它tx替换
+	tracker.TrackSent(sender, 0, common.HexToHash("0x02").Hex())
+	sentAt := time.Now()
+	time.Sleep(200 * time.Millisecond)
+	err = tracker.Check(clients[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := alerts.take()
+	if len(got) != 1 || got[0].Kind != txscanner.NonceReplaced {
+		t.Fatalf("unexpected alerts %+v", got)
+	}
+
+	//替换的tx不再跟踪后,等待时间仍从跟踪的tx发出时开始计算
+	time.Sleep(200 * time.Millisecond)
+	err = tracker.Check(clients[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = alerts.take()
+	if len(got) != 1 || got[0].Kind != txscanner.NonceLongPending || got[0].Nonce != 0 || got[0].PendingSince.After(sentAt) {
+		t.Fatalf("unexpected alerts %+v", got)
+	}
+}
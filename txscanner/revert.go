@@ -0,0 +1,179 @@
+package txscanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+//revert原因的获取方式
+type RevertReasonMode int
+
+const (
+	//不获取
+	RevertReasonNone RevertReasonMode = iota
+	//在父区块上通过eth_call重放交易,区块内之前的交易未执行,结果可能与链上不一致
+	RevertReasonCall
+	//通过debug_traceTransaction(callTracer)获取,需节点开启debug命名空间
+	RevertReasonTrace
+)
+
+var (
+	//Error(string)的方法id
+	errorSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+	//Panic(uint256)的方法id
+	panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+)
+
+//交易revert原因
+type RevertReason struct {
+	//revert数据,out of gas等无revert数据时为空
+	Data []byte
+	//错误名:Error、Panic或自定义错误名,无法解码时为空
+	Error string
+	//Error(string)的消息,Panic的说明,自定义错误的签名及参数
+	Message string
+	//Panic的错误码,非Panic时为nil
+	PanicCode *big.Int
+	//自定义错误的参数
+	Args []interface{}
+}
+
+//获取失败交易revert原因的watcher
+type RevertTxWatcher interface {
+	TxWatcher
+
+	//获取revert原因的获取方式
+	GetRevertReasonMode() RevertReasonMode
+
+	//获取用于解码自定义错误的abi,可为空
+	GetErrorABIs() []*abi.ABI
+}
+
+//解码revert数据,依次尝试Error(string)、Panic(uint256)和errorABIs中的自定义错误
+func DecodeRevert(data []byte, errorABIs ...*abi.ABI) *RevertReason {
+	reason := &RevertReason{Data: data}
+	if len(data) < 4 {
+		return reason
+	}
+
+	switch {
+	case bytes.Equal(data[:4], errorSelector):
+		message, err := abi.UnpackRevert(data)
+		if err == nil {
+			reason.Error = "Error"
+			reason.Message = message
+		}
+	case bytes.Equal(data[:4], panicSelector):
+		message, err := abi.UnpackRevert(data)
+		if err == nil && len(data) >= 36 {
+			reason.Error = "Panic"
+			reason.Message = message
+			reason.PanicCode = new(big.Int).SetBytes(data[4:36])
+		}
+	default:
+		var id [4]byte
+		copy(id[:], data[:4])
+		for _, errorABI := range errorABIs {
+			abiError, err := errorABI.ErrorByID(id)
+			if err != nil {
+				continue
+			}
+			unpacked, err := abiError.Unpack(data)
+			if err != nil {
+				continue
+			}
+			args, _ := unpacked.([]interface{})
+			reason.Error = abiError.Name
+			reason.Args = args
+			reason.Message = formatError(abiError, args)
+			break
+		}
+	}
+
+	return reason
+}
+
+//格式化自定义错误,如InsufficientBalance(100, 200)
+func formatError(abiError *abi.Error, args []interface{}) string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = fmt.Sprint(arg)
+	}
+
+	return abiError.Name + "(" + strings.Join(values, ", ") + ")"
+}
+
+//获取失败交易的revert原因
+func GetRevertReason(client *ethclient.Client, txInfo *TxInfo, mode RevertReasonMode, errorABIs ...*abi.ABI) (*RevertReason, error) {
+	var data []byte
+	var err error
+	switch mode {
+	case RevertReasonCall:
+		data, err = callRevertData(client, txInfo)
+	case RevertReasonTrace:
+		data, err = traceRevertData(client, txInfo)
+	default:
+		return nil, errors.New("unknown revert reason mode")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeRevert(data, errorABIs...), nil
+}
+
+//在父区块上通过eth_call重放交易,从返回的错误中获取revert数据
+func callRevertData(client *ethclient.Client, txInfo *TxInfo) ([]byte, error) {
+	msg := ethereum.CallMsg{
+		From:  common.HexToAddress(txInfo.From),
+		Gas:   txInfo.Gas,
+		Value: txInfo.Value,
+		Data:  txInfo.Input(),
+	}
+	if txInfo.To != "" {
+		to := common.HexToAddress(txInfo.To)
+		msg.To = &to
+	}
+	parentBlock := new(big.Int).Sub(txInfo.BlockNumber, big.NewInt(1))
+	_, err := client.CallContract(context.Background(), msg, parentBlock)
+	if err == nil {
+		//重放成功,失败原因依赖区块内之前的交易
+		return nil, nil
+	}
+
+	var dataErr rpc.DataError
+	if !errors.As(err, &dataErr) {
+		return nil, err
+	}
+	hexData, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return nil, nil
+	}
+
+	return hexutil.Decode(hexData)
+}
+
+//通过debug_traceTransaction(callTracer)获取顶层调用的revert数据
+func traceRevertData(client *ethclient.Client, txInfo *TxInfo) ([]byte, error) {
+	var result struct {
+		Output hexutil.Bytes `json:"output"`
+		Error  string        `json:"error"`
+	}
+	err := client.Client().CallContext(context.Background(), &result, "debug_traceTransaction", common.HexToHash(txInfo.TxHash), map[string]string{"tracer": "callTracer"})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Output, nil
+}
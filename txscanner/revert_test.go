@@ -0,0 +1,99 @@
+package txscanner_test
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+const errorABIJSON = `[{"type":"error","name":"InsufficientBalance","inputs":[{"name":"available","type":"uint256"},{"name":"required","type":"uint256"}]}]`
+
+//按selector和参数类型构造revert数据
+func packRevert(t *testing.T, selector string, typ string, value interface{}) []byte {
+	t.Helper()
+	abiType, err := abi.NewType(typ, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := abi.Arguments{{Type: abiType}}.Pack(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(hexutil.MustDecode(selector), packed...)
+}
+
+func TestDecodeRevert(t *testing.T) {
+	errorABI, err := abi.JSON(strings.NewReader(errorABIJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reason := txscanner.DecodeRevert(packRevert(t, "0x08c379a0", "string", "not allowed"), &errorABI)
+	if reason.Error != "Error" || reason.Message != "not allowed" {
+		t.Fatalf("error reason %+v", reason)
+	}
+
+	reason = txscanner.DecodeRevert(packRevert(t, "0x4e487b71", "uint256", big.NewInt(0x11)), &errorABI)
+	if reason.Error != "Panic" || reason.PanicCode.Int64() != 0x11 || reason.Message != "arithmetic underflow or overflow" {
+		t.Fatalf("panic reason %+v", reason)
+	}
+
+	custom := errorABI.Errors["InsufficientBalance"]
+	packed, err := custom.Inputs.Pack(big.NewInt(1), big.NewInt(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reason = txscanner.DecodeRevert(append(custom.ID[:4:4], packed...), &errorABI)
+	if reason.Error != "InsufficientBalance" || reason.Message != "InsufficientBalance(1, 2)" || len(reason.Args) != 2 {
+		t.Fatalf("custom reason %+v", reason)
+	}
+
+	//未提供abi的自定义错误只保留数据
+	reason = txscanner.DecodeRevert(append(custom.ID[:4:4], packed...))
+	if reason.Error != "" || len(reason.Data) != 68 {
+		t.Fatalf("unknown reason %+v", reason)
+	}
+}
+
+func TestScanTxRevertReasons(t *testing.T) {
+	for _, mode := range []txscanner.RevertReasonMode{txscanner.RevertReasonCall, txscanner.RevertReasonTrace} {
+		chain := fakechain.NewChain(1)
+		data := packRevert(t, "0x08c379a0", "string", "insufficient allowance")
+		reverted := chain.AddRevertedTx(1, &tokenAddr, nil, hexutil.MustDecode("0xa9059cbb"), data)
+		outOfGas := chain.AddFailedTx(2, &tokenAddr, nil, nil)
+		succeeded := chain.AddTx(3, &tokenAddr, nil, nil)
+		chain.Mine()
+
+		recorder := &txRecorder{}
+		watcher, _ := newWatcher(t, chain, 1, recorder)
+		watcher.AddInterestedTo(hexAddress(tokenAddr))
+		watcher.SetRevertReasons(mode)
+		err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertHashes(t, recorder.hashes(), reverted, outOfGas, succeeded)
+		reason := recorder.txs[0].RevertReason
+		if reason == nil || reason.Error != "Error" || reason.Message != "insufficient allowance" {
+			t.Fatalf("mode %d: revert reason %+v", mode, reason)
+		}
+		if recorder.txs[1].RevertReason == nil || len(recorder.txs[1].RevertReason.Data) != 0 || recorder.txs[2].RevertReason != nil {
+			t.Fatalf("mode %d: unexpected reasons %+v %+v", mode, recorder.txs[1].RevertReason, recorder.txs[2].RevertReason)
+		}
+
+		decoded := &txscanner.TxInfo{}
+		err = decoded.UnmarshalJSON([]byte(recorder.txs[0].JSON()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.RevertReason == nil || decoded.RevertReason.Message != "insufficient allowance" {
+			t.Fatalf("decoded revert reason %+v", decoded.RevertReason)
+		}
+	}
+}
@@ -1,54 +1,666 @@
 package txscanner
 
 import (
-	"encoding/base64"
+	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/warrior21st/ethblockscanner/addrset"
+	"github.com/warrior21st/ethblockscanner/filewatch"
+	"github.com/warrior21st/ethblockscanner/ratelimit"
+	"github.com/warrior21st/ethblockscanner/rpcauth"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
+	"github.com/warrior21st/ethblockscanner/rpcreplay"
 )
 
 //简单交易管理结构
 type SimpleTxWatcher struct {
-	endpoints       []string
+	endpoints       []*rpcnode.Endpoint
+	rateLimitPool   *ratelimit.Pool
+	endpointAuths   []*rpcauth.Auth
 	infuraSecrets   []string
 	scanStartBlock  uint64
-	interestedFroms map[string]interface{}
-	interestedTos   map[string]interface{}
+	interestedFroms addrset.AddressSet
+	interestedTos   addrset.AddressSet
 	scanInterval    time.Duration
 	callback        func(*TxInfo) error
+
+	updateMaxScanedBlock func(uint64) error
+	onBlock              func(*BlockInfo) error
+	profile              *ChainProfile
+	nodeDetection        bool
+	txRule               TxRule
+	logMatching          bool
+	revertReasonMode     RevertReasonMode
+	errorABIs            []*abi.ABI
+	onBalanceChange      func(*BalanceChange) error
+	onWithdrawal         func(*WithdrawalInfo) error
+	nonceTracker         *NonceTracker
+	gasAnalytics         *GasAnalytics
+	enricher             *Enricher
+	bloomSkipping        bool
+
+	nodeLock         sync.Mutex
+	nodeInfos        []*rpcnode.NodeInfo
+	endpointsVersion uint64
+	recorders        *rpcreplay.RecorderSet
+
+	lock             sync.RWMutex
+	backfillRequests []*BackfillRequest
+	claimedBackfills []*BackfillRequest
+	//回溯请求尚未被实时扫描取出的新增地址,取出前不参与匹配
+	unclaimedFroms   map[common.Address]bool
+	unclaimedTos     map[common.Address]bool
+	fileFroms        map[common.Address]bool
+	fileTos          map[common.Address]bool
+	balanceAddresses []common.Address
+}
+
+//关注地址配置文件结构
+type InterestedConfig struct {
+	Froms []string `json:"froms"`
+	Tos   []string `json:"tos"`
+
+	//新增地址从该区块开始回溯扫描,为0则不回溯
+	BackfillFromBlock uint64 `json:"backfillFromBlock"`
 }
 
 //构造一个新的简单tx管理结构(默认3秒钟扫描一次)
 func NewSimpleTxWatcher(endpoints []string, scanStartBlock uint64, scanInterval time.Duration, callback func(*TxInfo) error) *SimpleTxWatcher {
 
 	return &SimpleTxWatcher{
-		endpoints:      endpoints,
-		scanStartBlock: scanStartBlock,
-		scanInterval:   scanInterval,
-		callback:       callback,
+		endpoints:       rpcnode.ParseEndpoints(endpoints, 0),
+		scanStartBlock:  scanStartBlock,
+		scanInterval:    scanInterval,
+		callback:        callback,
+		interestedFroms: addrset.NewMemorySet(),
+		interestedTos:   addrset.NewMemorySet(),
+	}
+}
+
+//设置关注的from地址集合(如redis/sql集合),替换当前集合
+func (watcher *SimpleTxWatcher) SetInterestedFromSet(set addrset.AddressSet) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	watcher.interestedFroms = set
+	watcher.fileFroms = nil
+}
+
+//设置关注的to地址集合(如redis/sql集合),替换当前集合
+func (watcher *SimpleTxWatcher) SetInterestedToSet(set addrset.AddressSet) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	watcher.interestedTos = set
+	watcher.fileTos = nil
+}
+
+//设置扫描进度确认回调,可用于持久化扫描进度,返回错误时扫描进度回退
+func (watcher *SimpleTxWatcher) SetUpdateMaxScanedBlock(callback func(uint64) error) {
+	watcher.updateMaxScanedBlock = callback
+}
+
+func (watcher *SimpleTxWatcher) UpdateMaxScanedBlock(blockNumber uint64) error {
+	if watcher.updateMaxScanedBlock != nil {
+		return watcher.updateMaxScanedBlock(blockNumber)
+	}
+
+	return nil
+}
+
+//设置区块扫描完成回调,区块内所有tx回调成功后调用
+func (watcher *SimpleTxWatcher) SetOnBlock(onBlock func(*BlockInfo) error) {
+	watcher.onBlock = onBlock
+}
+
+func (watcher *SimpleTxWatcher) GetOnBlock() func(*BlockInfo) error {
+	return watcher.onBlock
+}
+
+//设置交易过滤规则,设置后替换关注地址匹配,可通过InterestedRule(watcher)组合关注地址,如
+//And(InterestedRule(watcher), MethodIs("0xa9059cbb"), Succeeded())
+func (watcher *SimpleTxWatcher) SetTxRule(rule TxRule) {
+	watcher.txRule = rule
+}
+
+func (watcher *SimpleTxWatcher) GetTxRule() TxRule {
+	return watcher.txRule
+}
+
+//开启按receipt log匹配,log的indexed topic中包含关注的from/to地址时匹配该交易(如代币转入关注地址),
+//每个区块额外请求一次eth_getLogs,节点支持eth_getBlockReceipts时改为获取区块receipt并复用于匹配的交易
+func (watcher *SimpleTxWatcher) SetLogMatching(enabled bool) {
+	watcher.logMatching = enabled
+}
+
+func (watcher *SimpleTxWatcher) GetLogAddressMatcher() func(addr common.Address) (bool, error) {
+	if !watcher.logMatching {
+		return nil
+	}
+
+	return watcher.isInterestedAddress
+}
+
+//获取log匹配的关注地址(from和to地址),未开启按log匹配、地址集合不可枚举(如redis/sql集合)或地址数超过limit时返回false
+func (watcher *SimpleTxWatcher) GetLogAddresses(limit int) ([]common.Address, bool) {
+	if !watcher.logMatching {
+		return nil, false
+	}
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	froms, ok := watcher.interestedFroms.(addrset.EnumerableSet)
+	if !ok {
+		return nil, false
+	}
+	tos, ok := watcher.interestedTos.(addrset.EnumerableSet)
+	if !ok || froms.Len()+tos.Len() > limit {
+		return nil, false
+	}
+	addrs := make([]common.Address, 0, froms.Len()+tos.Len())
+	collect := func(addr common.Address) bool {
+		addrs = append(addrs, addr)
+		return true
+	}
+	froms.Range(collect)
+	tos.Range(collect)
+
+	return addrs, true
+}
+
+//地址是否在关注的from或to地址中
+func (watcher *SimpleTxWatcher) isInterestedAddress(addr common.Address) (bool, error) {
+	return watcher.IsInterestedTxAddress(addr, addr)
+}
+
+//开启失败交易revert原因的获取,errorABIs用于解码合约的自定义错误
+func (watcher *SimpleTxWatcher) SetRevertReasons(mode RevertReasonMode, errorABIs ...*abi.ABI) {
+	watcher.revertReasonMode = mode
+	watcher.errorABIs = errorABIs
+}
+
+func (watcher *SimpleTxWatcher) GetRevertReasonMode() RevertReasonMode {
+	return watcher.revertReasonMode
+}
+
+func (watcher *SimpleTxWatcher) GetErrorABIs() []*abi.ABI {
+	return watcher.errorABIs
+}
+
+//添加跟踪余额的地址,每个区块获取其余额并回调变化(需设置SetOnBalanceChange)
+func (watcher *SimpleTxWatcher) AddBalanceAddress(addr string) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	address := common.HexToAddress(addr)
+	for _, balanceAddress := range watcher.balanceAddresses {
+		if balanceAddress == address {
+			return
+		}
+	}
+	watcher.balanceAddresses = append(watcher.balanceAddresses, address)
+}
+
+//移除跟踪余额的地址
+func (watcher *SimpleTxWatcher) RemoveBalanceAddress(addr string) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	address := common.HexToAddress(addr)
+	for i, balanceAddress := range watcher.balanceAddresses {
+		if balanceAddress == address {
+			watcher.balanceAddresses = append(watcher.balanceAddresses[:i:i], watcher.balanceAddresses[i+1:]...)
+			return
+		}
+	}
+}
+
+//设置余额变化回调,返回错误时重新扫描该区块
+func (watcher *SimpleTxWatcher) SetOnBalanceChange(onBalanceChange func(*BalanceChange) error) {
+	watcher.onBalanceChange = onBalanceChange
+}
+
+func (watcher *SimpleTxWatcher) GetBalanceAddresses() []common.Address {
+	if watcher.onBalanceChange == nil {
+		return nil
+	}
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	return append([]common.Address(nil), watcher.balanceAddresses...)
+}
+
+func (watcher *SimpleTxWatcher) OnBalanceChange(change *BalanceChange) error {
+	if watcher.onBalanceChange != nil {
+		return watcher.onBalanceChange(change)
+	}
+
+	return nil
+}
+
+//设置nonce跟踪器,扫描到的tx交给跟踪器检测nonce替换,扫描器按跟踪器的检查间隔检查交易池
+func (watcher *SimpleTxWatcher) SetNonceTracker(tracker *NonceTracker) {
+	watcher.nonceTracker = tracker
+}
+
+func (watcher *SimpleTxWatcher) GetNonceTracker() *NonceTracker {
+	return watcher.nonceTracker
+}
+
+//设置区块gas统计,扫描的每个区块在回调完成后加入统计,开启后不再使用bloom预检查跳过区块
+func (watcher *SimpleTxWatcher) SetGasAnalytics(analytics *GasAnalytics) {
+	watcher.gasAnalytics = analytics
+}
+
+func (watcher *SimpleTxWatcher) GetGasAnalytics() *GasAnalytics {
+	return watcher.gasAnalytics
+}
+
+//设置交易标注,tx回调前标注from、to及log合约地址的标签和合约/EOA分类
+func (watcher *SimpleTxWatcher) SetEnricher(enricher *Enricher) {
+	watcher.enricher = enricher
+}
+
+func (watcher *SimpleTxWatcher) GetEnricher() *Enricher {
+	return watcher.enricher
+}
+
+//设置提款回调,关注的from或to地址收到信标链提款时回调,返回错误时重新扫描该区块
+func (watcher *SimpleTxWatcher) SetOnWithdrawal(onWithdrawal func(*WithdrawalInfo) error) {
+	watcher.onWithdrawal = onWithdrawal
+}
+
+func (watcher *SimpleTxWatcher) GetWithdrawalMatcher() func(address common.Address) (bool, error) {
+	if watcher.onWithdrawal == nil {
+		return nil
+	}
+
+	return watcher.isInterestedAddress
+}
+
+func (watcher *SimpleTxWatcher) OnWithdrawal(withdrawal *WithdrawalInfo) error {
+	if watcher.onWithdrawal != nil {
+		return watcher.onWithdrawal(withdrawal)
+	}
+
+	return nil
+}
+
+//设置链配置(如L2/侧链),未设置时根据链id选择已知配置
+func (watcher *SimpleTxWatcher) SetChainProfile(profile *ChainProfile) {
+	watcher.profile = profile
+}
+
+func (watcher *SimpleTxWatcher) GetChainProfile() *ChainProfile {
+	return watcher.profile
+}
+
+//设置节点限流池,节点被限流或额度用尽时请求转到池中的其他节点
+func (watcher *SimpleTxWatcher) SetRateLimitPool(pool *ratelimit.Pool) {
+	watcher.rateLimitPool = pool
+}
+
+//设置节点(http/ws/ipc及超时),替换构造时传入的节点地址
+func (watcher *SimpleTxWatcher) SetEndpoints(endpoints []*rpcnode.Endpoint) {
+	watcher.nodeLock.Lock()
+	defer watcher.nodeLock.Unlock()
+
+	watcher.endpoints = endpoints
+	watcher.nodeInfos = nil
+	watcher.endpointsVersion++
+	if watcher.recorders != nil {
+		watcher.recorders.Close()
+	}
+}
+
+//设置录制文件,设置后各节点的请求经进程内的录制器转发,每次调用的响应写入录制文件,可用rpcreplay.NewReplayer回放;
+//为nil时停止录制,录制文件由调用方关闭
+func (watcher *SimpleTxWatcher) SetRecordArchive(archive *rpcreplay.Archive) {
+	watcher.nodeLock.Lock()
+	defer watcher.nodeLock.Unlock()
+
+	if watcher.recorders != nil {
+		watcher.recorders.Close()
+		watcher.recorders = nil
+	}
+	if archive != nil {
+		watcher.recorders = rpcreplay.NewRecorderSet(archive)
+	}
+}
+
+//连接第i个节点,设置了录制文件时返回录制客户端
+func (watcher *SimpleTxWatcher) dial(endpoint *rpcnode.Endpoint, i int) (*rpc.Client, error) {
+	watcher.nodeLock.Lock()
+	recorders := watcher.recorders
+	watcher.nodeLock.Unlock()
+
+	if recorders != nil {
+		return recorders.Dial(endpoint, watcher.endpointAuth(i), watcher.rateLimitPool)
+	}
+
+	return rpcnode.Dial(endpoint, watcher.endpointAuth(i), watcher.rateLimitPool)
+}
+
+func (watcher *SimpleTxWatcher) getEndpoints() []*rpcnode.Endpoint {
+	watcher.nodeLock.Lock()
+	defer watcher.nodeLock.Unlock()
+
+	return watcher.endpoints
+}
+
+//开启节点探测,扫描开始时通过web3_clientVersion等探测各节点类型及支持的方法,
+//启用节点特有的优化(如eth_getBlockReceipts);探测会向每个节点发送数个请求,默认关闭
+func (watcher *SimpleTxWatcher) SetNodeDetection(enabled bool) {
+	watcher.nodeDetection = enabled
+}
+
+//获取各节点的探测结果,首次调用时探测;未开启探测时返回nil,探测失败的节点为nil,下次调用时重新探测
+func (watcher *SimpleTxWatcher) GetNodeInfos() []*rpcnode.NodeInfo {
+	if !watcher.nodeDetection {
+		return nil
+	}
+	watcher.nodeLock.Lock()
+	endpoints := watcher.endpoints
+	version := watcher.endpointsVersion
+	if watcher.nodeInfos == nil {
+		watcher.nodeInfos = make([]*rpcnode.NodeInfo, len(endpoints))
+	}
+	var pending []int
+	for i, info := range watcher.nodeInfos {
+		if info == nil {
+			pending = append(pending, i)
+		}
 	}
+	watcher.nodeLock.Unlock()
+
+	//探测时不持有锁,避免阻塞GetEthClients
+	detected := make(map[int]*rpcnode.NodeInfo)
+	for _, i := range pending {
+		rpcClient, err := watcher.dial(endpoints[i], i)
+		if err != nil {
+			LogToConsole("detect client_" + strconv.Itoa(i) + " error: " + err.Error())
+			continue
+		}
+		info, err := rpcnode.Detect(rpcClient)
+		rpcClient.Close()
+		if err != nil {
+			LogToConsole("detect client_" + strconv.Itoa(i) + " error: " + err.Error())
+			continue
+		}
+		LogToConsole(fmt.Sprintf("client_%d is %s(%s),blockReceipts:%t,trace:%t", i, info.Client, info.ClientVersion, info.BlockReceipts, info.Trace))
+		detected[i] = info
+	}
+
+	watcher.nodeLock.Lock()
+	defer watcher.nodeLock.Unlock()
+
+	//探测期间更换了节点时丢弃探测结果
+	if version == watcher.endpointsVersion && watcher.nodeInfos != nil {
+		for i, info := range detected {
+			watcher.nodeInfos[i] = info
+		}
+	}
+	infos := make([]*rpcnode.NodeInfo, len(watcher.nodeInfos))
+	copy(infos, watcher.nodeInfos)
+
+	return infos
 }
 
+//设置Infura project secret,按下标对应节点
 func (watcher *SimpleTxWatcher) SetInfuraSecrets(secrets []string) {
 	watcher.infuraSecrets = secrets
 }
 
-//添加关注的from address
-func (watcher *SimpleTxWatcher) AddInterestedFrom(from string) {
-	if watcher.interestedFroms == nil {
-		watcher.interestedFroms = make(map[string]interface{})
+//设置节点认证(请求头/Basic/Bearer/jwt/mTLS),按下标对应节点,为nil的节点使用Infura secret或不认证
+func (watcher *SimpleTxWatcher) SetEndpointAuths(auths []*rpcauth.Auth) {
+	watcher.endpointAuths = auths
+}
+
+func (watcher *SimpleTxWatcher) endpointAuth(i int) *rpcauth.Auth {
+	if i < len(watcher.endpointAuths) && watcher.endpointAuths[i] != nil {
+		return watcher.endpointAuths[i]
 	}
-	watcher.interestedFroms[strings.ToLower(from)] = true
+	if i < len(watcher.infuraSecrets) && strings.Trim(watcher.infuraSecrets[i], " ") != "" {
+		return rpcauth.InfuraAuth(watcher.infuraSecrets[i])
+	}
+
+	return nil
+}
+
+//开启区块bloom预检查,交易规则只匹配产生特定log的交易(如LogEmitted)时,跳过bloom中不包含该log的区块;
+//按from/to地址匹配时不跳过任何区块
+func (watcher *SimpleTxWatcher) SetBloomSkipping(enabled bool) {
+	watcher.bloomSkipping = enabled
+}
+
+func (watcher *SimpleTxWatcher) BloomSkipping() bool {
+	return watcher.bloomSkipping
+}
+
+//添加关注的from address
+func (watcher *SimpleTxWatcher) AddInterestedFrom(from string) error {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	return watcher.interestedFroms.Add(common.HexToAddress(from))
 }
 
 //添加关注的to address
-func (watcher *SimpleTxWatcher) AddInterestedTo(to string) {
-	if watcher.interestedTos == nil {
-		watcher.interestedTos = make(map[string]interface{})
+func (watcher *SimpleTxWatcher) AddInterestedTo(to string) error {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	return watcher.interestedTos.Add(common.HexToAddress(to))
+}
+
+//添加关注的from address,并从fromBlock开始回溯扫描该地址;
+//新增的地址在回溯请求被实时扫描取出后才参与匹配,回溯扫描到取出前的区块为止,同一区块不会重复回调
+func (watcher *SimpleTxWatcher) AddInterestedFromWithBackfill(from string, fromBlock uint64) error {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	return watcher.addWithBackfill(watcher.interestedFroms, common.HexToAddress(from), &BackfillRequest{From: strings.ToLower(from), FromBlock: fromBlock})
+}
+
+//添加关注的to address,并从fromBlock开始回溯扫描该地址;
+//新增的地址在回溯请求被实时扫描取出后才参与匹配,回溯扫描到取出前的区块为止,同一区块不会重复回调
+func (watcher *SimpleTxWatcher) AddInterestedToWithBackfill(to string, fromBlock uint64) error {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	return watcher.addWithBackfill(watcher.interestedTos, common.HexToAddress(to), &BackfillRequest{To: strings.ToLower(to), FromBlock: fromBlock})
+}
+
+//添加地址并加入回溯请求,调用方需持有锁;集合中已有的地址继续参与匹配
+func (watcher *SimpleTxWatcher) addWithBackfill(set addrset.AddressSet, addr common.Address, request *BackfillRequest) error {
+	b, err := set.Contains(addr)
+	if err != nil {
+		return err
+	}
+	if !b {
+		err = set.Add(addr)
+		if err != nil {
+			return err
+		}
+		watcher.markUnclaimed(addr, request.From != "")
+	}
+	watcher.backfillRequests = append(watcher.backfillRequests, request)
+
+	return nil
+}
+
+//标记回溯请求尚未取出的地址,调用方需持有锁
+func (watcher *SimpleTxWatcher) markUnclaimed(addr common.Address, isFrom bool) {
+	if isFrom {
+		if watcher.unclaimedFroms == nil {
+			watcher.unclaimedFroms = make(map[common.Address]bool)
+		}
+		watcher.unclaimedFroms[addr] = true
+		return
+	}
+	if watcher.unclaimedTos == nil {
+		watcher.unclaimedTos = make(map[common.Address]bool)
 	}
-	watcher.interestedTos[strings.ToLower(to)] = true
+	watcher.unclaimedTos[addr] = true
+}
+
+//移除关注的from address,并取消该地址的回溯扫描
+func (watcher *SimpleTxWatcher) RemoveInterestedFrom(from string) error {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	addr := common.HexToAddress(from)
+	watcher.cancelBackfills(addr, true)
+
+	return watcher.interestedFroms.Remove(addr)
+}
+
+//移除关注的to address,并取消该地址的回溯扫描
+func (watcher *SimpleTxWatcher) RemoveInterestedTo(to string) error {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	addr := common.HexToAddress(to)
+	watcher.cancelBackfills(addr, false)
+
+	return watcher.interestedTos.Remove(addr)
+}
+
+//取消地址的回溯请求,包括已被扫描器取出的请求,调用方需持有锁
+func (watcher *SimpleTxWatcher) cancelBackfills(addr common.Address, isFrom bool) {
+	matches := func(request *BackfillRequest) bool {
+		if isFrom {
+			return request.From != "" && common.HexToAddress(request.From) == addr
+		}
+		return request.To != "" && common.HexToAddress(request.To) == addr
+	}
+
+	if isFrom {
+		delete(watcher.unclaimedFroms, addr)
+	} else {
+		delete(watcher.unclaimedTos, addr)
+	}
+	requests := watcher.backfillRequests[:0]
+	for _, request := range watcher.backfillRequests {
+		if matches(request) {
+			request.Cancel()
+			continue
+		}
+		requests = append(requests, request)
+	}
+	watcher.backfillRequests = requests
+	for _, request := range watcher.claimedBackfills {
+		if matches(request) {
+			request.Cancel()
+		}
+	}
+}
+
+//从配置文件加载关注地址,上次从文件加载而本次文件中已没有的地址会被移除
+func (watcher *SimpleTxWatcher) LoadInterestedFile(path string) error {
+	config := &InterestedConfig{}
+	err := filewatch.LoadJSON(path, config)
+	if err != nil {
+		return err
+	}
+
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	froms, err := reloadAddresses(watcher.interestedFroms, watcher.fileFroms, config.Froms, func(addr common.Address) {
+		if config.BackfillFromBlock > 0 {
+			watcher.backfillRequests = append(watcher.backfillRequests, &BackfillRequest{From: strings.ToLower(addr.Hex()), FromBlock: config.BackfillFromBlock})
+			watcher.markUnclaimed(addr, true)
+		}
+	}, func(addr common.Address) {
+		watcher.cancelBackfills(addr, true)
+	})
+	if err != nil {
+		return err
+	}
+	watcher.fileFroms = froms
+
+	tos, err := reloadAddresses(watcher.interestedTos, watcher.fileTos, config.Tos, func(addr common.Address) {
+		if config.BackfillFromBlock > 0 {
+			watcher.backfillRequests = append(watcher.backfillRequests, &BackfillRequest{To: strings.ToLower(addr.Hex()), FromBlock: config.BackfillFromBlock})
+			watcher.markUnclaimed(addr, false)
+		}
+	}, func(addr common.Address) {
+		watcher.cancelBackfills(addr, false)
+	})
+	if err != nil {
+		return err
+	}
+	watcher.fileTos = tos
+
+	return nil
+}
+
+//将文件中的地址同步到集合,返回本次文件中的地址
+func reloadAddresses(set addrset.AddressSet, lastFileAddrs map[common.Address]bool, hexAddrs []string, onAdded func(common.Address), onRemoved func(common.Address)) (map[common.Address]bool, error) {
+	fileAddrs := make(map[common.Address]bool, len(hexAddrs))
+	for _, hexAddr := range hexAddrs {
+		addr := common.HexToAddress(hexAddr)
+		fileAddrs[addr] = true
+
+		b, err := set.Contains(addr)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			continue
+		}
+		err = set.Add(addr)
+		if err != nil {
+			return nil, err
+		}
+		onAdded(addr)
+	}
+	for addr := range lastFileAddrs {
+		if fileAddrs[addr] {
+			continue
+		}
+		err := set.Remove(addr)
+		if err != nil {
+			return nil, err
+		}
+		onRemoved(addr)
+	}
+
+	return fileAddrs, nil
+}
+
+//定时检查配置文件,文件修改后自动重新加载关注地址,返回停止检查的方法
+func (watcher *SimpleTxWatcher) WatchInterestedFile(path string, interval time.Duration) (func(), error) {
+	return filewatch.Watch(path, interval, watcher.LoadInterestedFile, LogToConsole)
+}
+
+//取出待回溯扫描的请求,取出的请求在完成前仍可被移除地址时取消;
+//取出后请求对应的新增地址开始参与匹配,实时扫描在匹配每个区块前取出,回溯扫描到该区块之前为止
+func (watcher *SimpleTxWatcher) PopBackfillRequests() []*BackfillRequest {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	requests := watcher.backfillRequests
+	watcher.backfillRequests = nil
+	watcher.unclaimedFroms = nil
+	watcher.unclaimedTos = nil
+	claimed := watcher.claimedBackfills[:0]
+	for _, request := range watcher.claimedBackfills {
+		if !request.IsDone() {
+			claimed = append(claimed, request)
+		}
+	}
+	watcher.claimedBackfills = append(claimed, requests...)
+
+	return requests
 }
 
 func (watcher *SimpleTxWatcher) GetScanStartBlock() uint64 {
@@ -57,15 +669,13 @@ func (watcher *SimpleTxWatcher) GetScanStartBlock() uint64 {
 }
 
 func (watcher *SimpleTxWatcher) GetEthClients() ([]*ethclient.Client, error) {
-	clients := make([]*ethclient.Client, len(watcher.endpoints))
-	for i := 0; i < len(watcher.endpoints); i++ {
-		rpcClient, err := rpc.Dial(watcher.endpoints[i])
+	endpoints := watcher.getEndpoints()
+	clients := make([]*ethclient.Client, len(endpoints))
+	for i := 0; i < len(endpoints); i++ {
+		rpcClient, err := watcher.dial(endpoints[i], i)
 		if err != nil {
 			return nil, err
 		}
-		if i < len(watcher.infuraSecrets) && strings.Trim(watcher.infuraSecrets[i], " ") != "" {
-			rpcClient.SetHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+watcher.infuraSecrets[i])))
-		}
 
 		clients[i] = ethclient.NewClient(rpcClient)
 	}
@@ -74,21 +684,29 @@ func (watcher *SimpleTxWatcher) GetEthClients() ([]*ethclient.Client, error) {
 }
 
 func (watcher *SimpleTxWatcher) IsInterestedTx(from string, to string) bool {
+	b, err := watcher.IsInterestedTxAddress(common.HexToAddress(from), common.HexToAddress(to))
+	if err != nil {
+		LogToConsole("check interested tx error: " + err.Error())
+	}
 
-	if watcher.interestedFroms != nil {
-		_, b := watcher.interestedFroms[strings.ToLower(from)]
-		if b {
-			return b
+	return b
+}
+
+func (watcher *SimpleTxWatcher) IsInterestedTxAddress(from common.Address, to common.Address) (bool, error) {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	if !watcher.unclaimedFroms[from] {
+		b, err := watcher.interestedFroms.Contains(from)
+		if err != nil || b {
+			return b, err
 		}
 	}
-	if watcher.interestedTos != nil {
-		_, b := watcher.interestedTos[strings.ToLower(to)]
-		if b {
-			return b
-		}
+	if watcher.unclaimedTos[to] {
+		return false, nil
 	}
 
-	return false
+	return watcher.interestedTos.Contains(to)
 }
 
 //tx回调处理方法
@@ -0,0 +1,250 @@
+package txscanner
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/addrlabel"
+)
+
+//TxInfo的json结构,字段名和数值编码与以太坊rpc一致
+type txInfoJSON struct {
+	TxHash              string         `json:"hash"`
+	BlockHash           string         `json:"blockHash"`
+	BlockNumber         *hexutil.Big   `json:"blockNumber"`
+	BlockUnixSecs       hexutil.Uint64 `json:"blockTimestamp"`
+	From                string         `json:"from"`
+	Gas                 hexutil.Uint64 `json:"gas"`
+	GasPrice            *hexutil.Big   `json:"gasPrice"`
+	Input               hexutil.Bytes  `json:"input"`
+	Nonce               hexutil.Uint64 `json:"nonce"`
+	To                  *string        `json:"to"`
+	Value               *hexutil.Big   `json:"value"`
+	V                   *hexutil.Big   `json:"v"`
+	R                   *hexutil.Big   `json:"r"`
+	S                   *hexutil.Big   `json:"s"`
+	ChainID             *hexutil.Big   `json:"chainId"`
+	Status              hexutil.Uint64 `json:"status"`
+	TransactionIndex    hexutil.Uint   `json:"transactionIndex"`
+	GasUsed             hexutil.Uint64 `json:"gasUsed"`
+	CumulativeGasUsed   hexutil.Uint64 `json:"cumulativeGasUsed"`
+	Logs                []*types.Log   `json:"logs"`
+	Type                hexutil.Uint64 `json:"type"`
+	L1GasUsed           *hexutil.Big   `json:"l1GasUsed,omitempty"`
+	L1GasPrice          *hexutil.Big   `json:"l1GasPrice,omitempty"`
+	L1Fee               *hexutil.Big   `json:"l1Fee,omitempty"`
+	BlobVersionedHashes []string       `json:"blobVersionedHashes,omitempty"`
+	MaxFeePerBlobGas    *hexutil.Big   `json:"maxFeePerBlobGas,omitempty"`
+	BlobGasUsed         hexutil.Uint64 `json:"blobGasUsed,omitempty"`
+	BlobGasPrice        *hexutil.Big   `json:"blobGasPrice,omitempty"`
+	//MatchedLogs在logs中的下标
+	MatchedLogIndexes []hexutil.Uint              `json:"matchedLogIndexes,omitempty"`
+	RevertReason      *revertReasonJSON           `json:"revertReason,omitempty"`
+	FromInfo          *addressInfoJSON            `json:"fromInfo,omitempty"`
+	ToInfo            *addressInfoJSON            `json:"toInfo,omitempty"`
+	LogAddressInfos   map[string]*addressInfoJSON `json:"logAddressInfos,omitempty"`
+}
+
+type revertReasonJSON struct {
+	Data    hexutil.Bytes `json:"data"`
+	Error   string        `json:"error,omitempty"`
+	Message string        `json:"message,omitempty"`
+}
+
+type addressInfoJSON struct {
+	Label    string `json:"label,omitempty"`
+	Category string `json:"category,omitempty"`
+	Kind     string `json:"kind"`
+}
+
+func newAddressInfoJSON(info *AddressInfo) *addressInfoJSON {
+	if info == nil {
+		return nil
+	}
+	enc := &addressInfoJSON{Kind: info.Kind.String()}
+	if info.Label != nil {
+		enc.Label = info.Label.Name
+		enc.Category = info.Label.Category
+	}
+
+	return enc
+}
+
+func (enc *addressInfoJSON) addressInfo() *AddressInfo {
+	if enc == nil {
+		return nil
+	}
+	info := &AddressInfo{Kind: ParseAddressKind(enc.Kind)}
+	if enc.Label != "" || enc.Category != "" {
+		info.Label = &addrlabel.Label{Name: enc.Label, Category: enc.Category}
+	}
+
+	return info
+}
+
+//序列化为json,input包含方法id,数值均为0x开头的hex
+func (tx *TxInfo) MarshalJSON() ([]byte, error) {
+	enc := &txInfoJSON{
+		TxHash:              tx.TxHash,
+		BlockHash:           tx.BlockHash,
+		BlockNumber:         (*hexutil.Big)(tx.BlockNumber),
+		BlockUnixSecs:       hexutil.Uint64(tx.BlockUnixSecs),
+		From:                tx.From,
+		Gas:                 hexutil.Uint64(tx.Gas),
+		GasPrice:            (*hexutil.Big)(tx.GasPrice),
+		Nonce:               hexutil.Uint64(tx.Nonce),
+		Value:               (*hexutil.Big)(tx.Value),
+		V:                   bytesToBig(tx.V),
+		R:                   bytesToBig(tx.R),
+		S:                   bytesToBig(tx.S),
+		ChainID:             (*hexutil.Big)(tx.ChainID),
+		Status:              hexutil.Uint64(tx.Status),
+		TransactionIndex:    hexutil.Uint(tx.TransactionIndex),
+		GasUsed:             hexutil.Uint64(tx.GasUsed),
+		CumulativeGasUsed:   hexutil.Uint64(tx.CumulativeGasUsed),
+		Logs:                tx.Logs(),
+		Type:                hexutil.Uint64(tx.Type),
+		L1GasUsed:           (*hexutil.Big)(tx.L1GasUsed),
+		L1GasPrice:          (*hexutil.Big)(tx.L1GasPrice),
+		L1Fee:               (*hexutil.Big)(tx.L1Fee),
+		BlobVersionedHashes: tx.BlobVersionedHashes,
+		MaxFeePerBlobGas:    (*hexutil.Big)(tx.BlobGasFeeCap),
+		BlobGasUsed:         hexutil.Uint64(tx.BlobGasUsed),
+		BlobGasPrice:        (*hexutil.Big)(tx.BlobGasPrice),
+	}
+	//合约创建交易的to与rpc一致为null
+	if tx.To != "" {
+		enc.To = &tx.To
+	}
+	if tx.CallMethodID != "" {
+		methodID, err := hex.DecodeString(tx.CallMethodID)
+		if err != nil {
+			return nil, err
+		}
+		enc.Input = append(methodID, tx.InputData...)
+	}
+	if enc.Logs == nil {
+		enc.Logs = []*types.Log{}
+	}
+	if tx.RevertReason != nil {
+		enc.RevertReason = &revertReasonJSON{
+			Data:    tx.RevertReason.Data,
+			Error:   tx.RevertReason.Error,
+			Message: tx.RevertReason.Message,
+		}
+	}
+	enc.FromInfo = newAddressInfoJSON(tx.FromInfo)
+	enc.ToInfo = newAddressInfoJSON(tx.ToInfo)
+	if tx.LogAddressInfos != nil {
+		enc.LogAddressInfos = make(map[string]*addressInfoJSON, len(tx.LogAddressInfos))
+		for addr, info := range tx.LogAddressInfos {
+			enc.LogAddressInfos[addr] = newAddressInfoJSON(info)
+		}
+	}
+	for _, matchedLog := range tx.MatchedLogs {
+		for i, log := range enc.Logs {
+			if log.Index == matchedLog.Index {
+				enc.MatchedLogIndexes = append(enc.MatchedLogIndexes, hexutil.Uint(i))
+				break
+			}
+		}
+	}
+
+	return json.Marshal(enc)
+}
+
+//从MarshalJSON的输出反序列化
+func (tx *TxInfo) UnmarshalJSON(input []byte) error {
+	dec := &txInfoJSON{}
+	err := json.Unmarshal(input, dec)
+	if err != nil {
+		return err
+	}
+
+	*tx = TxInfo{
+		TxHash:              dec.TxHash,
+		BlockHash:           dec.BlockHash,
+		BlockNumber:         (*big.Int)(dec.BlockNumber),
+		BlockUnixSecs:       uint64(dec.BlockUnixSecs),
+		From:                dec.From,
+		Gas:                 uint64(dec.Gas),
+		GasPrice:            (*big.Int)(dec.GasPrice),
+		Nonce:               uint64(dec.Nonce),
+		Value:               (*big.Int)(dec.Value),
+		V:                   bigToBytes(dec.V),
+		R:                   bigToBytes(dec.R),
+		S:                   bigToBytes(dec.S),
+		ChainID:             (*big.Int)(dec.ChainID),
+		Status:              uint64(dec.Status),
+		TransactionIndex:    uint(dec.TransactionIndex),
+		GasUsed:             uint64(dec.GasUsed),
+		CumulativeGasUsed:   uint64(dec.CumulativeGasUsed),
+		Type:                uint8(dec.Type),
+		L1GasUsed:           (*big.Int)(dec.L1GasUsed),
+		L1GasPrice:          (*big.Int)(dec.L1GasPrice),
+		L1Fee:               (*big.Int)(dec.L1Fee),
+		BlobVersionedHashes: dec.BlobVersionedHashes,
+		BlobGasFeeCap:       (*big.Int)(dec.MaxFeePerBlobGas),
+		BlobGasUsed:         uint64(dec.BlobGasUsed),
+		BlobGasPrice:        (*big.Int)(dec.BlobGasPrice),
+	}
+	if dec.To != nil {
+		tx.To = *dec.To
+	}
+	if len(dec.Input) >= 4 {
+		tx.CallMethodID = hex.EncodeToString(dec.Input[:4])
+	}
+	if len(dec.Input) > 4 {
+		tx.InputData = dec.Input[4:]
+	}
+	tx.receipt = &types.Receipt{
+		Type:              tx.Type,
+		Status:            tx.Status,
+		CumulativeGasUsed: tx.CumulativeGasUsed,
+		Logs:              dec.Logs,
+		GasUsed:           tx.GasUsed,
+		TransactionIndex:  tx.TransactionIndex,
+		BlobGasUsed:       tx.BlobGasUsed,
+		BlobGasPrice:      tx.BlobGasPrice,
+	}
+	//自定义错误的参数需使用abi重新解码
+	if dec.RevertReason != nil {
+		tx.RevertReason = DecodeRevert(dec.RevertReason.Data)
+		tx.RevertReason.Error = dec.RevertReason.Error
+		tx.RevertReason.Message = dec.RevertReason.Message
+	}
+	tx.FromInfo = dec.FromInfo.addressInfo()
+	tx.ToInfo = dec.ToInfo.addressInfo()
+	if dec.LogAddressInfos != nil {
+		tx.LogAddressInfos = make(map[string]*AddressInfo, len(dec.LogAddressInfos))
+		for addr, info := range dec.LogAddressInfos {
+			tx.LogAddressInfos[addr] = info.addressInfo()
+		}
+	}
+	for _, i := range dec.MatchedLogIndexes {
+		if int(i) < len(dec.Logs) {
+			tx.MatchedLogs = append(tx.MatchedLogs, dec.Logs[i])
+		}
+	}
+
+	return nil
+}
+
+func bytesToBig(b []byte) *hexutil.Big {
+	if b == nil {
+		return nil
+	}
+
+	return (*hexutil.Big)(new(big.Int).SetBytes(b))
+}
+
+func bigToBytes(n *hexutil.Big) []byte {
+	if n == nil {
+		return nil
+	}
+
+	return (*big.Int)(n).Bytes()
+}
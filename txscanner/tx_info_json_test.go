@@ -0,0 +1,110 @@
+package txscanner_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func goldenTxInfo() *txscanner.TxInfo {
+	chain := fakechain.NewChain(1)
+	tx := chain.AddTx(1, &tokenAddr, big.NewInt(3), []byte{0xa9, 0x05, 0x9c, 0xbb, 0x01, 0x02}, fakechain.NewLog(tokenAddr, []common.Hash{transferTopic}, []byte{0x03}))
+	block := chain.Mine()
+	txInfo := txscanner.NewTxInfo(block, tx, chain.Sender(tx))
+	txInfo.SetReceipt(chain.Receipt(tx.Hash()))
+
+	return txInfo
+}
+
+//合约创建交易,to为null
+func goldenCreateTxInfo() *txscanner.TxInfo {
+	chain := fakechain.NewChain(1)
+	tx := chain.AddTx(1, nil, big.NewInt(0), []byte{0x60, 0x80, 0x60, 0x40, 0x52})
+	block := chain.Mine()
+	txInfo := txscanner.NewTxInfo(block, tx, chain.Sender(tx))
+	txInfo.SetReceipt(chain.Receipt(tx.Hash()))
+
+	return txInfo
+}
+
+func TestTxInfoJSONGolden(t *testing.T) {
+	for name, txInfo := range map[string]*txscanner.TxInfo{
+		"txinfo.golden.json":        goldenTxInfo(),
+		"txinfo_create.golden.json": goldenCreateTxInfo(),
+	} {
+		got, err := json.MarshalIndent(txInfo, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join("testdata", name)
+		if *updateGolden {
+			err = ioutil.WriteFile(path, got, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s mismatch, run go test -update to regenerate:\n%s", name, got)
+		}
+	}
+}
+
+func TestTxInfoJSONRoundTrip(t *testing.T) {
+	txInfo := goldenTxInfo()
+	encoded, err := json.Marshal(txInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &txscanner.TxInfo{}
+	err = json.Unmarshal(encoded, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, reencoded) {
+		t.Fatalf("round trip mismatch:\n%s\n%s", encoded, reencoded)
+	}
+	if !reflect.DeepEqual(decoded.Logs()[0].Data, txInfo.Logs()[0].Data) || decoded.CallMethodID != txInfo.CallMethodID {
+		t.Fatal("decoded fields mismatch")
+	}
+}
+
+func TestTxInfoJSONContractCreation(t *testing.T) {
+	encoded, err := json.Marshal(goldenCreateTxInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(encoded, []byte(`"to":null`)) {
+		t.Fatalf("contract creation to is not null: %s", encoded)
+	}
+	decoded := &txscanner.TxInfo{}
+	err = json.Unmarshal(encoded, decoded)
+	if err != nil || decoded.To != "" {
+		t.Fatalf("decoded to %q, err %v", decoded.To, err)
+	}
+}
+
+func TestTxInfoJSONNilFields(t *testing.T) {
+	_, err := json.Marshal(&txscanner.TxInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,381 @@
+package txscanner
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/addrset"
+)
+
+//交易过滤规则,可通过And/Or/Not组合
+type TxRule interface {
+	//是否需要receipt才能判断(如执行状态),为true时匹配前先获取receipt
+	NeedsReceipt() bool
+
+	//是否匹配交易,NeedsReceipt为false时tx的receipt未设置
+	Match(tx *RuleTx) (bool, error)
+}
+
+//支持交易过滤规则的watcher,规则不为nil时替换IsInterestedTx的地址匹配,
+//可通过InterestedRule组合watcher原有的地址匹配
+type RuleTxWatcher interface {
+	TxWatcher
+
+	//获取交易过滤规则,为nil时使用IsInterestedTx
+	GetTxRule() TxRule
+}
+
+//规则匹配的交易,tx信息在首次使用时构造
+type RuleTx struct {
+	From common.Address
+	To   common.Address
+	//按log匹配时,indexed topic中包含关注地址的log
+	MatchedLogs []*types.Log
+
+	block  *Block
+	btx    *BlockTx
+	txInfo *TxInfo
+	//预先匹配无法判断,需获取receipt后匹配
+	needsReceiptMatch bool
+}
+
+func newRuleTx(block *Block, btx *BlockTx) *RuleTx {
+	return &RuleTx{
+		From:  btx.From,
+		To:    *btx.To,
+		block: block,
+		btx:   btx,
+	}
+}
+
+//获取完整的tx信息,规则NeedsReceipt为true时receipt相关字段已设置
+func (tx *RuleTx) TxInfo() *TxInfo {
+	if tx.txInfo == nil {
+		tx.txInfo = tx.btx.TxInfo(tx.block)
+		tx.txInfo.MatchedLogs = tx.MatchedLogs
+	}
+
+	return tx.txInfo
+}
+
+//获取交易的receipt,未获取时为nil
+func (tx *RuleTx) Receipt() *types.Receipt {
+	if tx.txInfo == nil {
+		return nil
+	}
+
+	return tx.txInfo.Receipt()
+}
+
+type funcRule struct {
+	needsReceipt bool
+	match        func(tx *RuleTx) (bool, error)
+}
+
+func (rule *funcRule) NeedsReceipt() bool {
+	return rule.needsReceipt
+}
+
+func (rule *funcRule) Match(tx *RuleTx) (bool, error) {
+	return rule.match(tx)
+}
+
+//自定义规则
+func RuleFunc(needsReceipt bool, match func(tx *RuleTx) (bool, error)) TxRule {
+	return &funcRule{needsReceipt: needsReceipt, match: match}
+}
+
+//使用watcher的IsInterestedTx(或IsInterestedTxAddress)匹配
+func InterestedRule(txWatcher TxWatcher) TxRule {
+	isInterestedTx := interestedTxMatcher(txWatcher)
+	return RuleFunc(false, func(tx *RuleTx) (bool, error) {
+		return isInterestedTx(tx.From, tx.To)
+	})
+}
+
+//from地址在addresses中
+func FromIn(addresses ...string) TxRule {
+	return FromSet(addrset.NewMemorySet(hexToAddresses(addresses)...))
+}
+
+//to地址在addresses中
+func ToIn(addresses ...string) TxRule {
+	return ToSet(addrset.NewMemorySet(hexToAddresses(addresses)...))
+}
+
+//from地址在集合中(如redis/sql集合)
+func FromSet(set addrset.AddressSet) TxRule {
+	return RuleFunc(false, func(tx *RuleTx) (bool, error) {
+		return set.Contains(tx.From)
+	})
+}
+
+//to地址在集合中(如redis/sql集合)
+func ToSet(set addrset.AddressSet) TxRule {
+	return RuleFunc(false, func(tx *RuleTx) (bool, error) {
+		return set.Contains(tx.To)
+	})
+}
+
+func hexToAddresses(addresses []string) []common.Address {
+	result := make([]common.Address, len(addresses))
+	for i, address := range addresses {
+		result[i] = common.HexToAddress(address)
+	}
+
+	return result
+}
+
+//调用的方法id(如0xa9059cbb)为selectors之一
+func MethodIs(selectors ...string) TxRule {
+	methodIDs := make(map[string]bool, len(selectors))
+	for _, selector := range selectors {
+		methodIDs[strings.TrimPrefix(strings.ToLower(selector), "0x")] = true
+	}
+
+	return RuleFunc(false, func(tx *RuleTx) (bool, error) {
+		methodID := tx.TxInfo().CallMethodID
+		return methodID != "" && methodIDs[methodID], nil
+	})
+}
+
+//转账金额大于等于min
+func ValueAtLeast(min *big.Int) TxRule {
+	return ValueBetween(min, nil)
+}
+
+//转账金额在[min, max]内,min或max为nil时不限制
+func ValueBetween(min *big.Int, max *big.Int) TxRule {
+	return RuleFunc(false, func(tx *RuleTx) (bool, error) {
+		return inRange(tx.TxInfo().Value, min, max), nil
+	})
+}
+
+//交易gas price在[min, max]内,min或max为nil时不限制;EIP-1559交易为max fee per gas
+func GasPriceBetween(min *big.Int, max *big.Int) TxRule {
+	return RuleFunc(false, func(tx *RuleTx) (bool, error) {
+		return inRange(tx.TxInfo().GasPrice, min, max), nil
+	})
+}
+
+//receipt中的实际gas price在[min, max]内,min或max为nil时不限制
+func EffectiveGasPriceBetween(min *big.Int, max *big.Int) TxRule {
+	return RuleFunc(true, func(tx *RuleTx) (bool, error) {
+		receipt := tx.Receipt()
+		return receipt != nil && inRange(receipt.EffectiveGasPrice, min, max), nil
+	})
+}
+
+func inRange(n *big.Int, min *big.Int, max *big.Int) bool {
+	if n == nil {
+		n = new(big.Int)
+	}
+
+	return (min == nil || n.Cmp(min) >= 0) && (max == nil || n.Cmp(max) <= 0)
+}
+
+//交易的log中包含关注地址(需watcher开启按log匹配);
+//开启bloom预检查且关注地址可枚举时,跳过bloom中不包含任一关注地址topic的区块
+func LogMatched() TxRule {
+	return &logMatchedRule{}
+}
+
+type logMatchedRule struct{}
+
+func (rule *logMatchedRule) NeedsReceipt() bool {
+	return false
+}
+
+func (rule *logMatchedRule) Match(tx *RuleTx) (bool, error) {
+	return len(tx.MatchedLogs) > 0, nil
+}
+
+type logRule struct {
+	address *common.Address
+	topic0  *common.Hash
+}
+
+//交易产生了address(为空时不限制)的topic0(为空时不限制)的log;
+//规则只匹配产生该log的交易时,开启bloom预检查后跳过bloom中不包含该log的区块
+func LogEmitted(address string, topic0 string) TxRule {
+	rule := &logRule{}
+	if address != "" {
+		addr := common.HexToAddress(address)
+		rule.address = &addr
+	}
+	if topic0 != "" {
+		topic := common.HexToHash(topic0)
+		rule.topic0 = &topic
+	}
+
+	return rule
+}
+
+func (rule *logRule) NeedsReceipt() bool {
+	return true
+}
+
+func (rule *logRule) Match(tx *RuleTx) (bool, error) {
+	receipt := tx.Receipt()
+	if receipt == nil {
+		return false, nil
+	}
+	for _, log := range receipt.Logs {
+		if rule.address != nil && log.Address != *rule.address {
+			continue
+		}
+		if rule.topic0 != nil && (len(log.Topics) == 0 || log.Topics[0] != *rule.topic0) {
+			continue
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+//交易执行成功
+func Succeeded() TxRule {
+	return RuleFunc(true, func(tx *RuleTx) (bool, error) {
+		receipt := tx.Receipt()
+		return receipt != nil && receipt.Status == types.ReceiptStatusSuccessful, nil
+	})
+}
+
+//交易执行失败
+func Failed() TxRule {
+	return RuleFunc(true, func(tx *RuleTx) (bool, error) {
+		receipt := tx.Receipt()
+		return receipt != nil && receipt.Status == types.ReceiptStatusFailed, nil
+	})
+}
+
+type andRule struct {
+	rules []TxRule
+}
+
+type orRule struct {
+	rules []TxRule
+}
+
+type notRule struct {
+	rule TxRule
+}
+
+//所有规则均匹配,无规则时匹配所有交易
+func And(rules ...TxRule) TxRule {
+	return &andRule{rules: rules}
+}
+
+//任一规则匹配,无规则时不匹配任何交易
+func Or(rules ...TxRule) TxRule {
+	return &orRule{rules: rules}
+}
+
+//规则不匹配
+func Not(rule TxRule) TxRule {
+	return &notRule{rule: rule}
+}
+
+func (rule *andRule) NeedsReceipt() bool {
+	return anyNeedsReceipt(rule.rules)
+}
+
+func (rule *andRule) Match(tx *RuleTx) (bool, error) {
+	for _, r := range rule.rules {
+		matched, err := r.Match(tx)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (rule *orRule) NeedsReceipt() bool {
+	return anyNeedsReceipt(rule.rules)
+}
+
+func (rule *orRule) Match(tx *RuleTx) (bool, error) {
+	for _, r := range rule.rules {
+		matched, err := r.Match(tx)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+
+	return false, nil
+}
+
+func (rule *notRule) NeedsReceipt() bool {
+	return rule.rule.NeedsReceipt()
+}
+
+func (rule *notRule) Match(tx *RuleTx) (bool, error) {
+	matched, err := rule.rule.Match(tx)
+
+	return !matched && err == nil, err
+}
+
+func anyNeedsReceipt(rules []TxRule) bool {
+	for _, rule := range rules {
+		if rule.NeedsReceipt() {
+			return true
+		}
+	}
+
+	return false
+}
+
+//获取receipt前预先匹配,只计算不需要receipt的规则;
+//known为false时需获取receipt后再匹配
+func preMatch(rule TxRule, tx *RuleTx) (matched bool, known bool, err error) {
+	switch r := rule.(type) {
+	case *andRule:
+		known = true
+		for _, sub := range r.rules {
+			subMatched, subKnown, err := preMatch(sub, tx)
+			if err != nil {
+				return false, true, err
+			}
+			if subKnown && !subMatched {
+				return false, true, nil
+			}
+			known = known && subKnown
+		}
+		return known, known, nil
+	case *orRule:
+		known = true
+		for _, sub := range r.rules {
+			subMatched, subKnown, err := preMatch(sub, tx)
+			if err != nil {
+				return false, true, err
+			}
+			if subKnown && subMatched {
+				return true, true, nil
+			}
+			known = known && subKnown
+		}
+		return false, known, nil
+	case *notRule:
+		matched, known, err = preMatch(r.rule, tx)
+		return !matched && known && err == nil, known, err
+	}
+
+	if rule.NeedsReceipt() {
+		return false, false, nil
+	}
+	matched, err = rule.Match(tx)
+
+	return matched, true, err
+}
+
+//获取watcher的交易过滤规则,未设置时返回nil
+func txRule(txWatcher TxWatcher) TxRule {
+	if ruleWatcher, ok := txWatcher.(RuleTxWatcher); ok {
+		return ruleWatcher.GetTxRule()
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package txscanner_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+func TestScanTxRules(t *testing.T) {
+	transferInput := hexutil.MustDecode("0xa9059cbb" + "000000000000000000000000" + depositAddr.Hex()[2:] + "0000000000000000000000000000000000000000000000000000000000000001")
+	approveInput := hexutil.MustDecode("0x095ea7b3" + "000000000000000000000000" + depositAddr.Hex()[2:] + "0000000000000000000000000000000000000000000000000000000000000001")
+
+	chain := fakechain.NewChain(1)
+	transfer := chain.AddTx(1, &tokenAddr, nil, transferInput)
+	chain.AddTx(1, &tokenAddr, nil, approveInput)
+	chain.AddFailedTx(2, &tokenAddr, nil, transferInput)
+	chain.Mine()
+	chain.Transfer(1, depositAddr, big.NewInt(99))
+	large := chain.Transfer(2, depositAddr, big.NewInt(100))
+	chain.Transfer(3, depositAddr, big.NewInt(1000))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, servers := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(tokenAddr))
+	watcher.SetTxRule(txscanner.Or(
+		txscanner.And(txscanner.InterestedRule(watcher), txscanner.MethodIs("0xA9059CBB"), txscanner.Succeeded()),
+		txscanner.And(txscanner.ToIn(depositAddr.Hex()), txscanner.ValueAtLeast(big.NewInt(100)), txscanner.Not(txscanner.FromIn(fakechain.Address(3).Hex()))),
+	))
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), transfer, large)
+	//只有2笔transfer需要receipt判断执行状态,加上匹配的转账共3次
+	if servers[0].Calls("eth_getTransactionReceipt") != 3 {
+		t.Fatalf("receipt calls %d", servers[0].Calls("eth_getTransactionReceipt"))
+	}
+}
+
+func TestTxRuleNeedsReceipt(t *testing.T) {
+	byAddress := txscanner.ToIn(common.Address{}.Hex())
+	tests := []struct {
+		rule txscanner.TxRule
+		want bool
+	}{
+		{byAddress, false},
+		{txscanner.GasPriceBetween(big.NewInt(1), nil), false},
+		{txscanner.Failed(), true},
+		{txscanner.EffectiveGasPriceBetween(nil, big.NewInt(1)), true},
+		{txscanner.And(byAddress, txscanner.Not(txscanner.Succeeded())), true},
+		{txscanner.Or(byAddress, txscanner.MethodIs("0xa9059cbb")), false},
+	}
+	for i, test := range tests {
+		if test.rule.NeedsReceipt() != test.want {
+			t.Fatalf("rule %d: needs receipt %t", i, !test.want)
+		}
+	}
+}
@@ -3,17 +3,30 @@ package txscanner
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
 )
 
+//区块内匹配的交易数达到该数量且节点支持时,使用eth_getBlockReceipts获取receipt
+const blockReceiptsMinTxs = 2
+
+//节点探测失败后重新探测的间隔
+const nodeDetectRetryInterval = time.Minute
+
 type TxWatcher interface {
 	//获取开始扫描的区块号
 	GetScanStartBlock() uint64
@@ -31,6 +44,124 @@ type TxWatcher interface {
 	GetScanInterval() time.Duration
 }
 
+//按地址判断是否关注tx的watcher,扫描器优先使用该方法,避免每笔交易的hex编码
+type AddressTxWatcher interface {
+	TxWatcher
+
+	//是否是需要解析的tx
+	IsInterestedTxAddress(from common.Address, to common.Address) (bool, error)
+}
+
+//支持区块bloom预检查的watcher,交易规则只匹配产生特定log的交易(如LogEmitted)时,
+//区块header的bloom中不包含规则所需的log则跳过该区块,不再下载区块内容和receipt;
+//按from/to地址匹配时普通转账不产生log,不跳过任何区块
+type BloomTxWatcher interface {
+	TxWatcher
+
+	//是否开启bloom预检查
+	BloomSkipping() bool
+}
+
+//支持扫描进度确认的watcher,区块内所有tx回调成功后才会确认该区块,
+//返回错误时扫描进度回退到上次确认的区块
+type CheckpointTxWatcher interface {
+	TxWatcher
+
+	//更新已扫描的最大区块号
+	UpdateMaxScanedBlock(blockNumber uint64) error
+}
+
+//支持区块扫描完成回调的watcher
+type BlockTxWatcher interface {
+	TxWatcher
+
+	//获取区块扫描完成回调,区块内所有tx回调成功后调用(包括没有匹配tx的区块),
+	//回调返回错误时重新扫描该区块,返回RescanError时从其FromBlock重新扫描;为nil时不回调
+	GetOnBlock() func(block *BlockInfo) error
+}
+
+//区块回调返回该错误时扫描器回退到FromBlock重新扫描,用于检测到区块重组后重新扫描分叉的区块
+type RescanError struct {
+	FromBlock uint64
+	Reason    string
+}
+
+func (err *RescanError) Error() string {
+	return "rescan from block " + strconv.FormatUint(err.FromBlock, 10) + ": " + err.Reason
+}
+
+//错误为RescanError且回退到startBlock或之前时返回应扫描到的区块号
+func rescanFrom(err error, scanedBlock uint64) (uint64, bool) {
+	var rescan *RescanError
+	if !errors.As(err, &rescan) || rescan.FromBlock == 0 || rescan.FromBlock > scanedBlock+1 {
+		return 0, false
+	}
+
+	return rescan.FromBlock - 1, true
+}
+
+//区块扫描完成信息
+type BlockInfo struct {
+	Header        *types.Header
+	BlockNumber   uint64
+	BlockHash     string
+	BlockUnixSecs uint64
+	//London之前的区块为nil
+	BaseFee  *big.Int
+	GasUsed  uint64
+	GasLimit uint64
+	//区块内的交易数,区块被bloom跳过时为0
+	TxCount int
+	//匹配并回调成功的交易数
+	MatchedTxCount int
+	//是否被bloom预检查跳过
+	Skipped bool
+	//出块地址,clique链由签名恢复
+	Signer string
+}
+
+//指定链配置的watcher,未实现时根据链id选择已知配置
+type ProfileTxWatcher interface {
+	TxWatcher
+
+	//获取链配置,为nil时根据链id选择
+	GetChainProfile() *ChainProfile
+}
+
+//支持按receipt log匹配交易的watcher,log的任一indexed topic(topic1-3)为关注地址时匹配该交易,
+//如转入关注地址的erc20转账(tx的to为代币合约);匹配的log在TxInfo.MatchedLogs中
+type LogTxWatcher interface {
+	TxWatcher
+
+	//获取log中地址的匹配方法,为nil时不按log匹配
+	GetLogAddressMatcher() func(addr common.Address) (bool, error)
+}
+
+//可枚举log匹配地址的watcher,LogMatched规则据此使用bloom预检查
+type LogAddressTxWatcher interface {
+	LogTxWatcher
+
+	//获取log匹配的关注地址,不可枚举或地址数超过limit时返回false
+	GetLogAddresses(limit int) ([]common.Address, bool)
+}
+
+//提供节点探测结果的watcher,扫描器据此启用节点特有的优化(如eth_getBlockReceipts)
+type NodeTxWatcher interface {
+	TxWatcher
+
+	//获取各节点的探测结果,按下标对应GetEthClients返回的节点,未探测或探测失败的节点为nil;
+	//扫描器对探测失败的节点按间隔再次调用
+	GetNodeInfos() []*rpcnode.NodeInfo
+}
+
+//扫描统计
+type ScanStats struct {
+	//已扫描区块数(包含被跳过的区块)
+	ScannedBlocks uint64
+	//bloom预检查跳过的区块数
+	SkippedBlocks uint64
+}
+
 //tx相关信息
 type TxInfo struct {
 	TxHash            string
@@ -53,81 +184,497 @@ type TxInfo struct {
 	TransactionIndex  uint
 	GasUsed           uint64
 	CumulativeGasUsed uint64
+	Type              uint8
+
+	//L2链receipt中的L1费用,非L2链为nil(Arbitrum的L1GasUsed为gasUsedForL1)
+	L1GasUsed  *big.Int
+	L1GasPrice *big.Int
+	L1Fee      *big.Int
+
+	//EIP-4844 blob交易的blob版本hash和max fee per blob gas,非blob交易为空
+	BlobVersionedHashes []string
+	BlobGasFeeCap       *big.Int
+	//receipt中的blob gas用量和价格,非blob交易为0/nil
+	BlobGasUsed  uint64
+	BlobGasPrice *big.Int
+
+	//按log匹配时,indexed topic中包含关注地址的log
+	MatchedLogs []*types.Log
+
+	//失败交易的revert原因,watcher未开启获取或获取失败时为nil
+	RevertReason *RevertReason
+
+	//地址标注信息,watcher未设置Enricher时为nil;合约创建交易的ToInfo为nil
+	FromInfo *AddressInfo
+	ToInfo   *AddressInfo
+	//receipt中log合约地址的标注信息,按小写hex地址索引,无log时为nil
+	LogAddressInfos map[string]*AddressInfo
 
 	receipt *types.Receipt
 }
 
+//回溯扫描请求,From或To为空表示不匹配该项
+type BackfillRequest struct {
+	From      string
+	To        string
+	FromBlock uint64
+
+	state int32
+}
+
+const (
+	backfillPending int32 = iota
+	backfillCancelled
+	backfillFinished
+)
+
+//取消回溯扫描请求,扫描器不再匹配该请求的地址;请求已完成时无影响
+func (request *BackfillRequest) Cancel() {
+	atomic.CompareAndSwapInt32(&request.state, backfillPending, backfillCancelled)
+}
+
+//请求是否已取消
+func (request *BackfillRequest) IsCancelled() bool {
+	return atomic.LoadInt32(&request.state) == backfillCancelled
+}
+
+//请求是否已取消或回溯完成
+func (request *BackfillRequest) IsDone() bool {
+	return atomic.LoadInt32(&request.state) != backfillPending
+}
+
+func (request *BackfillRequest) finish() {
+	atomic.CompareAndSwapInt32(&request.state, backfillPending, backfillFinished)
+}
+
+//等待回溯扫描的请求,toBlock为实时扫描开始匹配新增地址之前的区块
+type pendingBackfill struct {
+	request *BackfillRequest
+	toBlock uint64
+}
+
+//支持运行时新增地址回溯扫描的watcher,实时扫描在匹配每个区块前取出请求,
+//回溯扫描在后台合并进行,扫描到实时扫描开始匹配新增地址之前的区块为止
+type BackfillTxWatcher interface {
+	TxWatcher
+
+	//取出待回溯扫描的请求,地址被移除时watcher需取消(Cancel)对应的请求
+	PopBackfillRequests() []*BackfillRequest
+}
+
+//交易扫描器,同一进程可运行多个实例
+type TxScanner struct {
+	lastScanedBlockNumber uint64
+	scannedBlocks         uint64
+	skippedBlocks         uint64
+
+	txWatcher        TxWatcher
+	chainID          *big.Int
+	signer           types.Signer
+	profile          *ChainProfile
+	nodeInfos        []*rpcnode.NodeInfo
+	nodeDetectTime   time.Time
+	balances         *balanceCache
+	clientSleepTimes map[int]int64
+	stop             chan struct{}
+	stopOnce         sync.Once
+
+	backfillLock     sync.Mutex
+	pendingBackfills []*pendingBackfill
+	backfilling      bool
+	backfillWG       sync.WaitGroup
+}
+
 var (
-	_txWatcher             TxWatcher
-	_lastScanedBlockNumber uint64 = 0
-	_chainID               *big.Int
-	_signer                types.EIP155Signer
-	_clientSleepTimes      map[int]int64
+	_defaultScanner     *TxScanner
+	_defaultScannerLock sync.Mutex
 )
 
-//开始扫描
+//构造交易扫描器
+func NewTxScanner(txWatcher TxWatcher) *TxScanner {
+	return &TxScanner{
+		txWatcher: txWatcher,
+		stop:      make(chan struct{}),
+	}
+}
+
+//使用默认扫描器开始扫描,重复调用时从上次扫描到的区块继续
 func StartScanTx(txWatcher TxWatcher) error {
+	scanner := NewTxScanner(txWatcher)
+	_defaultScannerLock.Lock()
+	if _defaultScanner != nil {
+		scanner.lastScanedBlockNumber = _defaultScanner.GetLastScanedBlock()
+	}
+	_defaultScanner = scanner
+	_defaultScannerLock.Unlock()
+
+	return scanner.Start()
+}
+
+//开始扫描,阻塞直到调用Stop;出错后再次调用时从上次扫描到的区块继续
+func (scanner *TxScanner) Start() error {
 	LogToConsole("eth tx scanner starting...")
-	_txWatcher = txWatcher
-	_clientSleepTimes = make(map[int]int64)
-	startBlock := _txWatcher.GetScanStartBlock()
-	if _lastScanedBlockNumber == 0 {
+	scanner.clientSleepTimes = make(map[int]int64)
+	startBlock := scanner.txWatcher.GetScanStartBlock()
+	if scanner.lastScanedBlockNumber == 0 {
 		if startBlock > 0 {
-			_lastScanedBlockNumber = startBlock - 1
+			scanner.setLastScanedBlock(startBlock - 1)
 		}
 	}
-	clients, err := _txWatcher.GetEthClients()
+	err := scanner.prepare()
 	if err != nil {
 		return err
 	}
+	LogToConsole("chainID:" + scanner.chainID.String() + ",profile:" + scanner.profile.Name + ",scaning...")
 
-	cid, err := clients[0].ChainID(context.Background())
-	if err != nil {
-		return err
-	}
-	_chainID = cid
-	_signer = types.NewEIP155Signer(_chainID)
-	LogToConsole("chainID:" + _chainID.String() + ",scaning...")
-
-	for i := 0; i < len(clients); i++ {
-		clients[i].Close()
-	}
-
-	scanInterval := _txWatcher.GetScanInterval()
+	scanInterval := scanner.txWatcher.GetScanInterval()
 	if scanInterval <= time.Millisecond {
 		scanInterval = 0
 	}
 	errCount := 0
-	for true {
-		scanedBlock, err := scanTx(_lastScanedBlockNumber + 1)
-		if err != nil {
+	for !scanner.isStopped() {
+		lastScanedBlockNumber := scanner.lastScanedBlockNumber
+		var onBlock func(*BlockInfo) error
+		if blockWatcher, ok := scanner.txWatcher.(BlockTxWatcher); ok {
+			onBlock = blockWatcher.GetOnBlock()
+		}
+		scanedBlock, err := scanner.scanTx(scanner.lastScanedBlockNumber+1, 0, newTxMatcher(scanner.txWatcher), onBlock)
+		if rescanBlock, ok := rescanFrom(err, scanedBlock); ok {
+			LogToConsole(err.Error())
+			scanner.setLastScanedBlock(rescanBlock)
+		} else if err != nil {
 			if scanedBlock > 0 {
-				_lastScanedBlockNumber = scanedBlock
+				scanner.setLastScanedBlock(scanedBlock)
 			} else {
 				errCount++
 			}
 		} else {
-			_lastScanedBlockNumber = scanedBlock
+			scanner.setLastScanedBlock(scanedBlock)
 			errCount = 0
 		}
+		if checkpointWatcher, ok := scanner.txWatcher.(CheckpointTxWatcher); ok && scanner.lastScanedBlockNumber > lastScanedBlockNumber {
+			err = checkpointWatcher.UpdateMaxScanedBlock(scanner.lastScanedBlockNumber)
+			if err != nil {
+				LogToConsole("update max scaned block error: " + err.Error() + ",rescan from block " + strconv.FormatUint(lastScanedBlockNumber+1, 10) + ".")
+				scanner.setLastScanedBlock(lastScanedBlockNumber)
+			}
+		}
+
+		scanner.startBackfill()
+
+		if nonceWatcher, ok := scanner.txWatcher.(NonceTxWatcher); ok {
+			if tracker := nonceWatcher.GetNonceTracker(); tracker != nil && tracker.checkDue() {
+				scanner.checkNonces(tracker)
+			}
+		}
 
 		//如果连续报错达到10次，则线程睡眠10秒后继续
 		if errCount == 10 {
 			LogToConsole("scaning block continuous error " + strconv.Itoa(errCount) + " times,sleep 30s...")
-			time.Sleep(30 * time.Second)
+			scanner.sleep(30 * time.Second)
 			errCount = 0
 		}
 
 		if scanInterval > 0 {
-			time.Sleep(scanInterval)
+			scanner.sleep(scanInterval)
 		}
 	}
+	scanner.backfillWG.Wait()
 
 	return nil
 }
 
-func scanTx(startBlock uint64) (uint64, error) {
-	clients, err := _txWatcher.GetEthClients()
+//使用一个可用节点检查nonce跟踪器的交易池状态
+func (scanner *TxScanner) checkNonces(tracker *NonceTracker) {
+	clients, err := scanner.txWatcher.GetEthClients()
+	if err != nil {
+		LogToConsole("check nonces error: " + err.Error())
+		return
+	}
+	for i := 0; i < len(clients); i++ {
+		defer clients[i].Close()
+	}
+
+	avaiIndexes := RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
+	if len(avaiIndexes) == 0 {
+		return
+	}
+	index := avaiIndexes[scanner.lastScanedBlockNumber%uint64(len(avaiIndexes))]
+	err = tracker.Check(clients[index])
+	if err != nil {
+		LogToConsole("check nonces on client_" + strconv.Itoa(index) + " error: " + err.Error())
+	}
+}
+
+//获取链id并初始化signer和链配置
+func (scanner *TxScanner) prepare() error {
+	clients, err := scanner.txWatcher.GetEthClients()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(clients); i++ {
+		defer clients[i].Close()
+	}
+
+	cid, err := clients[0].ChainID(context.Background())
+	if err != nil {
+		return err
+	}
+	scanner.chainID = cid
+	scanner.signer = NewSigner(scanner.chainID)
+	scanner.profile = GetChainProfile(scanner.chainID)
+	if profileWatcher, ok := scanner.txWatcher.(ProfileTxWatcher); ok && profileWatcher.GetChainProfile() != nil {
+		scanner.profile = profileWatcher.GetChainProfile()
+	}
+	if nodeWatcher, ok := scanner.txWatcher.(NodeTxWatcher); ok {
+		scanner.nodeInfos = nodeWatcher.GetNodeInfos()
+		scanner.nodeDetectTime = time.Now()
+	}
+
+	return nil
+}
+
+//获取节点的探测结果,未探测时返回nil;探测失败的节点按间隔重新探测
+func (scanner *TxScanner) nodeInfo(index int) *rpcnode.NodeInfo {
+	if index >= len(scanner.nodeInfos) {
+		return nil
+	}
+	if scanner.nodeInfos[index] == nil && time.Since(scanner.nodeDetectTime) >= nodeDetectRetryInterval {
+		if nodeWatcher, ok := scanner.txWatcher.(NodeTxWatcher); ok {
+			scanner.nodeInfos = nodeWatcher.GetNodeInfos()
+			scanner.nodeDetectTime = time.Now()
+		}
+		if index >= len(scanner.nodeInfos) {
+			return nil
+		}
+	}
+
+	return scanner.nodeInfos[index]
+}
+
+//构造共用链配置和停止信号的扫描器,用于回溯扫描
+func (scanner *TxScanner) child() *TxScanner {
+	child := NewTxScanner(scanner.txWatcher)
+	child.chainID = scanner.chainID
+	child.signer = scanner.signer
+	child.profile = scanner.profile
+	child.nodeInfos = scanner.nodeInfos
+	child.nodeDetectTime = scanner.nodeDetectTime
+	child.clientSleepTimes = make(map[int]int64)
+	child.stop = scanner.stop
+
+	return child
+}
+
+//取出watcher中新增的回溯请求,实时扫描从toBlock之后的区块开始匹配新增地址
+func (scanner *TxScanner) claimBackfills(backfillWatcher BackfillTxWatcher, toBlock uint64) {
+	requests := backfillWatcher.PopBackfillRequests()
+	if len(requests) == 0 {
+		return
+	}
+
+	scanner.backfillLock.Lock()
+	defer scanner.backfillLock.Unlock()
+
+	for _, request := range requests {
+		scanner.pendingBackfills = append(scanner.pendingBackfills, &pendingBackfill{request: request, toBlock: toBlock})
+	}
+}
+
+//没有正在进行的回溯扫描时,在后台合并扫描所有等待的请求,不阻塞实时扫描
+func (scanner *TxScanner) startBackfill() {
+	scanner.backfillLock.Lock()
+	defer scanner.backfillLock.Unlock()
+
+	if scanner.backfilling || len(scanner.pendingBackfills) == 0 {
+		return
+	}
+	backfills := scanner.pendingBackfills
+	scanner.pendingBackfills = nil
+	scanner.backfilling = true
+	scanner.backfillWG.Add(1)
+	go func() {
+		defer scanner.backfillWG.Done()
+		scanner.backfillTx(backfills)
+
+		scanner.backfillLock.Lock()
+		scanner.backfilling = false
+		scanner.backfillLock.Unlock()
+	}()
+}
+
+//合并回溯扫描新增的关注地址,每个地址只匹配[FromBlock, toBlock]内的区块,请求取消后不再匹配该地址
+func (scanner *TxScanner) backfillTx(backfills []*pendingBackfill) {
+	defer func() {
+		for _, backfill := range backfills {
+			backfill.request.finish()
+		}
+	}()
+
+	next := uint64(0)
+	endBlock := uint64(0)
+	for _, backfill := range backfills {
+		fromBlock := max(backfill.request.FromBlock, 1)
+		if next == 0 || fromBlock < next {
+			next = fromBlock
+		}
+		endBlock = max(endBlock, backfill.toBlock)
+	}
+	if next > endBlock {
+		return
+	}
+	startBlock := next
+	LogToConsole(fmt.Sprintf("backfilling %d requests blocks %d - %d...", len(backfills), startBlock, endBlock))
+
+	//当前区块需要回溯的地址
+	var froms, tos map[common.Address]bool
+	isInterestedAddress := func(addr common.Address) (bool, error) {
+		return froms[addr] || tos[addr], nil
+	}
+	//只匹配新增的地址,规则及其他设置与watcher一致
+	matcher := newTxMatcher(scanner.txWatcher)
+	matcher.atBlock = func(blockNumber uint64) {
+		froms = make(map[common.Address]bool)
+		tos = make(map[common.Address]bool)
+		for _, backfill := range backfills {
+			request := backfill.request
+			if request.IsCancelled() || blockNumber < request.FromBlock || blockNumber > backfill.toBlock {
+				continue
+			}
+			if request.From != "" {
+				froms[common.HexToAddress(request.From)] = true
+			}
+			if request.To != "" {
+				tos[common.HexToAddress(request.To)] = true
+			}
+		}
+	}
+	matcher.isInterestedTx = func(from common.Address, to common.Address) (bool, error) {
+		return froms[from] || tos[to], nil
+	}
+	if matcher.isInterestedLogAddress != nil {
+		matcher.isInterestedLogAddress = isInterestedAddress
+	}
+	if matcher.withdrawalWatcher != nil {
+		matcher.isInterestedWithdrawal = isInterestedAddress
+	}
+	//余额变化、nonce和gas统计已在实时扫描时处理
+	matcher.balanceWatcher = nil
+	matcher.nonceTracker = nil
+	matcher.gasAnalytics = nil
+	matcher.backfillWatcher = nil
+
+	child := scanner.child()
+	errCount := 0
+	for next <= endBlock && !scanner.isStopped() && !allCancelled(backfills) {
+		scanedBlock, err := child.scanTx(next, min(next+backfillProgressBlockCount-1, endBlock), matcher, nil)
+		if scanedBlock >= next {
+			next = scanedBlock + 1
+			if err == nil {
+				errCount = 0
+				continue
+			}
+		}
+
+		errCount++
+		if errCount == 10 {
+			LogToConsole(fmt.Sprintf("backfilling continuous error %d times,give up at block %d.", errCount, next))
+			return
+		}
+		scanner.sleep(time.Second)
+	}
+	LogToConsole(fmt.Sprintf("backfill %d requests blocks %d - %d finished.", len(backfills), startBlock, endBlock))
+}
+
+func allCancelled(backfills []*pendingBackfill) bool {
+	for _, backfill := range backfills {
+		if !backfill.request.IsCancelled() {
+			return false
+		}
+	}
+
+	return true
+}
+
+//交易匹配条件
+type txMatcher struct {
+	//地址匹配,为nil时只使用rule
+	isInterestedTx func(from common.Address, to common.Address) (bool, error)
+	//过滤规则,不为nil时需同时匹配
+	rule TxRule
+	//log的indexed topic中的地址匹配,为nil时不按log匹配
+	isInterestedLogAddress func(addr common.Address) (bool, error)
+	//失败交易revert原因的获取方式及解码自定义错误的abi
+	revertReasonMode RevertReasonMode
+	errorABIs        []*abi.ABI
+	//跟踪余额的watcher,为nil时不跟踪
+	balanceWatcher BalanceTxWatcher
+	//回调提款的watcher及提款地址匹配,为nil时不回调提款
+	withdrawalWatcher      WithdrawalTxWatcher
+	isInterestedWithdrawal func(address common.Address) (bool, error)
+	//nonce跟踪器,为nil时不跟踪
+	nonceTracker *NonceTracker
+	//区块gas统计,为nil时不统计
+	gasAnalytics *GasAnalytics
+	//交易标注,为nil时不标注
+	enricher *Enricher
+	//实时扫描取出回溯请求的watcher,回溯扫描时为nil
+	backfillWatcher BackfillTxWatcher
+	//每个区块匹配前调用,为nil时不调用
+	atBlock func(blockNumber uint64)
+}
+
+//根据watcher构造交易匹配条件,设置了规则时只使用规则
+func newTxMatcher(txWatcher TxWatcher) *txMatcher {
+	matcher := &txMatcher{rule: txRule(txWatcher)}
+	if matcher.rule == nil {
+		matcher.isInterestedTx = interestedTxMatcher(txWatcher)
+	}
+	if logWatcher, ok := txWatcher.(LogTxWatcher); ok {
+		matcher.isInterestedLogAddress = logWatcher.GetLogAddressMatcher()
+	}
+	if revertWatcher, ok := txWatcher.(RevertTxWatcher); ok {
+		matcher.revertReasonMode = revertWatcher.GetRevertReasonMode()
+		matcher.errorABIs = revertWatcher.GetErrorABIs()
+	}
+	if balanceWatcher, ok := txWatcher.(BalanceTxWatcher); ok {
+		matcher.balanceWatcher = balanceWatcher
+	}
+	if withdrawalWatcher, ok := txWatcher.(WithdrawalTxWatcher); ok && withdrawalWatcher.GetWithdrawalMatcher() != nil {
+		matcher.withdrawalWatcher = withdrawalWatcher
+		matcher.isInterestedWithdrawal = withdrawalWatcher.GetWithdrawalMatcher()
+	}
+	if nonceWatcher, ok := txWatcher.(NonceTxWatcher); ok {
+		matcher.nonceTracker = nonceWatcher.GetNonceTracker()
+	}
+	if gasWatcher, ok := txWatcher.(GasTxWatcher); ok {
+		matcher.gasAnalytics = gasWatcher.GetGasAnalytics()
+	}
+	if enrichWatcher, ok := txWatcher.(EnrichTxWatcher); ok {
+		matcher.enricher = enrichWatcher.GetEnricher()
+	}
+	if backfillWatcher, ok := txWatcher.(BackfillTxWatcher); ok {
+		matcher.backfillWatcher = backfillWatcher
+	}
+
+	return matcher
+}
+
+//获取watcher的tx匹配方法
+func interestedTxMatcher(txWatcher TxWatcher) func(from common.Address, to common.Address) (bool, error) {
+	if addressWatcher, ok := txWatcher.(AddressTxWatcher); ok {
+		return addressWatcher.IsInterestedTxAddress
+	}
+
+	return func(from common.Address, to common.Address) (bool, error) {
+		return txWatcher.IsInterestedTx(strings.ToLower(hexutil.Encode(from.Bytes())), strings.ToLower(hexutil.Encode(to.Bytes()))), nil
+	}
+}
+
+//扫描startBlock至endBlock(为0时扫描至最新区块)的交易
+//onBlock为nil时不回调区块扫描完成
+func (scanner *TxScanner) scanTx(startBlock uint64, endBlock uint64, matcher *txMatcher, onBlock func(*BlockInfo) error) (uint64, error) {
+	clients, err := scanner.txWatcher.GetEthClients()
 	if err != nil {
 		return 0, err
 	}
@@ -136,11 +683,13 @@ func scanTx(startBlock uint64) (uint64, error) {
 		defer clients[i].Close()
 	}
 
+	headers := newHeaderCache()
+
 	errorSleepSeconds := int64(10)
 	currBlock := startBlock
 	finishedBlock := startBlock - 1
-	for true {
-		avaiIndexes := RebuildAvaiIndexes(len(clients), &_clientSleepTimes)
+	for (endBlock == 0 || currBlock <= endBlock) && !scanner.isStopped() {
+		avaiIndexes := RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
 		if len(avaiIndexes) == 0 {
 			break
 		}
@@ -149,106 +698,233 @@ func scanTx(startBlock uint64) (uint64, error) {
 		client := clients[index]
 		LogToConsole("scaning block " + strconv.FormatUint(currBlock, 10) + " txs on client_" + strconv.Itoa(index) + "...")
 
-		block, err := client.BlockByNumber(context.Background(), new(big.Int).SetUint64(currBlock))
-		if err != nil {
-			if err.Error() == "not found" {
-				LogToConsole("block " + strconv.FormatUint(currBlock, 10) + " is not mined or not synced on client_" + strconv.Itoa(index) + ".")
-				break
-			}
-			_clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
-			avaiIndexes = RebuildAvaiIndexes(len(clients), &_clientSleepTimes)
-
-			LogToConsole("client_" + strconv.Itoa(index) + "response error,sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
-			continue
+		var balanceAddresses []common.Address
+		if matcher.balanceWatcher != nil {
+			balanceAddresses = matcher.balanceWatcher.GetBalanceAddresses()
 		}
 
-		if block == nil {
-			break
-		}
+		var block *Block
+		bloom, bloomSkipping := matcher.bloomQuery(scanner.txWatcher)
+		if bloomSkipping && len(balanceAddresses) == 0 {
+			header, err := headers.get(client, currBlock, endBlock)
+			if err != nil {
+				if err.Error() == "not found" {
+					LogToConsole("block " + strconv.FormatUint(currBlock, 10) + " is not mined or not synced on client_" + strconv.Itoa(index) + ".")
+					break
+				}
+				scanner.clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
+				avaiIndexes = RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
 
-		blockUnixSecs := block.Time()
-		txs := block.Transactions()
-		if txs==nil ||len(txs)==0{
-			finishedBlock = currBlock
-			currBlock++
-			continue
-		}
-		resolveTxError := false
-		for _, tx := range txs {
-			//skip contract creation tx
-			if tx.To() == nil {
+				LogToConsole("client_" + strconv.Itoa(index) + "response error,sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
+				continue
+			}
+			if !bloom.matches(header.header.Bloom) {
+				LogToConsole("block " + strconv.FormatUint(currBlock, 10) + " skipped by bloom.")
+				if onBlock != nil {
+					blockInfo := scanner.newBlockInfo(header.header, header.hash)
+					blockInfo.Skipped = true
+					err = onBlock(blockInfo)
+					if err != nil {
+						return finishedBlock, err
+					}
+				}
+				atomic.AddUint64(&scanner.scannedBlocks, 1)
+				atomic.AddUint64(&scanner.skippedBlocks, 1)
+				finishedBlock = currBlock
+				currBlock++
 				continue
 			}
 
-			txData := tx.Data()
-			signV, signR, signS := tx.RawSignatureValues()
-			//txChainID := tx.ChainId()
-			// if txChainID.Sign() != 0 {
-			// 	signV = big.NewInt(int64(signV.Bytes()[0] - 35))
-			// 	signV.Sub(signV, new(big.Int).Mul(txChainID, big.NewInt(2)))
-			// 	signV.Add(signV, big.NewInt(27))
-			// }
-			// if signV.String() != "27" && signV.String() != "28" {
-			// 	fmt.Println(signV.String())
-			// }
+			//按预检查的header的hash获取区块,确保与预检查的是同一区块
+			block, err = GetBlockByHash(client, header.hash, scanner.profile, scanner.signer)
+			if err != nil {
+				headers.reset()
+				scanner.clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
+				avaiIndexes = RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
 
-			fromAddr, err := _signer.Sender(tx)
+				LogToConsole("client_" + strconv.Itoa(index) + "response error,sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
+				continue
+			}
+		} else {
+			//不使用bloom预检查时丢弃预取的header,避免之后使用过期的header
+			headers.reset()
+			block, err = GetBlock(client, currBlock, scanner.profile, scanner.signer)
 			if err != nil {
-				return 0, err
+				if err.Error() == "not found" {
+					LogToConsole("block " + strconv.FormatUint(currBlock, 10) + " is not mined or not synced on client_" + strconv.Itoa(index) + ".")
+					break
+				}
+				scanner.clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
+				avaiIndexes = RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
+
+				LogToConsole("client_" + strconv.Itoa(index) + "response error,sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
+				continue
 			}
-			from := strings.ToLower(hexutil.Encode(fromAddr.Bytes()))
-			to := strings.ToLower(hexutil.Encode(tx.To().Bytes()))
-			methodId := ""
-			if txData != nil && len(txData) >= 4 {
-				methodId = hex.EncodeToString(txData[0:4])
+		}
+
+		//在匹配前取出回溯请求,新增地址从当前区块开始由实时扫描匹配
+		if matcher.backfillWatcher != nil {
+			scanner.claimBackfills(matcher.backfillWatcher, currBlock-1)
+		}
+		if matcher.atBlock != nil {
+			matcher.atBlock(currBlock)
+		}
+
+		//节点支持时一次获取区块内所有receipt,失败时逐笔获取
+		var receipts map[common.Hash]*types.Receipt
+		blockReceipts := !scanner.profile.RawBlocks && scanner.nodeInfo(index) != nil && scanner.nodeInfo(index).BlockReceipts
+		var logMatches map[common.Hash][]*types.Log
+		if matcher.isInterestedLogAddress != nil {
+			//按log匹配时优先使用区块receipt中的log,匹配的交易复用receipt,不再请求eth_getLogs
+			if blockReceipts {
+				receipts, err = GetBlockReceipts(client, block)
+				if err != nil {
+					LogToConsole("get block " + strconv.FormatUint(currBlock, 10) + " receipts on client_" + strconv.Itoa(index) + " error: " + err.Error())
+				}
 			}
-			if !_txWatcher.IsInterestedTx(from, to) {
-				continue
+			var logs []*types.Log
+			if receipts != nil {
+				logs = receiptLogs(block, receipts)
+			} else {
+				filterLogs, err := client.FilterLogs(context.Background(), ethereum.FilterQuery{BlockHash: &block.Hash})
+				if err != nil {
+					scanner.clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
+					avaiIndexes = RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
+
+					LogToConsole("client_" + strconv.Itoa(index) + "response error,sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
+					continue
+				}
+				logs = make([]*types.Log, len(filterLogs))
+				for i := range filterLogs {
+					logs[i] = &filterLogs[i]
+				}
 			}
-			txInfo := &TxInfo{
-				TxHash:        strings.ToLower(tx.Hash().Hex()),
-				From:          from,
-				Gas:           tx.Gas(),
-				GasPrice:      tx.GasPrice(),
-				Nonce:         tx.Nonce(),
-				To:            to,
-				Value:         tx.Value(),
-				V:             signV.Bytes(),
-				R:             signR.Bytes(),
-				S:             signS.Bytes(),
-				BlockHash:     strings.ToLower(block.Hash().Hex()),
-				BlockNumber:   block.Number(),
-				BlockUnixSecs: blockUnixSecs,
-				ChainID:       tx.ChainId(),
-				CallMethodID:  methodId,
-			}
-			if len(txData) > 4 {
-				txInfo.InputData = txData[4:]
-			}
-
-			receipt, err := client.TransactionReceipt(context.Background(), tx.Hash())
+			logMatches, err = matchLogs(logs, matcher.isInterestedLogAddress)
 			if err != nil {
-				_clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
-				avaiIndexes = RebuildAvaiIndexes(len(clients), &_clientSleepTimes)
+				return finishedBlock, err
+			}
+		}
 
-				LogToConsole("client_" + strconv.Itoa(index) + "reponse error,sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
-				resolveTxError = true
-				break
+		matchedTxs, err := matchTxs(block, matcher, logMatches)
+		if err != nil {
+			return finishedBlock, err
+		}
+		var withdrawals []*WithdrawalInfo
+		if matcher.withdrawalWatcher != nil {
+			withdrawals, err = matchWithdrawals(block, matcher.isInterestedWithdrawal)
+			if err != nil {
+				return finishedBlock, err
 			}
+		}
 
-			txInfo.receipt = receipt
-			txInfo.Status = receipt.Status
-			txInfo.TransactionIndex = receipt.TransactionIndex
-			txInfo.GasUsed = receipt.GasUsed
-			txInfo.CumulativeGasUsed = receipt.CumulativeGasUsed
+		if receipts == nil && len(matchedTxs) >= blockReceiptsMinTxs && blockReceipts {
+			receipts, err = GetBlockReceipts(client, block)
+			if err != nil {
+				LogToConsole("get block " + strconv.FormatUint(currBlock, 10) + " receipts on client_" + strconv.Itoa(index) + " error: " + err.Error())
+			}
+		}
 
-			err = _txWatcher.Callback(txInfo)
+		var balanceChanges []*BalanceChange
+		if len(balanceAddresses) > 0 {
+			balanceChanges, err = scanner.getBalanceChanges(client, block, balanceAddresses, receipts)
 			if err != nil {
-				return finishedBlock, err
+				scanner.clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
+				avaiIndexes = RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
+
+				LogToConsole("client_" + strconv.Itoa(index) + "response error,sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
+				continue
 			}
 		}
 
+		//回调前获取所有匹配交易的receipt和标注,出错时重新扫描区块不会重复回调
+		var txInfos []*TxInfo
+		resolveTxError := false
+		for _, tx := range matchedTxs {
+			txInfo := tx.TxInfo()
+			if receipt := receipts[tx.btx.Hash]; receipt != nil {
+				txInfo.SetReceipt(receipt)
+			} else {
+				err = LoadReceipt(client, txInfo, scanner.profile)
+				if err != nil {
+					scanner.clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
+					avaiIndexes = RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
+
+					LogToConsole("client_" + strconv.Itoa(index) + "reponse error,sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
+					resolveTxError = true
+					break
+				}
+			}
+			if tx.needsReceiptMatch {
+				matched, err := matcher.rule.Match(tx)
+				if err != nil {
+					return finishedBlock, err
+				}
+				if !matched {
+					continue
+				}
+			}
+			if txInfo.Status == types.ReceiptStatusFailed && matcher.revertReasonMode != RevertReasonNone {
+				txInfo.RevertReason, err = GetRevertReason(client, txInfo, matcher.revertReasonMode, matcher.errorABIs...)
+				if err != nil {
+					LogToConsole("get tx " + txInfo.TxHash + " revert reason error: " + err.Error())
+				}
+			}
+			if matcher.enricher != nil {
+				err = matcher.enricher.Enrich(client, txInfo)
+				if err != nil {
+					scanner.clientSleepTimes[index] = time.Now().UTC().Unix() + errorSleepSeconds
+					avaiIndexes = RebuildAvaiIndexes(len(clients), &scanner.clientSleepTimes)
+
+					LogToConsole("enrich tx " + txInfo.TxHash + " on client_" + strconv.Itoa(index) + " error: " + err.Error() + ",sleep " + strconv.FormatInt(errorSleepSeconds, 10) + "s.")
+					resolveTxError = true
+					break
+				}
+			}
+			txInfos = append(txInfos, txInfo)
+		}
+
 		if !resolveTxError {
+			for _, txInfo := range txInfos {
+				err = scanner.txWatcher.Callback(txInfo)
+				if err != nil {
+					return finishedBlock, err
+				}
+				//tx已回调,告警出错不影响区块扫描
+				if matcher.nonceTracker != nil {
+					err = matcher.nonceTracker.OnTx(txInfo)
+					if err != nil {
+						LogToConsole("nonce alert for tx " + txInfo.TxHash + " error: " + err.Error())
+					}
+				}
+			}
+			for _, withdrawal := range withdrawals {
+				err = matcher.withdrawalWatcher.OnWithdrawal(withdrawal)
+				if err != nil {
+					return finishedBlock, err
+				}
+			}
+			for _, change := range balanceChanges {
+				err = matcher.balanceWatcher.OnBalanceChange(change)
+				if err != nil {
+					return finishedBlock, err
+				}
+			}
+			if matcher.gasAnalytics != nil {
+				err = matcher.gasAnalytics.AddBlock(block)
+				if err != nil {
+					return finishedBlock, err
+				}
+			}
+			if onBlock != nil {
+				blockInfo := scanner.newBlockInfo(block.Header, block.Hash)
+				blockInfo.TxCount = len(block.Txs)
+				blockInfo.MatchedTxCount = len(txInfos)
+				err = onBlock(blockInfo)
+				if err != nil {
+					return finishedBlock, err
+				}
+			}
+			atomic.AddUint64(&scanner.scannedBlocks, 1)
 			finishedBlock = currBlock
 			currBlock++
 		}
@@ -257,76 +933,301 @@ func scanTx(startBlock uint64) (uint64, error) {
 	return finishedBlock, nil
 }
 
+//按区块内交易的顺序获取receipt中的log
+func receiptLogs(block *Block, receipts map[common.Hash]*types.Receipt) []*types.Log {
+	var logs []*types.Log
+	for _, btx := range block.Txs {
+		if receipt := receipts[btx.Hash]; receipt != nil {
+			logs = append(logs, receipt.Logs...)
+		}
+	}
+
+	return logs
+}
+
+//按log的indexed topic(topic1-3)中的地址匹配log,返回按交易hash索引的匹配log
+func matchLogs(logs []*types.Log, isInterestedLogAddress func(addr common.Address) (bool, error)) (map[common.Hash][]*types.Log, error) {
+	logMatches := make(map[common.Hash][]*types.Log)
+	for _, log := range logs {
+		for _, topic := range log.Topics[min(len(log.Topics), 1):] {
+			//地址topic的前12字节为0
+			if common.BytesToHash(topic[12:]) != topic {
+				continue
+			}
+			interested, err := isInterestedLogAddress(common.BytesToAddress(topic[12:]))
+			if err != nil {
+				return nil, err
+			}
+			if interested {
+				logMatches[log.TxHash] = append(logMatches[log.TxHash], log)
+				break
+			}
+		}
+	}
+
+	return logMatches, nil
+}
+
+//匹配区块内的交易(跳过合约创建交易),log匹配的交易不再检查地址,需要receipt才能判断的交易在获取receipt后再次匹配
+func matchTxs(block *Block, matcher *txMatcher, logMatches map[common.Hash][]*types.Log) ([]*RuleTx, error) {
+	var matchedTxs []*RuleTx
+	for _, btx := range block.Txs {
+		//skip contract creation tx
+		if btx.To == nil {
+			continue
+		}
+
+		matchedLogs := logMatches[btx.Hash]
+		if matcher.isInterestedTx != nil && len(matchedLogs) == 0 {
+			interested, err := matcher.isInterestedTx(btx.From, *btx.To)
+			if err != nil {
+				return nil, err
+			}
+			if !interested {
+				continue
+			}
+		}
+		tx := newRuleTx(block, btx)
+		tx.MatchedLogs = matchedLogs
+		if matcher.rule != nil {
+			matched, known, err := preMatch(matcher.rule, tx)
+			if err != nil {
+				return nil, err
+			}
+			if known && !matched {
+				continue
+			}
+			tx.needsReceiptMatch = !known
+		}
+		matchedTxs = append(matchedTxs, tx)
+	}
+
+	return matchedTxs, nil
+}
+
+//根据区块header构造区块扫描完成信息
+func NewBlockInfo(header *types.Header) *BlockInfo {
+	return &BlockInfo{
+		Header:        header,
+		BlockNumber:   header.Number.Uint64(),
+		BlockHash:     strings.ToLower(header.Hash().Hex()),
+		BlockUnixSecs: header.Time,
+		BaseFee:       header.BaseFee,
+		GasUsed:       header.GasUsed,
+		GasLimit:      header.GasLimit,
+	}
+}
+
+func (scanner *TxScanner) newBlockInfo(header *types.Header, blockHash common.Hash) *BlockInfo {
+	blockInfo := NewBlockInfo(header)
+	blockInfo.BlockHash = hashString(blockHash)
+	if scanner.profile.Consensus != ConsensusEthereum {
+		signer, err := scanner.profile.BlockSigner(header)
+		if err != nil {
+			LogToConsole("recover block " + blockInfo.Header.Number.String() + " signer error: " + err.Error())
+		} else {
+			blockInfo.Signer = addressString(signer)
+		}
+	}
+
+	return blockInfo
+}
+
+//构造用于恢复交易发送者的signer
+func NewSigner(chainID *big.Int) types.Signer {
+	return types.LatestSignerForChainID(chainID)
+}
+
+//根据区块内的交易构造tx信息,receipt相关字段需调用SetReceipt设置
+func NewTxInfo(block *types.Block, tx *types.Transaction, from common.Address) *TxInfo {
+	return newTxInfo(block.Header(), block.Hash(), tx, from)
+}
+
+func newTxInfo(header *types.Header, blockHash common.Hash, tx *types.Transaction, from common.Address) *TxInfo {
+	signV, signR, signS := tx.RawSignatureValues()
+	//txChainID := tx.ChainId()
+	// if txChainID.Sign() != 0 {
+	// 	signV = big.NewInt(int64(signV.Bytes()[0] - 35))
+	// 	signV.Sub(signV, new(big.Int).Mul(txChainID, big.NewInt(2)))
+	// 	signV.Add(signV, big.NewInt(27))
+	// }
+	// if signV.String() != "27" && signV.String() != "28" {
+	// 	fmt.Println(signV.String())
+	// }
+
+	to := ""
+	if tx.To() != nil {
+		to = addressString(*tx.To())
+	}
+	txInfo := &TxInfo{
+		TxHash:        hashString(tx.Hash()),
+		Type:          tx.Type(),
+		From:          addressString(from),
+		Gas:           tx.Gas(),
+		GasPrice:      tx.GasPrice(),
+		Nonce:         tx.Nonce(),
+		To:            to,
+		Value:         tx.Value(),
+		V:             signV.Bytes(),
+		R:             signR.Bytes(),
+		S:             signS.Bytes(),
+		BlockHash:     hashString(blockHash),
+		BlockNumber:   header.Number,
+		BlockUnixSecs: header.Time,
+		ChainID:       tx.ChainId(),
+	}
+	setInputData(txInfo, tx.Data())
+	if tx.Type() == types.BlobTxType {
+		txInfo.BlobVersionedHashes = hashStrings(tx.BlobHashes())
+		txInfo.BlobGasFeeCap = tx.BlobGasFeeCap()
+	}
+
+	return txInfo
+}
+
+//设置方法id和去掉方法id的input
+func setInputData(txInfo *TxInfo, txData []byte) {
+	if len(txData) >= 4 {
+		txInfo.CallMethodID = hex.EncodeToString(txData[0:4])
+	}
+	if len(txData) > 4 {
+		txInfo.InputData = txData[4:]
+	}
+}
+
+func hashString(hash common.Hash) string {
+	return strings.ToLower(hash.Hex())
+}
+
+func hashStrings(hashes []common.Hash) []string {
+	result := make([]string, len(hashes))
+	for i, hash := range hashes {
+		result[i] = hashString(hash)
+	}
+
+	return result
+}
+
+func addressString(addr common.Address) string {
+	return strings.ToLower(hexutil.Encode(addr.Bytes()))
+}
+
+//设置tx的receipt及相关字段
+func (tx *TxInfo) SetReceipt(receipt *types.Receipt) {
+	tx.receipt = receipt
+	tx.Status = receipt.Status
+	tx.TransactionIndex = receipt.TransactionIndex
+	tx.GasUsed = receipt.GasUsed
+	tx.CumulativeGasUsed = receipt.CumulativeGasUsed
+	tx.BlobGasUsed = receipt.BlobGasUsed
+	tx.BlobGasPrice = receipt.BlobGasPrice
+}
+
+//获取包含方法id的完整input
+func (tx *TxInfo) Input() []byte {
+	methodID, err := hex.DecodeString(tx.CallMethodID)
+	if err != nil {
+		return nil
+	}
+
+	return append(methodID, tx.InputData...)
+}
+
+//获取tx的receipt
+func (tx *TxInfo) Receipt() *types.Receipt {
+	return tx.receipt
+}
+
+//获取默认扫描器的扫描统计
+func GetScanStats() ScanStats {
+	_defaultScannerLock.Lock()
+	scanner := _defaultScanner
+	_defaultScannerLock.Unlock()
+	if scanner == nil {
+		return ScanStats{}
+	}
+
+	return scanner.GetScanStats()
+}
+
+//获取扫描统计
+func (scanner *TxScanner) GetScanStats() ScanStats {
+	return ScanStats{
+		ScannedBlocks: atomic.LoadUint64(&scanner.scannedBlocks),
+		SkippedBlocks: atomic.LoadUint64(&scanner.skippedBlocks),
+	}
+}
+
+//获取已扫描的最大区块号
+func (scanner *TxScanner) GetLastScanedBlock() uint64 {
+	return atomic.LoadUint64(&scanner.lastScanedBlockNumber)
+}
+
+//获取链id,开始扫描前为nil
+func (scanner *TxScanner) GetChainID() *big.Int {
+	return scanner.chainID
+}
+
+//停止扫描,Start在当前区块处理完后返回
+func (scanner *TxScanner) Stop() {
+	scanner.stopOnce.Do(func() { close(scanner.stop) })
+}
+
+//重置停止状态,Stop后需要再次Start时调用,只能在Start返回后调用
+func (scanner *TxScanner) Reset() {
+	scanner.stop = make(chan struct{})
+	scanner.stopOnce = sync.Once{}
+}
+
+func (scanner *TxScanner) isStopped() bool {
+	select {
+	case <-scanner.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//睡眠指定时间,调用Stop时提前返回
+func (scanner *TxScanner) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-scanner.stop:
+	case <-timer.C:
+	}
+}
+
+func (scanner *TxScanner) setLastScanedBlock(blockNumber uint64) {
+	atomic.StoreUint64(&scanner.lastScanedBlockNumber, blockNumber)
+}
+
+//bloom预检查跳过的区块比例
+func (stats ScanStats) SkipRate() float64 {
+	if stats.ScannedBlocks == 0 {
+		return 0
+	}
+
+	return float64(stats.SkippedBlocks) / float64(stats.ScannedBlocks)
+}
+
 //获取tx logs
 func (tx *TxInfo) Logs() []*types.Log {
+	if tx.receipt == nil {
+		return nil
+	}
+
 	return tx.receipt.Logs
 }
 
-//获取tx的json形式
+//获取tx的json形式,格式见MarshalJSON
 func (tx *TxInfo) JSON() string {
-	var sb strings.Builder
-	sb.WriteString(`{"TxHash":"`)
-	sb.WriteString(tx.TxHash)
-	sb.WriteString(`",`)
-	sb.WriteString(`"BlockHash":"`)
-	sb.WriteString(tx.BlockHash)
-	sb.WriteString(`",`)
-	sb.WriteString(`"BlockNumber":`)
-	sb.WriteString(tx.BlockNumber.String())
-	sb.WriteString(`,`)
-	sb.WriteString(`"BlockUnixSecs":`)
-	sb.WriteString(strconv.FormatUint(tx.BlockUnixSecs, 10))
-	sb.WriteString(`,`)
-	sb.WriteString(`"From":"`)
-	sb.WriteString(tx.From)
-	sb.WriteString(`",`)
-	sb.WriteString(`"Gas":`)
-	sb.WriteString(strconv.FormatUint(tx.Gas, 10))
-	sb.WriteString(`,`)
-	sb.WriteString(`"GasPrice":`)
-	sb.WriteString(tx.GasPrice.String())
-	sb.WriteString(`,`)
-	sb.WriteString(`"InputData":"`)
-	sb.WriteString(hex.EncodeToString(tx.InputData))
-	sb.WriteString(`",`)
-	sb.WriteString(`"Nonce":`)
-	sb.WriteString(strconv.FormatUint(tx.Nonce, 10))
-	sb.WriteString(`,`)
-	sb.WriteString(`"To":"`)
-	sb.WriteString(tx.To)
-	sb.WriteString(`",`)
-	sb.WriteString(`"Value":`)
-	sb.WriteString(tx.Value.String())
-	sb.WriteString(`,`)
-	sb.WriteString(`"V":"`)
-	sb.WriteString(hex.EncodeToString(tx.V))
-	sb.WriteString(`",`)
-	sb.WriteString(`"R":"`)
-	sb.WriteString(hex.EncodeToString(tx.R))
-	sb.WriteString(`",`)
-	sb.WriteString(`"S":"`)
-	sb.WriteString(hex.EncodeToString(tx.S))
-	sb.WriteString(`",`)
-	sb.WriteString(`"ChainID":`)
-	sb.WriteString(tx.ChainID.String())
-	sb.WriteString(`,`)
-	sb.WriteString(`"CallMethodID":"`)
-	sb.WriteString(tx.CallMethodID)
-	sb.WriteString(`",`)
-	sb.WriteString(`"Status":`)
-	sb.WriteString(strconv.FormatUint(tx.Status, 10))
-	sb.WriteString(`,`)
-	sb.WriteString(`"TransactionIndex":`)
-	sb.WriteString(strconv.FormatUint(uint64(tx.TransactionIndex), 10))
-	sb.WriteString(`,`)
-	sb.WriteString(`"GasUsed":`)
-	sb.WriteString(strconv.FormatUint(tx.GasUsed, 10))
-	sb.WriteString(`,`)
-	sb.WriteString(`"CumulativeGasUsed":`)
-	sb.WriteString(strconv.FormatUint(tx.CumulativeGasUsed, 10))
-	sb.WriteString(`}`)
-
-	return sb.String()
+	bytes, err := tx.MarshalJSON()
+	if err != nil {
+		return ""
+	}
+
+	return string(bytes)
 }
 
 func LogToConsole(msg string) {
@@ -0,0 +1,810 @@
+package txscanner_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/warrior21st/ethblockscanner/addrset"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/rpcnode"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+const scanTimeout = 20 * time.Second
+
+var (
+	depositAddr   = common.HexToAddress("0x00000000000000000000000000000000000000d1")
+	tokenAddr     = common.HexToAddress("0x00000000000000000000000000000000000000e1")
+	transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+)
+
+//记录回调的tx,可并发调用
+type txRecorder struct {
+	lock sync.Mutex
+	txs  []*txscanner.TxInfo
+}
+
+func (recorder *txRecorder) callback(tx *txscanner.TxInfo) error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.txs = append(recorder.txs, tx)
+
+	return nil
+}
+
+func (recorder *txRecorder) hashes() []string {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	hashes := make([]string, len(recorder.txs))
+	for i, tx := range recorder.txs {
+		hashes[i] = tx.TxHash
+	}
+
+	return hashes
+}
+
+func hexHash(tx *types.Transaction) string {
+	return strings.ToLower(tx.Hash().Hex())
+}
+
+func hexAddress(addr common.Address) string {
+	return strings.ToLower(addr.Hex())
+}
+
+func newWatcher(t *testing.T, chain *fakechain.Chain, servers int, recorder *txRecorder) (*txscanner.SimpleTxWatcher, []*fakechain.Server) {
+	started, endpoints, closeAll := fakechain.NewServers(chain, servers)
+	t.Cleanup(closeAll)
+	watcher := txscanner.NewSimpleTxWatcher(endpoints, 1, 10*time.Millisecond, recorder.callback)
+
+	return watcher, started
+}
+
+func assertHashes(t *testing.T, got []string, want ...*types.Transaction) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d txs, want %d", len(got), len(want))
+	}
+	for i, tx := range want {
+		if got[i] != hexHash(tx) {
+			t.Fatalf("tx %d: got %s, want %s", i, got[i], hexHash(tx))
+		}
+	}
+}
+
+func TestScanTxMatchesInterestedAddresses(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(100))
+	chain.Transfer(2, fakechain.Address(3), big.NewInt(1))
+	chain.Mine()
+	withdraw := chain.AddTx(4, &tokenAddr, nil, []byte{0xa9, 0x05, 0x9c, 0xbb, 0x01}, fakechain.NewLog(tokenAddr, []common.Hash{transferTopic}, []byte{0x02}))
+	chain.AddTx(5, nil, nil, []byte{0x60, 0x80})
+	chain.Mine()
+	chain.MineN(2)
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	watcher.AddInterestedFrom(hexAddress(fakechain.Address(4)))
+	//合约创建交易总是被跳过
+	watcher.AddInterestedFrom(hexAddress(fakechain.Address(5)))
+
+	scanner := txscanner.NewTxScanner(watcher)
+	err := fakechain.ScanTo(scanner, chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit, withdraw)
+
+	tx := recorder.txs[0]
+	if tx.From != hexAddress(fakechain.Address(1)) || tx.To != hexAddress(depositAddr) || tx.Value.Int64() != 100 {
+		t.Fatalf("unexpected deposit %+v", tx)
+	}
+	if tx.BlockNumber.Uint64() != 1 || tx.BlockHash != strings.ToLower(chain.BlockByNumber(1).Hash().Hex()) || tx.BlockUnixSecs != chain.BlockByNumber(1).Time() {
+		t.Fatalf("unexpected deposit block %+v", tx)
+	}
+	if tx.Status != types.ReceiptStatusSuccessful || tx.GasUsed != fakechain.TransferGas || tx.ChainID.Int64() != 1 || tx.Type != types.DynamicFeeTxType {
+		t.Fatalf("unexpected deposit receipt %+v", tx)
+	}
+
+	tx = recorder.txs[1]
+	if tx.CallMethodID != "a9059cbb" || len(tx.InputData) != 1 || tx.InputData[0] != 0x01 {
+		t.Fatalf("unexpected input %s %x", tx.CallMethodID, tx.InputData)
+	}
+	if len(tx.Logs()) != 1 || tx.Logs()[0].Topics[0] != transferTopic {
+		t.Fatalf("unexpected logs %v", tx.Logs())
+	}
+	if scanner.GetChainID().Int64() != 1 {
+		t.Fatalf("chain id %s", scanner.GetChainID())
+	}
+}
+
+func TestScanTxFailedTxStatus(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	failed := chain.AddFailedTx(1, &depositAddr, big.NewInt(1), nil)
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), failed)
+	if recorder.txs[0].Status != types.ReceiptStatusFailed {
+		t.Fatalf("status %d", recorder.txs[0].Status)
+	}
+}
+
+func TestScanTxBlockReceipts(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	first := chain.Transfer(1, depositAddr, big.NewInt(1))
+	failed := chain.AddFailedTx(2, &depositAddr, big.NewInt(1), nil)
+	chain.Mine()
+	single := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, servers := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	watcher.SetNodeDetection(true)
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), first, failed, single)
+	if recorder.txs[0].Status != types.ReceiptStatusSuccessful || recorder.txs[1].Status != types.ReceiptStatusFailed || recorder.txs[1].TransactionIndex != 1 {
+		t.Fatalf("unexpected receipts %+v %+v", recorder.txs[0], recorder.txs[1])
+	}
+	//区块1的2笔交易使用eth_getBlockReceipts(另有1次为探测),区块2只有1笔交易逐笔获取
+	if servers[0].Calls("eth_getBlockReceipts") != 2 || servers[0].Calls("eth_getTransactionReceipt") != 1 {
+		t.Fatalf("calls %d %d", servers[0].Calls("eth_getBlockReceipts"), servers[0].Calls("eth_getTransactionReceipt"))
+	}
+}
+
+func TestNodeDetectionRetriesFailedNodes(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	watcher, servers := newWatcher(t, chain, 2, &txRecorder{})
+	watcher.SetNodeDetection(true)
+	servers[1].FailNext("web3_clientVersion", 1, "node starting")
+
+	infos := watcher.GetNodeInfos()
+	if infos[0] == nil || infos[1] != nil {
+		t.Fatalf("unexpected first detection %v", infos)
+	}
+	//已探测成功的节点不再探测
+	infos = watcher.GetNodeInfos()
+	if infos[1] == nil || !infos[1].BlockReceipts {
+		t.Fatalf("unexpected retried detection %v", infos)
+	}
+	if servers[0].Calls("web3_clientVersion") != 1 || servers[1].Calls("web3_clientVersion") != 2 {
+		t.Fatalf("calls %d %d", servers[0].Calls("web3_clientVersion"), servers[1].Calls("web3_clientVersion"))
+	}
+}
+
+func TestScanTxSwitchesClientOnError(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	var deposits []*types.Transaction
+	for i := 0; i < 4; i++ {
+		deposits = append(deposits, chain.Transfer(1, depositAddr, big.NewInt(1)))
+		chain.Mine()
+	}
+
+	recorder := &txRecorder{}
+	watcher, servers := newWatcher(t, chain, 3, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	servers[0].FailNext("eth_getBlockByNumber", -1, "internal error")
+	servers[1].FailNext("eth_getTransactionReceipt", 1, "internal error")
+
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposits...)
+}
+
+func TestScanTxRateLimitedAndSlowClients(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.MineN(5)
+
+	recorder := &txRecorder{}
+	watcher, servers := newWatcher(t, chain, 2, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	servers[0].SetRateLimit(1)
+	servers[1].SetLatency(5 * time.Millisecond)
+
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit)
+}
+
+func TestScanTxBloomSkipsBlocks(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.MineN(3)
+	transfer := chain.AddTx(1, &tokenAddr, nil, nil, fakechain.NewLog(tokenAddr, []common.Hash{transferTopic}, nil))
+	//同一区块内其他合约的Transfer log不匹配
+	chain.AddTx(2, &depositAddr, nil, nil, fakechain.NewLog(depositAddr, []common.Hash{transferTopic}, nil))
+	chain.Mine()
+	chain.Transfer(3, tokenAddr, big.NewInt(1))
+	chain.Mine()
+	chain.MineN(40)
+
+	recorder := &txRecorder{}
+	watcher, servers := newWatcher(t, chain, 1, recorder)
+	watcher.SetTxRule(txscanner.LogEmitted(hexAddress(tokenAddr), transferTopic.Hex()))
+	watcher.SetBloomSkipping(true)
+	var blocks []*txscanner.BlockInfo
+	watcher.SetOnBlock(func(block *txscanner.BlockInfo) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+
+	scanner := txscanner.NewTxScanner(watcher)
+	err := fakechain.ScanTo(scanner, chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), transfer)
+
+	stats := scanner.GetScanStats()
+	if stats.ScannedBlocks != 45 || stats.SkippedBlocks != 44 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if len(blocks) != 45 {
+		t.Fatalf("got %d block callbacks", len(blocks))
+	}
+	for i, block := range blocks {
+		if block.BlockNumber != uint64(i+1) || block.Skipped != (block.BlockNumber != 4) {
+			t.Fatalf("unexpected block %+v", block)
+		}
+	}
+	if blocks[3].MatchedTxCount != 1 || blocks[3].TxCount != 2 || blocks[3].BlockHash != strings.ToLower(chain.BlockByNumber(4).Hash().Hex()) {
+		t.Fatalf("unexpected block %+v", blocks[3])
+	}
+	if blocks[4].BlockHash != strings.ToLower(chain.BlockByNumber(5).Hash().Hex()) {
+		t.Fatalf("unexpected skipped block %+v", blocks[4])
+	}
+	//只有未跳过的区块按预检查的header的hash获取区块内容
+	if calls := servers[0].Calls("eth_getBlockByHash"); calls != 1 {
+		t.Fatalf("got %d eth_getBlockByHash calls", calls)
+	}
+}
+
+func TestScanTxBloomKeepsAddressMatches(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.MineN(2)
+	//转入关注地址的普通转账不产生log
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	watcher.SetBloomSkipping(true)
+	//规则中有不需要log即可匹配的分支时同样不跳过
+	watcher.SetTxRule(txscanner.Or(txscanner.LogEmitted(hexAddress(tokenAddr), ""), txscanner.InterestedRule(watcher)))
+
+	scanner := txscanner.NewTxScanner(watcher)
+	err := fakechain.ScanTo(scanner, chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit)
+	if stats := scanner.GetScanStats(); stats.SkippedBlocks != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestScanTxCheckpointErrorRescans(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	var lock sync.Mutex
+	var checkpoints []uint64
+	failed := false
+	watcher.SetUpdateMaxScanedBlock(func(blockNumber uint64) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if !failed {
+			failed = true
+			return errors.New("commit failed")
+		}
+		checkpoints = append(checkpoints, blockNumber)
+		return nil
+	})
+
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit, deposit)
+	if len(checkpoints) != 1 || checkpoints[0] != 1 {
+		t.Fatalf("unexpected checkpoints %v", checkpoints)
+	}
+}
+
+func TestScanTxCallbackErrorRetries(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	calls := 0
+	_, endpoints, closeAll := fakechain.NewServers(chain, 1)
+	defer closeAll()
+	watcher := txscanner.NewSimpleTxWatcher(endpoints, 1, 10*time.Millisecond, func(tx *txscanner.TxInfo) error {
+		calls++
+		if calls == 1 {
+			return errors.New("callback failed")
+		}
+		return recorder.callback(tx)
+	})
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit)
+}
+
+func TestScanTxBackfillsNewAddress(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	early := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.MineN(3)
+
+	//回溯扫描的回调阻塞到实时扫描回调新交易之后,确认回溯不阻塞实时扫描
+	release := make(chan struct{})
+	recorder := &txRecorder{}
+	_, endpoints, closeAll := fakechain.NewServers(chain, 1)
+	t.Cleanup(closeAll)
+	watcher := txscanner.NewSimpleTxWatcher(endpoints, 1, 10*time.Millisecond, func(tx *txscanner.TxInfo) error {
+		if tx.TxHash == hexHash(early) {
+			<-release
+		}
+		return recorder.callback(tx)
+	})
+	scanner := txscanner.NewTxScanner(watcher)
+	done := make(chan error, 1)
+	go func() {
+		done <- scanner.Start()
+	}()
+	waitBlock(t, scanner, 3)
+
+	err := watcher.AddInterestedToWithBackfill(hexAddress(depositAddr), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.Mine()
+	waitHashes(t, recorder, 1)
+	close(release)
+	waitHashes(t, recorder, 2)
+	scanner.Stop()
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//回溯扫描到加入地址前的区块为止,每笔tx只回调一次
+	assertHashes(t, recorder.hashes(), late, early)
+}
+
+func TestScanTxBackfillMergesAndCancelsRequests(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	first := chain.Transfer(1, fakechain.Address(7), big.NewInt(1))
+	second := chain.Transfer(2, fakechain.Address(8), big.NewInt(1))
+	chain.Mine()
+	chain.Transfer(3, fakechain.Address(9), big.NewInt(1))
+	chain.MineN(2)
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	scanner := txscanner.NewTxScanner(watcher)
+	done := make(chan error, 1)
+	go func() {
+		done <- scanner.Start()
+	}()
+	waitBlock(t, scanner, 3)
+
+	for i := 7; i <= 9; i++ {
+		err := watcher.AddInterestedToWithBackfill(hexAddress(fakechain.Address(i)), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//移除的地址不再回溯
+	err := watcher.RemoveInterestedTo(hexAddress(fakechain.Address(9)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.Mine()
+	waitHashes(t, recorder, 2)
+	waitBlock(t, scanner, 4)
+	scanner.Stop()
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), first, second)
+}
+
+func TestBackfillAddressMatchesAfterClaim(t *testing.T) {
+	watcher := txscanner.NewSimpleTxWatcher(nil, 1, time.Second, nil)
+	err := watcher.AddInterestedToWithBackfill(hexAddress(depositAddr), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//回溯请求取出前新增地址不参与匹配,回溯扫描会覆盖取出前的区块
+	b, _ := watcher.IsInterestedTxAddress(common.Address{}, depositAddr)
+	if b {
+		t.Fatal("address matched before its backfill was claimed")
+	}
+	if len(watcher.PopBackfillRequests()) != 1 {
+		t.Fatal("backfill request not queued")
+	}
+	b, _ = watcher.IsInterestedTxAddress(common.Address{}, depositAddr)
+	if !b {
+		t.Fatal("address not matched after its backfill was claimed")
+	}
+}
+
+func TestScanTxBackfillWhileScanningDeliversOnce(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	addresses := []common.Address{fakechain.Address(10), fakechain.Address(11), fakechain.Address(12), fakechain.Address(13)}
+	var txs []*types.Transaction
+	for i := 0; i < 40; i++ {
+		for j, addr := range addresses {
+			txs = append(txs, chain.Transfer(j+1, addr, big.NewInt(1)))
+		}
+		chain.Mine()
+	}
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	scanner := txscanner.NewTxScanner(watcher)
+	done := make(chan error, 1)
+	go func() {
+		done <- scanner.Start()
+	}()
+	//实时扫描进行中加入地址,每笔tx由实时扫描或回溯扫描回调一次
+	for _, addr := range addresses {
+		time.Sleep(5 * time.Millisecond)
+		err := watcher.AddInterestedToWithBackfill(hexAddress(addr), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitHashes(t, recorder, len(txs))
+	chain.Mine()
+	waitBlock(t, scanner, chain.Head())
+	scanner.Stop()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for _, hash := range recorder.hashes() {
+		counts[hash]++
+	}
+	for _, tx := range txs {
+		if counts[hexHash(tx)] != 1 {
+			t.Fatalf("tx %s delivered %d times", hexHash(tx), counts[hexHash(tx)])
+		}
+	}
+}
+
+//等待回调的tx达到n笔
+func waitHashes(t *testing.T, recorder *txRecorder, n int) {
+	t.Helper()
+	deadline := time.Now().Add(scanTimeout)
+	for len(recorder.hashes()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %d txs, got %d", n, len(recorder.hashes()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitBlock(t *testing.T, scanner fakechain.Scanner, blockNumber uint64) {
+	t.Helper()
+	deadline := time.Now().Add(scanTimeout)
+	for scanner.GetLastScanedBlock() < blockNumber {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for block %d", blockNumber)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func containsHash(hashes []string, tx *types.Transaction) bool {
+	for _, hash := range hashes {
+		if hash == hexHash(tx) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestScanTxRawBlocksProfile(t *testing.T) {
+	chain := fakechain.NewChain(10)
+	deposit := chain.Transfer(1, depositAddr, big.NewInt(7))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	var blocks []*txscanner.BlockInfo
+	watcher.SetOnBlock(func(block *txscanner.BlockInfo) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+
+	//链id 10为Optimism,使用原始rpc数据解析区块
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit)
+	tx := recorder.txs[0]
+	if tx.From != hexAddress(fakechain.Address(1)) || tx.Value.Int64() != 7 || tx.Status != types.ReceiptStatusSuccessful || tx.L1Fee != nil {
+		t.Fatalf("unexpected tx %+v", tx)
+	}
+	if tx.BlockHash != strings.ToLower(chain.BlockByNumber(1).Hash().Hex()) || blocks[0].BlockHash != tx.BlockHash {
+		t.Fatalf("unexpected block hash %s", tx.BlockHash)
+	}
+}
+
+func TestBackfillerResumesShards(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	var deposits []*types.Transaction
+	for i := 0; i < 10; i++ {
+		deposits = append(deposits, chain.Transfer(1, depositAddr, big.NewInt(1)))
+		chain.Mine()
+	}
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 2, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	progress := txscanner.NewMemoryBackfillProgress()
+	//前两个分片已完成
+	progress.UpdateShardProgress(1, 3, 3)
+	progress.UpdateShardProgress(4, 6, 6)
+
+	backfiller := txscanner.NewBackfiller(watcher, 3)
+	backfiller.SetProgressStore(progress)
+	err := backfiller.Run(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := recorder.hashes()
+	if len(hashes) != 4 {
+		t.Fatalf("got %d txs, want 4", len(hashes))
+	}
+	for _, deposit := range deposits[6:] {
+		if !containsHash(hashes, deposit) {
+			t.Fatalf("missing tx %s", hexHash(deposit))
+		}
+	}
+
+	err = backfiller.Run(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.hashes()) != 4 {
+		t.Fatal("finished shards were scanned again")
+	}
+}
+
+func TestSplitShards(t *testing.T) {
+	shards := txscanner.SplitShards(5, 14, 4)
+	want := [][2]uint64{{5, 8}, {9, 12}, {13, 14}}
+	if len(shards) != len(want) {
+		t.Fatalf("got %d shards", len(shards))
+	}
+	for i, shard := range shards {
+		if shard.FromBlock != want[i][0] || shard.ToBlock != want[i][1] {
+			t.Fatalf("shard %d: %+v", i, shard)
+		}
+	}
+}
+
+func TestScanTxMatchesLogAddress(t *testing.T) {
+	other := common.HexToAddress("0x00000000000000000000000000000000000000f1")
+	approvalTopic := common.HexToHash("0x8c5be1e5ebec7d5bd14f71427e945b9bf7c8e1c1d6c32b8a19d1d4a6d4b9d9a7")
+	chain := fakechain.NewChain(1)
+	deposit := chain.AddTx(1, &tokenAddr, nil, nil,
+		fakechain.NewLog(tokenAddr, []common.Hash{approvalTopic, common.BytesToHash(fakechain.Address(1).Bytes()), common.BytesToHash(other.Bytes())}, nil),
+		fakechain.NewLog(tokenAddr, []common.Hash{transferTopic, common.BytesToHash(fakechain.Address(1).Bytes()), common.BytesToHash(depositAddr.Bytes())}, nil),
+	)
+	chain.AddTx(2, &tokenAddr, nil, nil,
+		fakechain.NewLog(tokenAddr, []common.Hash{transferTopic, common.BytesToHash(fakechain.Address(2).Bytes()), common.BytesToHash(other.Bytes())}, nil),
+	)
+	direct := chain.Transfer(3, depositAddr, big.NewInt(1))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	watcher.SetLogMatching(true)
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit, direct)
+	matched := recorder.txs[0].MatchedLogs
+	if len(matched) != 1 || matched[0].Index != 1 || len(recorder.txs[1].MatchedLogs) != 0 {
+		t.Fatalf("matched logs %+v", matched)
+	}
+
+	decoded := &txscanner.TxInfo{}
+	err = decoded.UnmarshalJSON([]byte(recorder.txs[0].JSON()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.MatchedLogs) != 1 || decoded.MatchedLogs[0].Index != 1 {
+		t.Fatalf("decoded matched logs %+v", decoded.MatchedLogs)
+	}
+}
+
+func TestScanTxLogMatchingUsesBlockReceipts(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	deposit := chain.AddTx(1, &tokenAddr, nil, nil,
+		fakechain.NewLog(tokenAddr, []common.Hash{transferTopic, common.BytesToHash(fakechain.Address(1).Bytes()), common.BytesToHash(depositAddr.Bytes())}, nil),
+	)
+	direct := chain.Transfer(3, depositAddr, big.NewInt(1))
+	chain.Mine()
+
+	recorder := &txRecorder{}
+	watcher, servers := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	watcher.SetLogMatching(true)
+	watcher.SetNodeDetection(true)
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit, direct)
+	if len(recorder.txs[0].MatchedLogs) != 1 || recorder.txs[0].Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("unexpected deposit %+v", recorder.txs[0])
+	}
+	//log和receipt都来自eth_getBlockReceipts(另有1次为探测)
+	if servers[0].Calls("eth_getLogs") != 0 || servers[0].Calls("eth_getTransactionReceipt") != 0 || servers[0].Calls("eth_getBlockReceipts") != 2 {
+		t.Fatalf("calls %d %d %d", servers[0].Calls("eth_getLogs"), servers[0].Calls("eth_getTransactionReceipt"), servers[0].Calls("eth_getBlockReceipts"))
+	}
+}
+
+func TestScanTxBloomSkipsBlocksWithoutLogAddresses(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	chain.MineN(2)
+	deposit := chain.AddTx(1, &tokenAddr, nil, nil,
+		fakechain.NewLog(tokenAddr, []common.Hash{transferTopic, common.BytesToHash(fakechain.Address(1).Bytes()), common.BytesToHash(depositAddr.Bytes())}, nil),
+	)
+	chain.Mine()
+	//转给非关注地址的Transfer被bloom跳过
+	chain.AddTx(2, &tokenAddr, nil, nil,
+		fakechain.NewLog(tokenAddr, []common.Hash{transferTopic, common.BytesToHash(fakechain.Address(2).Bytes()), common.BytesToHash(fakechain.Address(4).Bytes())}, nil),
+	)
+	chain.Mine()
+	chain.MineN(2)
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	watcher.SetLogMatching(true)
+	watcher.SetTxRule(txscanner.LogMatched())
+	watcher.SetBloomSkipping(true)
+	scanner := txscanner.NewTxScanner(watcher)
+	err := fakechain.ScanTo(scanner, chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), deposit)
+	if stats := scanner.GetScanStats(); stats.ScannedBlocks != 6 || stats.SkippedBlocks != 5 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	//不可枚举的地址集合不跳过区块
+	watcher, _ = newWatcher(t, chain, 1, &txRecorder{})
+	watcher.SetInterestedToSet(addrset.NewBloomSet(addrset.NewMemorySet(depositAddr), addrset.NewBloomFilter(100, 0.01), depositAddr))
+	watcher.SetLogMatching(true)
+	watcher.SetTxRule(txscanner.LogMatched())
+	watcher.SetBloomSkipping(true)
+	scanner = txscanner.NewTxScanner(watcher)
+	err = fakechain.ScanTo(scanner, chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := scanner.GetScanStats(); stats.SkippedBlocks != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestScanTxResetRestartsAfterStop(t *testing.T) {
+	chain := fakechain.NewChain(1)
+	first := chain.Transfer(1, depositAddr, big.NewInt(1))
+	chain.MineN(2)
+
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(depositAddr))
+	scanner := txscanner.NewTxScanner(watcher)
+	err := fakechain.ScanTo(scanner, chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := chain.Transfer(2, depositAddr, big.NewInt(2))
+	chain.MineN(2)
+	scanner.Reset()
+	err = fakechain.ScanTo(scanner, chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, recorder.hashes(), first, second)
+}
+
+//统计接受的连接数的listener
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (listener *countingListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err == nil {
+		listener.accepted.Add(1)
+	}
+
+	return conn, err
+}
+
+func TestGetEthClientsReusesIPCConnection(t *testing.T) {
+	server := fakechain.NewServer(fakechain.NewChain(1))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "geth.ipc")
+	unixListener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &countingListener{Listener: unixListener}
+	server.ServeListener(listener)
+
+	watcher := txscanner.NewSimpleTxWatcher(nil, 1, time.Second, nil)
+	watcher.SetEndpoints([]*rpcnode.Endpoint{rpcnode.IPCEndpoint(path, time.Second)})
+	for i := 0; i < 20; i++ {
+		clients, err := watcher.GetEthClients()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = clients[0].BlockNumber(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[0].Close()
+	}
+	if listener.accepted.Load() != 1 {
+		t.Fatalf("opened %d ipc connections, want 1", listener.accepted.Load())
+	}
+}
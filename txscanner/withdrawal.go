@@ -0,0 +1,70 @@
+package txscanner
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+//回调信标链提款的watcher,提款不产生交易,提款地址匹配时在区块内所有tx回调后回调;
+//开启后不再使用bloom预检查跳过区块
+type WithdrawalTxWatcher interface {
+	TxWatcher
+
+	//获取提款地址的匹配方法,为nil时不回调提款
+	GetWithdrawalMatcher() func(address common.Address) (bool, error)
+
+	//提款回调,返回错误时重新扫描该区块
+	OnWithdrawal(withdrawal *WithdrawalInfo) error
+}
+
+//信标链提款信息
+type WithdrawalInfo struct {
+	//提款序号,全链唯一递增
+	Index          uint64
+	ValidatorIndex uint64
+	Address        string
+	//提款金额(gwei)
+	AmountGwei uint64
+	//提款金额(wei)
+	Amount        *big.Int
+	BlockNumber   uint64
+	BlockHash     string
+	BlockUnixSecs uint64
+}
+
+//根据区块内的提款构造提款信息
+func NewWithdrawalInfo(block *Block, withdrawal *types.Withdrawal) *WithdrawalInfo {
+	return &WithdrawalInfo{
+		Index:          withdrawal.Index,
+		ValidatorIndex: withdrawal.Validator,
+		Address:        addressString(withdrawal.Address),
+		AmountGwei:     withdrawal.Amount,
+		Amount:         withdrawalAmount(withdrawal),
+		BlockNumber:    block.Header.Number.Uint64(),
+		BlockHash:      hashString(block.Hash),
+		BlockUnixSecs:  block.Header.Time,
+	}
+}
+
+func withdrawalAmount(withdrawal *types.Withdrawal) *big.Int {
+	return new(big.Int).Mul(new(big.Int).SetUint64(withdrawal.Amount), big.NewInt(params.GWei))
+}
+
+//匹配区块内的提款
+func matchWithdrawals(block *Block, isInterestedWithdrawal func(address common.Address) (bool, error)) ([]*WithdrawalInfo, error) {
+	var withdrawals []*WithdrawalInfo
+	for _, withdrawal := range block.Withdrawals {
+		interested, err := isInterestedWithdrawal(withdrawal.Address)
+		if err != nil {
+			return nil, err
+		}
+		if interested {
+			withdrawals = append(withdrawals, NewWithdrawalInfo(block, withdrawal))
+		}
+	}
+
+	return withdrawals, nil
+}
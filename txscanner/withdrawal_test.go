@@ -0,0 +1,63 @@
+package txscanner_test
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/warrior21st/ethblockscanner/fakechain"
+	"github.com/warrior21st/ethblockscanner/txscanner"
+)
+
+func TestScanTxWithdrawals(t *testing.T) {
+	validatorAddr := fakechain.Address(5)
+	chain := fakechain.NewChain(1)
+	chain.Mine()
+	chain.Transfer(1, validatorAddr, big.NewInt(1))
+	chain.AddWithdrawal(10, validatorAddr, 32000000000)
+	chain.AddWithdrawal(11, fakechain.Address(6), 1000)
+	chain.AddWithdrawal(12, validatorAddr, 15000)
+	chain.Mine()
+
+	var lock sync.Mutex
+	var withdrawals []*txscanner.WithdrawalInfo
+	var changes []*txscanner.BalanceChange
+	recorder := &txRecorder{}
+	watcher, _ := newWatcher(t, chain, 1, recorder)
+	watcher.AddInterestedTo(hexAddress(validatorAddr))
+	//按地址匹配及开启提款回调时,bloom预检查不跳过区块
+	watcher.SetBloomSkipping(true)
+	watcher.SetOnWithdrawal(func(withdrawal *txscanner.WithdrawalInfo) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		withdrawals = append(withdrawals, withdrawal)
+		return nil
+	})
+	watcher.AddBalanceAddress(hexAddress(validatorAddr))
+	watcher.SetOnBalanceChange(func(change *txscanner.BalanceChange) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		changes = append(changes, change)
+		return nil
+	})
+	err := fakechain.ScanTo(txscanner.NewTxScanner(watcher), chain.Head(), scanTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.txs) != 1 || len(withdrawals) != 2 {
+		t.Fatalf("got %d txs, %d withdrawals", len(recorder.txs), len(withdrawals))
+	}
+	first := withdrawals[0]
+	if first.Index != 0 || first.ValidatorIndex != 10 || first.Address != hexAddress(validatorAddr) || first.BlockNumber != 2 ||
+		first.Amount.Cmp(new(big.Int).Mul(big.NewInt(32), big.NewInt(params.Ether))) != 0 || withdrawals[1].Index != 2 {
+		t.Fatalf("withdrawals %+v %+v", first, withdrawals[1])
+	}
+	//提款计入预期余额变化,不再标记为不一致
+	if len(changes) != 1 || changes[0].Mismatch || changes[0].Withdrawals.Cmp(new(big.Int).Add(first.Amount, withdrawals[1].Amount)) != 0 {
+		t.Fatalf("balance changes %+v", changes)
+	}
+}